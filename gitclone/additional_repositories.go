@@ -0,0 +1,256 @@
+package gitclone
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/bitrise-io/go-utils/command/git"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/repourl"
+	"gopkg.in/yaml.v3"
+)
+
+const additionalRepositoryCheckoutFailedTag = "additional_repository_checkout_failed"
+
+const (
+	outputAdditionalRepositoryPrefix = "GIT_CLONE_REPOSITORY_"
+	// outputAdditionalRepositoryDir is the path of the repository, namespaced like its commit details
+	outputAdditionalRepositoryDir = "DIR"
+)
+
+// AdditionalRepository is a repository checked out next to the main repository (for example a shared SDK)
+type AdditionalRepository struct {
+	// Name identifies the repository in the logs and the output names, it defaults to the name of the directory
+	Name              string   `yaml:"name"`
+	URL               string   `yaml:"url"`
+	Dir               string   `yaml:"dir"`
+	Branch            string   `yaml:"branch"`
+	Tag               string   `yaml:"tag"`
+	Commit            string   `yaml:"commit"`
+	CloneDepth        int      `yaml:"depth"`
+	SparseDirectories []string `yaml:"sparse_directories"`
+	UpdateSubmodules  bool     `yaml:"update_submodules"`
+}
+
+// additionalRepositoryResult is the checked out state of an additional repository, used for exporting its outputs
+type additionalRepositoryResult struct {
+	name   string
+	dir    string
+	gitRef string
+	gitCmd git.Git
+}
+
+// ParseAdditionalRepositories parses the YAML (or JSON) list of the additional repositories, for example:
+//
+//   - url: https://github.com/org/sdk.git
+//     dir: ../sdk
+//     tag: 1.2.0
+//     depth: 1
+func ParseAdditionalRepositories(value string) ([]AdditionalRepository, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	decoder := yaml.NewDecoder(strings.NewReader(value))
+	decoder.KnownFields(true)
+	var repos []AdditionalRepository
+	if err := decoder.Decode(&repos); err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	dirs := map[string]string{}
+	for i := range repos {
+		repo := &repos[i]
+		repo.URL = strings.TrimSpace(repo.URL)
+		repo.Dir = strings.TrimSpace(repo.Dir)
+		repo.Branch = strings.TrimSpace(repo.Branch)
+		repo.Tag = strings.TrimSpace(repo.Tag)
+		repo.Commit = strings.TrimSpace(repo.Commit)
+
+		if repo.URL == "" {
+			return nil, fmt.Errorf("repository #%d: url is required", i+1)
+		}
+		if _, err := repourl.Parse(repo.URL); err != nil {
+			return nil, fmt.Errorf("repository #%d: invalid url (%s): %w", i+1, repourl.Redact(repo.URL), err)
+		}
+		if repo.Dir == "" {
+			return nil, fmt.Errorf("repository #%d: dir is required", i+1)
+		}
+		if repo.Branch == "" && repo.Tag == "" && repo.Commit == "" {
+			return nil, fmt.Errorf("repository #%d: one of branch, tag or commit is required", i+1)
+		}
+		if repo.CloneDepth < 0 {
+			return nil, fmt.Errorf("repository #%d: depth can't be negative", i+1)
+		}
+
+		if repo.Name = strings.TrimSpace(repo.Name); repo.Name == "" {
+			repo.Name = filepath.Base(filepath.Clean(repo.Dir))
+		}
+		key := additionalRepositoryOutputKey(repo.Name, "")
+		if other, ok := names[key]; ok {
+			return nil, fmt.Errorf("repository #%d: the outputs of %s would overwrite the outputs of %s, set a different name", i+1, repo.Name, other)
+		}
+		names[key] = repo.Name
+
+		absDir, err := filepath.Abs(repo.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("repository #%d: %w", i+1, err)
+		}
+		if other, ok := dirs[absDir]; ok {
+			return nil, fmt.Errorf("repository #%d: %s is checked out into the same directory as %s", i+1, repo.Name, other)
+		}
+		dirs[absDir] = repo.Name
+	}
+
+	return repos, nil
+}
+
+// additionalRepositoryOutputKey returns the namespaced name of an output of the repository,
+// for example GIT_CLONE_REPOSITORY_SDK_COMMIT_HASH for the GIT_CLONE_COMMIT_HASH output of the sdk repository
+func additionalRepositoryOutputKey(name, outputKey string) string {
+	return outputAdditionalRepositoryPrefix + strings.Map(func(r rune) rune {
+		if r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name) + "_" + strings.TrimPrefix(outputKey, "GIT_CLONE_")
+}
+
+// config returns the checkout configuration of the repository. The clone settings (such as the timeouts and the worktree cache)
+// are inherited from the main repository, the Pull Request and the output settings are not.
+func (r AdditionalRepository) config(main Config) Config {
+	return Config{
+		name:                 r.Name,
+		CloneIntoDir:         r.Dir,
+		CloneDepth:           r.CloneDepth,
+		UpdateSubmodules:     r.UpdateSubmodules,
+		SubmoduleUpdateDepth: main.SubmoduleUpdateDepth,
+		SparseDirectories:    r.SparseDirectories,
+		DeepenSteps:          main.DeepenSteps,
+		CommandTimeouts:      main.CommandTimeouts,
+		LowSpeedLimit:        main.LowSpeedLimit,
+		LowSpeedTime:         main.LowSpeedTime,
+		WorkspaceLockTimeout: main.WorkspaceLockTimeout,
+		WorktreeCacheDir:     main.WorktreeCacheDir,
+		RepositoryURL:        r.URL,
+		Commit:               r.Commit,
+		Tag:                  r.Tag,
+		Branch:               r.Branch,
+		ResetRepository:      main.ResetRepository,
+		RemoteMismatchPolicy: main.RemoteMismatchPolicy,
+		NonEmptyDirPolicy:    main.NonEmptyDirPolicy,
+	}
+}
+
+// checkoutAdditionalRepositories checks out the additional repositories in parallel.
+// All of them are checked out even if some fail, so the errors of every repository are reported at once.
+func (g GitCloner) checkoutAdditionalRepositories(cfg Config) ([]additionalRepositoryResult, error) {
+	repos := cfg.AdditionalRepositories
+
+	g.logger.Println()
+	g.logger.Infof("Checking out %d additional repositories in parallel", len(repos))
+
+	results := make([]additionalRepositoryResult, len(repos))
+	errs := make([]error, len(repos))
+	var wg sync.WaitGroup
+	for i, repo := range repos {
+		wg.Add(1)
+		go func(i int, repo AdditionalRepository) {
+			defer wg.Done()
+
+			// The output of the repositories checked out in parallel is prefixed with their names
+			prefix := fmt.Sprintf("[%s] ", repo.Name)
+			runner.SetOutputPrefix(repo.Dir, prefix)
+			defer runner.SetOutputPrefix(repo.Dir, "")
+			cloner := g
+			cloner.logger = newPrefixedLogger(g.logger, prefix)
+
+			result, err := cloner.checkout(repo.config(cfg))
+			if err != nil {
+				errs[i] = fmt.Errorf("%s (%s): %w", repo.Name, repourl.Redact(repo.URL), err)
+				return
+			}
+			results[i] = additionalRepositoryResult{name: repo.Name, dir: repo.Dir, gitRef: result.gitRef, gitCmd: result.gitCmd}
+		}(i, repo)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, newStepError(
+			additionalRepositoryCheckoutFailedTag,
+			fmt.Errorf("checking out additional repositories failed: %w", err),
+			"Checking out additional repositories failed",
+		)
+	}
+
+	g.logger.Println()
+	for _, result := range results {
+		g.logger.Donef("%s checked out into %s", result.name, result.dir)
+	}
+
+	return results, nil
+}
+
+// prefixedLogger prefixes the messages of the logger, so the logs of the repositories checked out in parallel can be told apart
+type prefixedLogger struct {
+	log.Logger
+	prefix string
+}
+
+func newPrefixedLogger(logger log.Logger, prefix string) prefixedLogger {
+	// The prefix is part of the format string
+	return prefixedLogger{Logger: logger, prefix: strings.ReplaceAll(prefix, "%", "%%")}
+}
+
+func (l prefixedLogger) Infof(format string, v ...interface{}) {
+	l.Logger.Infof(l.prefix+format, v...)
+}
+
+func (l prefixedLogger) Warnf(format string, v ...interface{}) {
+	l.Logger.Warnf(l.prefix+format, v...)
+}
+
+func (l prefixedLogger) Printf(format string, v ...interface{}) {
+	l.Logger.Printf(l.prefix+format, v...)
+}
+
+func (l prefixedLogger) Donef(format string, v ...interface{}) {
+	l.Logger.Donef(l.prefix+format, v...)
+}
+
+func (l prefixedLogger) Debugf(format string, v ...interface{}) {
+	l.Logger.Debugf(l.prefix+format, v...)
+}
+
+func (l prefixedLogger) Errorf(format string, v ...interface{}) {
+	l.Logger.Errorf(l.prefix+format, v...)
+}
+
+func (l prefixedLogger) TInfof(format string, v ...interface{}) {
+	l.Logger.TInfof(l.prefix+format, v...)
+}
+
+func (l prefixedLogger) TWarnf(format string, v ...interface{}) {
+	l.Logger.TWarnf(l.prefix+format, v...)
+}
+
+func (l prefixedLogger) TPrintf(format string, v ...interface{}) {
+	l.Logger.TPrintf(l.prefix+format, v...)
+}
+
+func (l prefixedLogger) TDonef(format string, v ...interface{}) {
+	l.Logger.TDonef(l.prefix+format, v...)
+}
+
+func (l prefixedLogger) TDebugf(format string, v ...interface{}) {
+	l.Logger.TDebugf(l.prefix+format, v...)
+}
+
+func (l prefixedLogger) TErrorf(format string, v ...interface{}) {
+	l.Logger.TErrorf(l.prefix+format, v...)
+}
@@ -0,0 +1,171 @@
+package gitclone
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/tracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAdditionalRepositories(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []AdditionalRepository
+		wantErr string
+	}{
+		{
+			name:  "Empty",
+			value: " \n",
+			want:  nil,
+		},
+		{
+			name: "YAML list",
+			value: `
+- url: https://github.com/bitrise-io/sdk.git
+  dir: ../sdk/
+  tag: 1.2.0
+  depth: 1
+- name: Design assets
+  url: git@github.com:bitrise-io/assets.git
+  dir: ../assets
+  branch: main
+  commit: 76a934ae
+  sparse_directories: [icons, fonts]
+  update_submodules: yes
+`,
+			want: []AdditionalRepository{
+				{Name: "sdk", URL: "https://github.com/bitrise-io/sdk.git", Dir: "../sdk/", Tag: "1.2.0", CloneDepth: 1},
+				{
+					Name: "Design assets", URL: "git@github.com:bitrise-io/assets.git", Dir: "../assets", Branch: "main", Commit: "76a934ae",
+					SparseDirectories: []string{"icons", "fonts"}, UpdateSubmodules: true,
+				},
+			},
+		},
+		{
+			name:  "JSON list",
+			value: `[{"url": "https://github.com/bitrise-io/sdk.git", "dir": "sdk", "branch": "main"}]`,
+			want:  []AdditionalRepository{{Name: "sdk", URL: "https://github.com/bitrise-io/sdk.git", Dir: "sdk", Branch: "main"}},
+		},
+		{
+			name:    "Unknown field",
+			value:   `[{"url": "https://github.com/bitrise-io/sdk.git", "dir": "sdk", "ref": "main"}]`,
+			wantErr: "field ref not found",
+		},
+		{
+			name:    "Missing URL",
+			value:   `[{"dir": "sdk", "branch": "main"}]`,
+			wantErr: "repository #1: url is required",
+		},
+		{
+			name:    "Missing directory",
+			value:   `[{"url": "https://github.com/bitrise-io/sdk.git", "branch": "main"}]`,
+			wantErr: "repository #1: dir is required",
+		},
+		{
+			name:    "Missing ref",
+			value:   `[{"url": "https://github.com/bitrise-io/sdk.git", "dir": "sdk"}]`,
+			wantErr: "repository #1: one of branch, tag or commit is required",
+		},
+		{
+			name:    "Negative depth",
+			value:   `[{"url": "https://github.com/bitrise-io/sdk.git", "dir": "sdk", "branch": "main", "depth": -1}]`,
+			wantErr: "repository #1: depth can't be negative",
+		},
+		{
+			name: "Output names collide",
+			value: `[{"url": "https://github.com/bitrise-io/sdk.git", "dir": "sdk-1", "branch": "main", "name": "my-sdk"},
+				{"url": "https://github.com/bitrise-io/sdk.git", "dir": "sdk-2", "branch": "main", "name": "my_sdk"}]`,
+			wantErr: "repository #2: the outputs of my_sdk would overwrite the outputs of my-sdk, set a different name",
+		},
+		{
+			name: "Same directory",
+			value: `[{"url": "https://github.com/bitrise-io/sdk.git", "dir": "libs/sdk", "branch": "main", "name": "sdk"},
+				{"url": "https://github.com/bitrise-io/sdk.git", "dir": "./libs/sdk/", "branch": "main", "name": "other"}]`,
+			wantErr: "repository #2: other is checked out into the same directory as sdk",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAdditionalRepositories(tt.value)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_additionalRepositoryOutputKey(t *testing.T) {
+	assert.Equal(t, "GIT_CLONE_REPOSITORY_DESIGN_ASSETS_COMMIT_HASH", additionalRepositoryOutputKey("Design assets", "GIT_CLONE_COMMIT_HASH"))
+	assert.Equal(t, "GIT_CLONE_REPOSITORY_SDK_DIR", additionalRepositoryOutputKey("sdk", outputAdditionalRepositoryDir))
+}
+
+func Test_checkoutAdditionalRepositories(t *testing.T) {
+	runner = &DefaultRunner{}
+
+	root := t.TempDir()
+	runGit := func(dir string, args ...string) string {
+		args = append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		out, err := exec.Command("git", args...).CombinedOutput()
+		require.NoError(t, err, string(out))
+		return strings.TrimSpace(string(out))
+	}
+	givenRemote := func(name string) string {
+		dir := filepath.Join(root, "remotes", name)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		runGit(dir, "init", "-q", "--initial-branch=main")
+		runGit(dir, "commit", "-q", "--allow-empty", "-m", "Release "+name)
+		runGit(dir, "tag", "1.0.0")
+		runGit(dir, "commit", "-q", "--allow-empty", "-m", "Next "+name)
+		return dir
+	}
+	sdkRemote := givenRemote("sdk")
+	assetsRemote := givenRemote("assets")
+
+	cfg := Config{
+		AdditionalRepositories: []AdditionalRepository{
+			{Name: "sdk", URL: sdkRemote, Dir: filepath.Join(root, "sdk"), Tag: "1.0.0"},
+			{Name: "assets", URL: assetsRemote, Dir: filepath.Join(root, "assets"), Branch: "main"},
+		},
+	}
+	envRepo := env.NewRepository()
+	cloner := NewGitCloner(log.NewLogger(), tracker.NewStepTracker(envRepo, log.NewLogger()), command.NewFactory(envRepo), nil, nil, false)
+
+	// When
+	results, err := cloner.checkoutAdditionalRepositories(cfg)
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "sdk", results[0].name)
+	assert.Equal(t, runGit(sdkRemote, "rev-parse", "1.0.0^{commit}"), runGit(results[0].dir, "rev-parse", "HEAD"))
+	assert.Equal(t, "assets", results[1].name)
+	assert.Equal(t, runGit(assetsRemote, "rev-parse", "main"), runGit(results[1].dir, "rev-parse", "HEAD"))
+
+	t.Run("Reports the failures of every repository", func(t *testing.T) {
+		cfg := Config{
+			AdditionalRepositories: []AdditionalRepository{
+				{Name: "missing-tag", URL: sdkRemote, Dir: filepath.Join(root, "missing-tag"), Tag: "9.9.9"},
+				{Name: "missing-branch", URL: assetsRemote, Dir: filepath.Join(root, "missing-branch"), Branch: "release"},
+			},
+		}
+
+		_, err := cloner.checkoutAdditionalRepositories(cfg)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "missing-tag ("+sdkRemote+")")
+		assert.Contains(t, err.Error(), "missing-branch ("+assetsRemote+")")
+	})
+}
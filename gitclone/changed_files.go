@@ -0,0 +1,168 @@
+package gitclone
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command/git"
+)
+
+// changedFiles holds the files changed between the base and HEAD
+type changedFiles struct {
+	base  string
+	files []changedFile
+	// matchesPatterns is true if any of the changed paths matches the configured patterns
+	// (or if there are any changes at all when no pattern is configured)
+	matchesPatterns bool
+}
+
+type changedFile struct {
+	Status string `json:"status"`
+	Path   string `json:"path"`
+	// PreviousPath is only set for renamed files
+	PreviousPath string `json:"previous_path,omitempty"`
+}
+
+const (
+	changeStatusAdded    = "added"
+	changeStatusModified = "modified"
+	changeStatusDeleted  = "deleted"
+	changeStatusRenamed  = "renamed"
+)
+
+func listChangedFiles(gitCmd git.Git, base string, patterns []string) (*changedFiles, error) {
+	// -z disables the quoting of unusual paths, the fields are NUL separated instead
+	out, err := runner.RunForOutput(gitCommand(gitCmd, "diff", "--name-status", "-z", "-M", base, "HEAD"))
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := parseNameStatus(out)
+	if err != nil {
+		return nil, err
+	}
+
+	return &changedFiles{
+		base:            base,
+		files:           files,
+		matchesPatterns: anyChangeMatches(files, patterns),
+	}, nil
+}
+
+// parseNameStatus parses the output of `git diff --name-status -z`, for example:
+//
+//	M\x00README.md\x00R087\x00old/path.go\x00new/path.go\x00
+func parseNameStatus(out string) ([]changedFile, error) {
+	files := []changedFile{}
+	fields := strings.Split(strings.TrimRight(out, "\x00"), "\x00")
+	for i := 0; i < len(fields); i++ {
+		status := strings.TrimSpace(fields[i])
+		if status == "" {
+			continue
+		}
+
+		var file changedFile
+		switch status[0] {
+		case 'A', 'C':
+			file.Status = changeStatusAdded
+		case 'M', 'T':
+			file.Status = changeStatusModified
+		case 'D':
+			file.Status = changeStatusDeleted
+		case 'R':
+			file.Status = changeStatusRenamed
+		default:
+			return nil, fmt.Errorf("unexpected change status: %s", status)
+		}
+
+		// Renames and copies have a source and a destination path
+		if status[0] == 'R' || status[0] == 'C' {
+			if i+2 >= len(fields) {
+				return nil, fmt.Errorf("missing paths for change status: %s", status)
+			}
+			if status[0] == 'R' {
+				file.PreviousPath = fields[i+1]
+			}
+			file.Path = fields[i+2]
+			i += 2
+		} else {
+			if i+1 >= len(fields) {
+				return nil, fmt.Errorf("missing path for change status: %s", status)
+			}
+			file.Path = fields[i+1]
+			i++
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// compactChangedFiles formats the changes similar to `git diff --name-status`: one change per line,
+// with the status letter and the path(s) separated by tabs.
+func compactChangedFiles(files []changedFile) string {
+	var lines []string
+	for _, file := range files {
+		status := strings.ToUpper(file.Status[:1])
+		if file.PreviousPath != "" {
+			lines = append(lines, fmt.Sprintf("%s\t%s\t%s", status, file.PreviousPath, file.Path))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s\t%s", status, file.Path))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func anyChangeMatches(files []changedFile, patterns []string) bool {
+	if len(patterns) == 0 {
+		return len(files) > 0
+	}
+
+	for _, file := range files {
+		for _, pattern := range patterns {
+			// A renamed file affects both its old and its new location
+			if matchPathPattern(pattern, file.Path) || (file.PreviousPath != "" && matchPathPattern(pattern, file.PreviousPath)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matchPathPattern reports whether the slash separated path or any of its parent directories matches the pattern.
+// The pattern segments are matched with path.Match, `**` matches any number of directories.
+// For example `src/android` matches `src/android/app/build.gradle` and `**/*.kt` matches `app/src/Main.kt`.
+func matchPathPattern(pattern, filePath string) bool {
+	pattern = strings.Trim(strings.TrimSpace(pattern), "/")
+	if pattern == "" {
+		return false
+	}
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(filePath, "/"))
+}
+
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		// The remaining segments are the contents of a matching directory
+		return true
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+
+	if ok, err := path.Match(pattern[0], segments[0]); err != nil || !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
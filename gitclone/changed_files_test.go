@@ -0,0 +1,71 @@
+package gitclone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseNameStatus(t *testing.T) {
+	out := "M\x00README.md\x00A\x00src/android/app/Main.kt\x00D\x00old file.txt\x00R087\x00ios/Old.swift\x00ios/New.swift\x00T\x00link\x00"
+
+	got, err := parseNameStatus(out)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []changedFile{
+		{Status: changeStatusModified, Path: "README.md"},
+		{Status: changeStatusAdded, Path: "src/android/app/Main.kt"},
+		{Status: changeStatusDeleted, Path: "old file.txt"},
+		{Status: changeStatusRenamed, Path: "ios/New.swift", PreviousPath: "ios/Old.swift"},
+		{Status: changeStatusModified, Path: "link"},
+	}, got)
+	assert.Equal(t, "M\tREADME.md\nA\tsrc/android/app/Main.kt\nD\told file.txt\nR\tios/Old.swift\tios/New.swift\nM\tlink", compactChangedFiles(got))
+
+	got, err = parseNameStatus("")
+	assert.NoError(t, err)
+	assert.Equal(t, []changedFile{}, got)
+
+	_, err = parseNameStatus("R100\x00only-one-path\x00")
+	assert.Error(t, err)
+}
+
+func Test_matchPathPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "src/android", path: "src/android/app/build.gradle", want: true},
+		{pattern: "src/android/", path: "src/android/app/build.gradle", want: true},
+		{pattern: "src/android", path: "src/android-tools/build.gradle", want: false},
+		{pattern: "src/ios", path: "src/android/app/build.gradle", want: false},
+		{pattern: "*.md", path: "README.md", want: true},
+		{pattern: "*.md", path: "docs/README.md", want: false},
+		{pattern: "**/*.md", path: "docs/README.md", want: true},
+		{pattern: "**/*.md", path: "README.md", want: true},
+		{pattern: "src/**/test", path: "src/a/b/test/Test.kt", want: true},
+		{pattern: "src/**/test", path: "src/test/Test.kt", want: true},
+		{pattern: "src/*/test", path: "src/a/b/test/Test.kt", want: false},
+		{pattern: "**", path: "anything/at/all", want: true},
+		{pattern: "", path: "README.md", want: false},
+		{pattern: "[", path: "README.md", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchPathPattern(tt.pattern, tt.path))
+		})
+	}
+}
+
+func Test_anyChangeMatches(t *testing.T) {
+	files := []changedFile{
+		{Status: changeStatusModified, Path: "README.md"},
+		{Status: changeStatusRenamed, Path: "shared/New.kt", PreviousPath: "android/Old.kt"},
+	}
+
+	assert.True(t, anyChangeMatches(files, nil))
+	assert.False(t, anyChangeMatches(nil, nil))
+	assert.True(t, anyChangeMatches(files, []string{"ios", "android"}))
+	assert.True(t, anyChangeMatches(files, []string{"shared"}))
+	assert.False(t, anyChangeMatches(files, []string{"ios", "docs/**"}))
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/bitrise-io/go-utils/command/git"
 	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-steplib/steps-git-clone/gitclone/bitriseapi"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/repourl"
 )
 
 // CheckoutMethod is the checkout method used
@@ -45,7 +46,11 @@ const (
 	CheckoutForkCommitMethod
 )
 
-const privateForkAuthWarning = `May fail due to missing authentication as Pull Request opened from a private fork.
+// defaultCloneDepth is used if the clone depth is not set. A shallow clone is enough for every method,
+// as the manual merge strategy deepens both branches until their merge base is fetched.
+const defaultCloneDepth = 1
+
+const privateForkAuthWarning = `May fail due to missing authentication as the source repository (fork) of the Pull Request is not accessible.
 A git hosting provider head branch or a diff file is unavailable.`
 
 // ParameterValidationError is returned when there is missing or malformed parameter for a given parameter set
@@ -72,6 +77,16 @@ type checkoutStrategy interface {
 	// However, a PR checkout strategy may create a (temporary) merge commit, so the merged state can be tested.
 	// In this case the returned ref will point to the Source branch (or a commit on the Source branch).
 	getBuildTriggerRef() string
+
+	// fetchTargets returns the refs fetched by the strategy (after running 'do'), their history is deepened
+	// if the commits needed for the outputs are not part of the shallow history.
+	fetchTargets() []fetchTarget
+}
+
+// depthReporter is implemented by the checkout strategies which deepen the shallow history as far as needed,
+// requiredDepth returns the clone depth the checkout needed (after running 'do'), 0 if unknown
+type depthReporter interface {
+	requiredDepth() int
 }
 
 // X: required parameter
@@ -89,7 +104,7 @@ type checkoutStrategy interface {
 // | headBranch  |        |     |        |          |  X         |           |
 // |=========================================================================|
 
-func selectCheckoutMethod(cfg Config, patchSource bitriseapi.PatchSource, mergeRefChecker bitriseapi.MergeRefChecker) (CheckoutMethod, string) {
+func selectCheckoutMethod(cfg Config, patchSource bitriseapi.PatchSource, mergeRefChecker bitriseapi.MergeRefChecker, forkProbe forkAccessProbe) (CheckoutMethod, string) {
 	isPR := cfg.PRSourceRepositoryURL != "" || cfg.PRDestBranch != "" || cfg.PRMergeRef != "" || cfg.PRUnverifiedMergeRef != ""
 	if !isPR {
 		if cfg.Commit != "" {
@@ -108,8 +123,6 @@ func selectCheckoutMethod(cfg Config, patchSource bitriseapi.PatchSource, mergeR
 	}
 
 	isFork := isFork(cfg.RepositoryURL, cfg.PRSourceRepositoryURL)
-	isPrivateSourceRepo := isPrivate(cfg.PRSourceRepositoryURL)
-	isPublicFork := isFork && !isPrivateSourceRepo
 
 	// PR: check out the head of the PR branch
 	if !cfg.ShouldMergePR {
@@ -124,10 +137,15 @@ func selectCheckoutMethod(cfg Config, patchSource bitriseapi.PatchSource, mergeR
 			return CheckoutCommitMethod, ""
 		}
 
-		if isPublicFork {
-			// Even though it's not an MR, we can access the source branch
+		log.Printf("\n")
+		log.Infof("Checking if the source repository of the Pull Request is accessible...")
+		err := forkProbe(cfg.PRSourceRepositoryURL, cfg.Branch)
+		if err == nil {
+			// Even though it's not an MR, we can access the source branch (public fork, or private fork with access)
+			log.Printf("Source repository (%s) is accessible, checking out the commit from the fork", repourl.Redact(cfg.PRSourceRepositoryURL))
 			return CheckoutForkCommitMethod, ""
 		}
+		log.Printf("Source repository (%s) is not accessible: %v", repourl.Redact(cfg.PRSourceRepositoryURL), err)
 
 		// Fallback (Bitbucket only): it's a PR from a fork we can't access, so we fetch the PR patch file through
 		// the API and apply the diff manually
@@ -208,6 +226,7 @@ func createCheckoutStrategy(checkoutMethod CheckoutMethod, cfg Config, patchFile
 
 			return checkoutCommit{
 				params: *params,
+				refs:   cfg.tempRefs,
 			}, nil
 		}
 	case CheckoutTagMethod:
@@ -230,6 +249,7 @@ func createCheckoutStrategy(checkoutMethod CheckoutMethod, cfg Config, patchFile
 
 			return checkoutBranch{
 				params: *params,
+				refs:   cfg.tempRefs,
 			}, nil
 		}
 	case CheckoutPRMergeBranchMethod:
@@ -250,18 +270,19 @@ func createCheckoutStrategy(checkoutMethod CheckoutMethod, cfg Config, patchFile
 
 			return checkoutPRMergeRef{
 				params: *params,
+				refs:   cfg.tempRefs,
 				fallbackCheckout: func(gitCmd git.Git) error {
 					log.Warnf("Using manual merge strategy with PR source branch")
 
-					manualMergeFallbackFetchOpts := selectFetchOptions(CheckoutPRManualMergeMethod, cfg.CloneDepth, cfg.FetchTags, cfg.UpdateSubmodules, len(cfg.SparseDirectories) != 0)
-					manualMergeFallbackFallback := selectFallbacks(CheckoutPRManualMergeMethod, manualMergeFallbackFetchOpts)
+					manualMergeFallbackFetchOpts := selectFetchOptions(CheckoutPRManualMergeMethod, cfg.CloneDepth, newHistoryLimit(cfg), cfg.FetchTags, cfg.UpdateSubmodules, len(cfg.SparseDirectories) != 0)
+					manualMergeFallbackFallback := selectFallbacks(CheckoutPRManualMergeMethod, manualMergeFallbackFetchOpts, newDeepenOptions(cfg))
 
 					prRepositoryURL := ""
 					if isFork(cfg.RepositoryURL, cfg.PRSourceRepositoryURL) {
 						prRepositoryURL = cfg.PRSourceRepositoryURL
 					}
 
-					fallbackManualMergeWithSourceBranch, err := createManualMergeFallbackFunc(prRepositoryURL, cfg.Branch, cfg.Commit, cfg.PRDestBranch)
+					fallbackManualMergeWithSourceBranch, err := createManualMergeFallbackFunc(prRepositoryURL, cfg.Branch, cfg.Commit, cfg.PRDestBranch, cfg.tempRefs)
 					if err != nil {
 						return err
 					}
@@ -301,7 +322,9 @@ func createCheckoutStrategy(checkoutMethod CheckoutMethod, cfg Config, patchFile
 			}
 
 			return checkoutPRManualMerge{
-				params: *params,
+				params:     *params,
+				refs:       cfg.tempRefs,
+				mergeDepth: new(int),
 			}, nil
 		}
 	case CheckoutHeadBranchCommitMethod:
@@ -314,6 +337,7 @@ func createCheckoutStrategy(checkoutMethod CheckoutMethod, cfg Config, patchFile
 
 			return checkoutCommit{
 				params: *params,
+				refs:   cfg.tempRefs,
 				fallbackCheckout: func(gitCmd git.Git) error {
 					log.Warnf("Using commit checkout strategy with PR source branch")
 
@@ -322,8 +346,8 @@ func createCheckoutStrategy(checkoutMethod CheckoutMethod, cfg Config, patchFile
 					}
 
 					branchRef := refsHeadsPrefix + cfg.Branch
-					commitCheckoutFallbackFetchOpts := selectFetchOptions(CheckoutCommitMethod, cfg.CloneDepth, cfg.FetchTags, cfg.UpdateSubmodules, len(cfg.SparseDirectories) != 0)
-					commitCheckoutFallbackFallback := selectFallbacks(CheckoutCommitMethod, commitCheckoutFallbackFetchOpts)
+					commitCheckoutFallbackFetchOpts := selectFetchOptions(CheckoutCommitMethod, cfg.CloneDepth, newHistoryLimit(cfg), cfg.FetchTags, cfg.UpdateSubmodules, len(cfg.SparseDirectories) != 0)
+					commitCheckoutFallbackFallback := selectFallbacks(CheckoutCommitMethod, commitCheckoutFallbackFetchOpts, newDeepenOptions(cfg))
 
 					prRepositoryURL := ""
 					if isFork(cfg.RepositoryURL, cfg.PRSourceRepositoryURL) {
@@ -337,6 +361,7 @@ func createCheckoutStrategy(checkoutMethod CheckoutMethod, cfg Config, patchFile
 
 					commitCheckoutFallbackCheckoutMethod := checkoutCommit{
 						params: *params,
+						refs:   cfg.tempRefs,
 					}
 
 					return commitCheckoutFallbackCheckoutMethod.do(gitCmd, commitCheckoutFallbackFetchOpts, commitCheckoutFallbackFallback)
@@ -353,6 +378,7 @@ func createCheckoutStrategy(checkoutMethod CheckoutMethod, cfg Config, patchFile
 
 			return checkoutCommit{
 				params: *params,
+				refs:   cfg.tempRefs,
 			}, nil
 		}
 	default:
@@ -360,19 +386,25 @@ func createCheckoutStrategy(checkoutMethod CheckoutMethod, cfg Config, patchFile
 	}
 }
 
-func selectFetchOptions(method CheckoutMethod, cloneDepth int, fetchTags, fetchSubmodules bool, filterTree bool) fetchOptions {
-	// If cloneDepth is 0, that means the user did not set a value for it,
-	// so we will determine the correct value based on the checkout method.
-	if cloneDepth == 0 {
-		cloneDepth = idealDefaultCloneDepth(method)
-	}
-
+func selectFetchOptions(method CheckoutMethod, cloneDepth int, limit historyLimit, fetchTags, fetchSubmodules bool, filterTree bool) fetchOptions {
 	opts := fetchOptions{
-		limitDepth:      cloneDepth > 0,
-		depth:           cloneDepth,
 		tags:            fetchTags,
 		fetchSubmodules: fetchSubmodules,
 	}
+
+	if limit.since != "" || len(limit.exclude) > 0 {
+		// The date or ref based limit replaces the depth limit, git doesn't allow combining them
+		opts.shallowSince = limit.since
+		opts.shallowExclude = limit.exclude
+	} else {
+		// If cloneDepth is 0, that means the user did not set a value for it
+		if cloneDepth == 0 {
+			cloneDepth = defaultCloneDepth
+		}
+
+		opts.limitDepth = cloneDepth > 0
+		opts.depth = cloneDepth
+	}
 	opts = selectFilterTreeFetchOption(method, opts, filterTree)
 
 	return opts
@@ -405,18 +437,7 @@ func selectFilterTreeFetchOption(method CheckoutMethod, opts fetchOptions, filte
 	}
 }
 
-func idealDefaultCloneDepth(method CheckoutMethod) int {
-	const defaultCloneDepth = 50
-	const shallowCloneDepth = 1
-
-	if method == CheckoutPRManualMergeMethod {
-		return defaultCloneDepth
-	} else {
-		return shallowCloneDepth
-	}
-}
-
-func selectFallbacks(method CheckoutMethod, fetchOpts fetchOptions) fallbackRetry {
+func selectFallbacks(method CheckoutMethod, fetchOpts fetchOptions, deepenOpts deepenOptions) fallbackRetry {
 	if fetchOpts.IsFullDepth() {
 		return nil
 	}
@@ -424,7 +445,10 @@ func selectFallbacks(method CheckoutMethod, fetchOpts fetchOptions) fallbackRetr
 	unshallowFetchOpts := unshallowFetchOptions{
 		tags:            fetchOpts.tags,
 		fetchSubmodules: fetchOpts.fetchSubmodules,
+		forceUpdate:     fetchOpts.forceUpdate,
+		limit:           historyLimit{since: fetchOpts.shallowSince, exclude: fetchOpts.shallowExclude},
 	}
+	incrementalDeepenEnabled := len(deepenOpts.steps) > 0
 
 	switch method {
 	case CheckoutNoneMethod,
@@ -438,6 +462,12 @@ func selectFallbacks(method CheckoutMethod, fetchOpts fetchOptions) fallbackRetr
 		CheckoutHeadBranchCommitMethod,
 		CheckoutForkCommitMethod:
 		{
+			if incrementalDeepenEnabled {
+				return incrementalDeepen{
+					traits:  unshallowFetchOpts,
+					options: deepenOpts,
+				}
+			}
 			return simpleUnshallow{
 				traits: unshallowFetchOpts,
 			}
@@ -445,6 +475,13 @@ func selectFallbacks(method CheckoutMethod, fetchOpts fetchOptions) fallbackRetr
 	case CheckoutPRManualMergeMethod,
 		CheckoutPRDiffFileMethod:
 		{
+			if incrementalDeepenEnabled {
+				return incrementalDeepen{
+					traits:  unshallowFetchOpts,
+					options: deepenOpts,
+					reset:   true,
+				}
+			}
 			return resetUnshallow{
 				traits: unshallowFetchOpts,
 			}
@@ -454,6 +491,20 @@ func selectFallbacks(method CheckoutMethod, fetchOpts fetchOptions) fallbackRetr
 	}
 }
 
+func newHistoryLimit(cfg Config) historyLimit {
+	return historyLimit{
+		since:   cfg.ShallowSince,
+		exclude: cfg.ShallowExclude,
+	}
+}
+
+func newDeepenOptions(cfg Config) deepenOptions {
+	return deepenOptions{
+		gitDir: cfg.commonGitDir(),
+		steps:  cfg.DeepenSteps,
+	}
+}
+
 func isPRCheckout(method CheckoutMethod) bool {
 	switch method {
 	case CheckoutNoneMethod,
@@ -476,12 +527,13 @@ func isPRCheckout(method CheckoutMethod) bool {
 	}
 }
 
-func createManualMergeFallbackFunc(repositoryURL, branch, commit, prDestBranch string) (*checkoutPRManualMerge, error) {
+func createManualMergeFallbackFunc(repositoryURL, branch, commit, prDestBranch string, refs tempRefs) (*checkoutPRManualMerge, error) {
 	manualMergeFallbackParams, err := NewPRManualMergeParams(branch, commit, repositoryURL, prDestBranch)
 	if err != nil {
 		return nil, err
 	}
 	return &checkoutPRManualMerge{
 		params: *manualMergeFallbackParams,
+		refs:   refs,
 	}, nil
 }
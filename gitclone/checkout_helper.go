@@ -20,9 +20,19 @@ type fetchOptions struct {
 	// More info: https://git-scm.com/docs/fetch-options/2.29.0#Documentation/fetch-options.txt---depthltdepthgt
 	limitDepth bool
 	depth      int
+	// Sets the '--shallow-since' and '--shallow-exclude' flags, these replace '--depth' as git doesn't allow combining them
+	// More info:
+	// - https://git-scm.com/docs/git-fetch#Documentation/git-fetch.txt---shallow-sinceltdategt
+	// - https://git-scm.com/docs/git-fetch#Documentation/git-fetch.txt---shallow-excludeltrevisiongt
+	shallowSince   string
+	shallowExclude []string
 	// Sets '--no-recurse-submodules' flag
 	// More info: https://git-scm.com/docs/git-fetch#Documentation/git-fetch.txt---no-recurse-submodules
 	fetchSubmodules bool
+	// Sets '--force' flag, so the tags moved on the remote since a previous build (in a reused clone directory)
+	// are updated instead of being rejected
+	// More info: https://git-scm.com/docs/git-fetch#Documentation/git-fetch.txt---force
+	forceUpdate bool
 	// Sets `--filter=tree:0` flag
 	// More info: https://github.blog/2020-12-21-get-up-to-speed-with-partial-clone-and-shallow-clone/#user-content-treeless-clones
 	filterTree bool
@@ -30,9 +40,40 @@ type fetchOptions struct {
 
 // TODO
 func (t fetchOptions) IsFullDepth() bool {
-	return t.depth == 0
+	return t.depth == 0 && !t.isHistoryLimited()
+}
+
+func (t fetchOptions) isHistoryLimited() bool {
+	return t.shallowSince != "" || len(t.shallowExclude) > 0
+}
+
+// historyLimit bounds the fetched history by a date or by refs, instead of a number of commits
+type historyLimit struct {
+	// since is a date accepted by git, for example 2024-01-31 or "30 days ago"
+	since string
+	// exclude are the refs (for example the last release tag) whose history is not fetched
+	exclude []string
+}
+
+func (l historyLimit) isSet() bool {
+	return l.since != "" || len(l.exclude) > 0
+}
+
+// fetchFlags returns the '--shallow-since' and '--shallow-exclude' flags of the limit
+func (l historyLimit) fetchFlags() []string {
+	var flags []string
+	if l.since != "" {
+		flags = append(flags, "--shallow-since="+l.since)
+	}
+	for _, exclude := range l.exclude {
+		flags = append(flags, "--shallow-exclude="+exclude)
+	}
+	return flags
 }
 
+// The error of a shallow-since or shallow-exclude fetch if the fetched ref itself is outside of the requested history
+const noShallowCommitsSelectedError = "no commits selected for shallow requests"
+
 const (
 	refsPrefix      = "refs/"
 	refsHeadsPrefix = "refs/heads/"
@@ -45,6 +86,12 @@ func fetch(gitCmd git.Git, remote string, ref string, options fetchOptions) erro
 	if options.limitDepth {
 		opts = append(opts, fmt.Sprintf("--depth=%d", options.depth))
 	}
+	if options.shallowSince != "" {
+		opts = append(opts, "--shallow-since="+options.shallowSince)
+	}
+	for _, exclude := range options.shallowExclude {
+		opts = append(opts, "--shallow-exclude="+exclude)
+	}
 	if options.filterTree {
 		opts = append(opts, `--filter=tree:0`)
 	}
@@ -54,6 +101,9 @@ func fetch(gitCmd git.Git, remote string, ref string, options fetchOptions) erro
 	} else {
 		opts = append(opts, "--no-tags")
 	}
+	if options.forceUpdate {
+		opts = append(opts, "--force")
+	}
 	if !options.fetchSubmodules {
 		opts = append(opts, "--no-recurse-submodules")
 	}
@@ -70,6 +120,22 @@ func fetch(gitCmd git.Git, remote string, ref string, options fetchOptions) erro
 	if err := runner.RunWithRetry(func() *command.Model {
 		return gitCmd.Fetch(opts...)
 	}); err != nil {
+		if options.isHistoryLimited() && strings.Contains(err.Error(), noShallowCommitsSelectedError) {
+			log.Warnf("%s is outside of the requested history, fetching only its latest commit", ref)
+
+			tipOptions := options
+			tipOptions.shallowSince, tipOptions.shallowExclude = "", nil
+			tipOptions.limitDepth, tipOptions.depth = true, 1
+			return fetch(gitCmd, remote, ref, tipOptions)
+		}
+		if isCommandTimeoutError(err) {
+			return newStepError(
+				commandTimedOutTag,
+				err,
+				"Fetching repository has timed out",
+			)
+		}
+
 		return handleCheckoutError(
 			listBranches(gitCmd),
 			fetchFailedTag,
@@ -86,11 +152,9 @@ func checkoutWithCustomRetry(gitCmd git.Git, arg string, retry fallbackRetry) er
 	if cErr := runner.Run(gitCmd.Checkout(arg)); cErr != nil {
 		if retry != nil {
 			log.Warnf("Checkout failed (%s): %v", arg, cErr)
-			if err := retry.do(gitCmd); err != nil {
-				return err
-			}
-
-			return runner.Run(gitCmd.Checkout(arg))
+			return retry.do(gitCmd, func() error {
+				return runner.Run(gitCmd.Checkout(arg))
+			})
 		}
 
 		return fmt.Errorf("checkout failed (%s): %w", arg, cErr)
@@ -99,7 +163,8 @@ func checkoutWithCustomRetry(gitCmd git.Git, arg string, retry fallbackRetry) er
 	return nil
 }
 
-func forceCheckoutRemoteBranch(gitCmd git.Git, remote string, branchRef string, fetchTraits fetchOptions) error {
+// forceCheckoutRemoteBranch fetches the branch of the remote and checks it out as localBranch
+func forceCheckoutRemoteBranch(gitCmd git.Git, remote string, branchRef string, localBranch string, fetchTraits fetchOptions) error {
 	branch := strings.TrimPrefix(branchRef, refsHeadsPrefix)
 	if err := fetch(gitCmd, remote, branchRef, fetchTraits); err != nil {
 		wErr := fmt.Errorf("fetch branch %s: %w", branchRef, err)
@@ -110,7 +175,7 @@ func forceCheckoutRemoteBranch(gitCmd git.Git, remote string, branchRef string,
 	// -B: create the branch if it doesn't exist, reset if it does
 	// The latter is important in persistent environments because shallow-fetching only fetches 1 commit,
 	// so the next run would see unrelated histories after shallow-fetching another single commit.
-	err := runner.Run(gitCmd.Checkout("-B", branch, remoteBranch))
+	err := runner.Run(gitCmd.Checkout("-B", localBranch, remoteBranch))
 	if err != nil {
 		return handleCheckoutError(
 			listBranches(gitCmd),
@@ -128,11 +193,9 @@ func mergeWithCustomRetry(gitCmd git.Git, arg string, retry fallbackRetry) error
 	if mErr := runner.Run(gitCmd.Merge(arg)); mErr != nil {
 		if retry != nil {
 			log.Warnf("Merge failed (%s): %v", arg, mErr)
-			if err := retry.do(gitCmd); err != nil {
-				return err
-			}
-
-			return runner.Run(gitCmd.Merge(arg))
+			return retry.do(gitCmd, func() error {
+				return runner.Run(gitCmd.Merge(arg))
+			})
 		}
 
 		wErr := fmt.Errorf("merge failed (%s): %w", arg, mErr)
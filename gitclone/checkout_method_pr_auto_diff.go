@@ -41,7 +41,7 @@ func (c checkoutPRDiffFile) do(gitCmd git.Git, fetchOptions fetchOptions, fallba
 		return fmt.Errorf("failed to fetch base branch: %w", err)
 	}
 
-	if err := checkoutWithCustomRetry(gitCmd, c.params.DestinationBranch, fallback); err != nil {
+	if err := checkoutWithCustomRetry(gitCmd, c.params.DestinationBranch, withDeepenTargets(fallback, c.fetchTargets()...)); err != nil {
 		return err
 	}
 
@@ -62,3 +62,15 @@ func (c checkoutPRDiffFile) do(gitCmd git.Git, fetchOptions fetchOptions, fallba
 func (c checkoutPRDiffFile) getBuildTriggerRef() string {
 	return ""
 }
+
+// requiredDepth returns the depth needed by the manual merge, if applying the diff failed and the strategy fell back to it
+func (c checkoutPRDiffFile) requiredDepth() int {
+	if manualMerge, ok := c.params.PRManualMergeStrategy.(depthReporter); ok {
+		return manualMerge.requiredDepth()
+	}
+	return 0
+}
+
+func (c checkoutPRDiffFile) fetchTargets() []fetchTarget {
+	return []fetchTarget{{remote: originRemoteName, ref: refsHeadsPrefix + c.params.DestinationBranch}}
+}
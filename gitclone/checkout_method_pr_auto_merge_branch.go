@@ -36,6 +36,7 @@ func NewPRMergeRefParams(mergeRef, headRef string) (*PRMergeRefParams, error) {
 type checkoutPRMergeRef struct {
 	params           PRMergeRefParams
 	fallbackCheckout fallbackCheckoutFunc
+	refs             tempRefs
 }
 
 type fallbackCheckoutFunc func(gitCmd git.Git) error
@@ -100,8 +101,12 @@ func (c checkoutPRMergeRef) getBuildTriggerRef() string {
 	return c.localHeadRef()
 }
 
+func (c checkoutPRMergeRef) fetchTargets() []fetchTarget {
+	return []fetchTarget{{remote: originRemoteName, ref: c.remoteMergeRef()}, {remote: originRemoteName, ref: c.remoteHeadRef()}}
+}
+
 func (c checkoutPRMergeRef) localMergeRef() string {
-	return fmt.Sprintf("refs/remotes/%s", c.params.MergeRef)
+	return c.refs.remoteRef(c.params.MergeRef)
 }
 
 func (c checkoutPRMergeRef) remoteMergeRef() string {
@@ -109,7 +114,7 @@ func (c checkoutPRMergeRef) remoteMergeRef() string {
 }
 
 func (c checkoutPRMergeRef) localHeadRef() string {
-	return fmt.Sprintf("refs/remotes/%s", c.params.HeadRef)
+	return c.refs.remoteRef(c.params.HeadRef)
 }
 
 func (c checkoutPRMergeRef) remoteHeadRef() string {
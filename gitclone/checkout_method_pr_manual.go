@@ -6,6 +6,7 @@ import (
 
 	"github.com/bitrise-io/go-utils/command/git"
 	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/repourl"
 )
 
 // PRManualMergeParams are parameters to check out a Merge Request using manual merge
@@ -56,12 +57,24 @@ func validatePRManualMergeParams(sourceBranch, commit, sourceRepoURL, destBranch
 
 type checkoutPRManualMerge struct {
 	params PRManualMergeParams
+	refs   tempRefs
+	// mergeDepth records the clone depth the merge base was found with (if set)
+	mergeDepth *int
+}
+
+// requiredDepth returns the clone depth both branches had to be fetched with to find their merge base,
+// 0 if the depth is not limited or the merge base was not searched for
+func (c checkoutPRManualMerge) requiredDepth() int {
+	if c.mergeDepth == nil {
+		return 0
+	}
+	return *c.mergeDepth
 }
 
 func (c checkoutPRManualMerge) do(gitCmd git.Git, fetchOptions fetchOptions, fallback fallbackRetry) error {
 	// Fetch and checkout destinations branch
 	destBranchRef := refsHeadsPrefix + c.params.DestinationBranch
-	if err := forceCheckoutRemoteBranch(gitCmd, originRemoteName, destBranchRef, fetchOptions); err != nil {
+	if err := forceCheckoutRemoteBranch(gitCmd, originRemoteName, destBranchRef, c.refs.branch(c.params.DestinationBranch), fetchOptions); err != nil {
 		return fmt.Errorf("failed to fetch base branch: %w", err)
 	}
 
@@ -71,17 +84,12 @@ func (c checkoutPRManualMerge) do(gitCmd git.Git, fetchOptions fetchOptions, fal
 	}
 	log.Printf("commit hash: %s", commitHash)
 
-	var remoteName string
+	remoteName := c.sourceRemote()
 	if c.params.SourceRepoURL != "" {
-		remoteName = forkRemoteName
-
 		// Add fork remote
-		if err := runner.Run(gitCmd.RemoteAdd(forkRemoteName, c.params.SourceRepoURL)); err != nil {
-			return fmt.Errorf("adding remote fork repository failed (%s): %w", c.params.SourceRepoURL, err)
+		if err := addOrUpdateRemote(gitCmd, remoteName, c.params.SourceRepoURL); err != nil {
+			return fmt.Errorf("adding remote fork repository failed (%s): %w", repourl.Redact(c.params.SourceRepoURL), err)
 		}
-
-	} else {
-		remoteName = originRemoteName
 	}
 
 	// Fetch and merge
@@ -90,7 +98,14 @@ func (c checkoutPRManualMerge) do(gitCmd git.Git, fetchOptions fetchOptions, fal
 		return fmt.Errorf("failed to fetch compare branch: %w", err)
 	}
 
-	if err := mergeWithCustomRetry(gitCmd, c.params.SourceMergeArg, fallback); err != nil {
+	if fetchOptions.limitDepth || fetchOptions.isHistoryLimited() {
+		// The merge fails (or falls back to unshallow) if the shallow history doesn't contain the merge base
+		if err := c.deepenUntilMergeBase(gitCmd, destBranchRef, remoteName, sourceBranchRef, fetchOptions); err != nil {
+			log.Warnf("Failed to fetch the merge base of the branches: %v", err)
+		}
+	}
+
+	if err := mergeWithCustomRetry(gitCmd, c.sourceMergeArg(), withDeepenTargets(fallback, c.fetchTargets()...)); err != nil {
 		return err
 	}
 
@@ -98,5 +113,76 @@ func (c checkoutPRManualMerge) do(gitCmd git.Git, fetchOptions fetchOptions, fal
 }
 
 func (c checkoutPRManualMerge) getBuildTriggerRef() string {
+	return c.sourceMergeArg()
+}
+
+func (c checkoutPRManualMerge) fetchTargets() []fetchTarget {
+	return []fetchTarget{
+		{remote: originRemoteName, ref: refsHeadsPrefix + c.params.DestinationBranch},
+		{remote: c.sourceRemote(), ref: refsHeadsPrefix + c.params.SourceBranch},
+	}
+}
+
+// sourceRemote returns the remote of the source branch: the fork remote (named in the namespace of the temporary refs) or origin
+func (c checkoutPRManualMerge) sourceRemote() string {
+	if c.params.SourceRepoURL != "" {
+		return c.refs.forkRemote()
+	}
+	return originRemoteName
+}
+
+// sourceMergeArg returns the merged commit, or the source branch of the fork remote (named in the namespace of the temporary refs)
+func (c checkoutPRManualMerge) sourceMergeArg() string {
+	if c.params.SourceRepoURL != "" {
+		return c.refs.namespace + c.params.SourceMergeArg
+	}
 	return c.params.SourceMergeArg
 }
+
+// deepenUntilMergeBase deepens the history of both branches in lockstep until their merge base is fetched,
+// so the merge doesn't need a fixed (and usually too large or too small) clone depth.
+func (c checkoutPRManualMerge) deepenUntilMergeBase(gitCmd git.Git, destBranchRef, sourceRemote, sourceBranchRef string, options fetchOptions) error {
+	traits := unshallowFetchOptions{
+		tags:            options.tags,
+		fetchSubmodules: options.fetchSubmodules,
+		forceUpdate:     options.forceUpdate,
+	}
+
+	deepened := 0
+	deepen := deepenInitialCommits
+	for round := 0; ; round++ {
+		if _, err := runner.RunForOutput(gitCommand(gitCmd, "merge-base", "HEAD", c.sourceMergeArg())); err == nil {
+			if options.limitDepth {
+				log.Donef("Merge base found, both branches are fetched with a depth of %d", options.depth+deepened)
+				if c.mergeDepth != nil {
+					*c.mergeDepth = options.depth + deepened
+				}
+			} else {
+				log.Donef("Merge base found after deepening both branches by %d commits", deepened)
+			}
+			return nil
+		}
+
+		shallow, err := runner.RunForOutput(gitCmd.RevParse("--is-shallow-repository"))
+		if err != nil {
+			return err
+		}
+		if shallow != "true" {
+			return errNoMergeBase
+		}
+		if round == deepenMaxRounds {
+			return fmt.Errorf("%w by %d commits", errDeepenLimitReached, deepened)
+		}
+
+		log.Infof("Merge base not found, deepening both branches by %d commits...", deepen)
+		if err := deepenFetch(gitCmd, originRemoteName, deepen, []string{destBranchRef}, traits); err != nil {
+			return fmt.Errorf("deepen base branch: %w", err)
+		}
+		if err := deepenFetch(gitCmd, sourceRemote, deepen, []string{sourceBranchRef}, traits); err != nil {
+			return fmt.Errorf("deepen compare branch: %w", err)
+		}
+
+		deepened += deepen
+		deepen *= 2
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/bitrise-io/go-utils/command/git"
 	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/repourl"
 )
 
 // checkoutNone
@@ -19,6 +20,10 @@ func (c checkoutNone) getBuildTriggerRef() string {
 	return ""
 }
 
+func (c checkoutNone) fetchTargets() []fetchTarget {
+	return nil
+}
+
 // CommitParams are parameters to check out a given commit (In addition to the repository URL)
 type CommitParams struct {
 	Commit                     string
@@ -45,6 +50,7 @@ func NewCommitParams(commit, branchRef, sourceRepoURL string, ignoreBranchForCom
 type checkoutCommit struct {
 	params           CommitParams
 	fallbackCheckout fallbackCheckoutFunc
+	refs             tempRefs
 }
 
 func (c checkoutCommit) do(gitCmd git.Git, fetchOptions fetchOptions, fallback fallbackRetry) error {
@@ -59,15 +65,16 @@ func (c checkoutCommit) do(gitCmd git.Git, fetchOptions fetchOptions, fallback f
 }
 
 func (c checkoutCommit) performCheckout(gitCmd git.Git, fetchOptions fetchOptions, fallback fallbackRetry) error {
-	remote := originRemoteName
+	// The ref which is deepened if the commit is not part of the shallow history
+	fetched := c.fetchTarget()
+	remote := fetched.remote
 	if c.params.SourceRepoURL != "" {
-		remote = forkRemoteName
-		if err := runner.Run(gitCmd.RemoteAdd(forkRemoteName, c.params.SourceRepoURL)); err != nil {
-			return fmt.Errorf("adding remote fork repository failed (%s): %v", c.params.SourceRepoURL, err)
+		if err := addOrUpdateRemote(gitCmd, remote, c.params.SourceRepoURL); err != nil {
+			return fmt.Errorf("adding remote fork repository failed (%s): %v", repourl.Redact(c.params.SourceRepoURL), err)
 		}
 	}
 
-	if c.params.IgnoreBranchForCommitFetch || c.params.BranchRef == "" {
+	if c.fetchesCommitDirectly() {
 		if directFetchErr := fetch(gitCmd, remote, c.params.Commit, fetchOptions); directFetchErr != nil {
 			log.Warnf("Could not fetch commit directly: %v", directFetchErr)
 			log.Warnf("Note: To speed up checkouts, ensure your Git server allows fetching reachable SHAs directly (uploadpack.allowReachableSHA1InWant).")
@@ -80,7 +87,7 @@ func (c checkoutCommit) performCheckout(gitCmd git.Git, fetchOptions fetchOption
 		}
 	}
 
-	if err := checkoutWithCustomRetry(gitCmd, c.params.Commit, fallback); err != nil {
+	if err := checkoutWithCustomRetry(gitCmd, c.params.Commit, withDeepenTargets(fallback, fetched)); err != nil {
 		err = fmt.Errorf("failed to checkout commit: %w", err)
 		newErr := fmt.Errorf("please check if the provided commit hash (%s) is valid", c.params.Commit)
 		return fmt.Errorf("%v: %w", err, newErr)
@@ -93,6 +100,26 @@ func (c checkoutCommit) getBuildTriggerRef() string {
 	return c.params.Commit
 }
 
+func (c checkoutCommit) fetchTargets() []fetchTarget {
+	return []fetchTarget{c.fetchTarget()}
+}
+
+// fetchTarget returns the fetched branch, or the commit itself if it is fetched directly
+func (c checkoutCommit) fetchTarget() fetchTarget {
+	target := fetchTarget{remote: originRemoteName, ref: c.params.BranchRef}
+	if c.params.SourceRepoURL != "" {
+		target.remote = c.refs.forkRemote()
+	}
+	if c.fetchesCommitDirectly() {
+		target.ref = c.params.Commit
+	}
+	return target
+}
+
+func (c checkoutCommit) fetchesCommitDirectly() bool {
+	return c.params.IgnoreBranchForCommitFetch || c.params.BranchRef == ""
+}
+
 // BranchParams are parameters to check out a given branch (In addition to the repository URL)
 type BranchParams struct {
 	Branch string
@@ -112,10 +139,11 @@ func NewBranchParams(branch string) (*BranchParams, error) {
 // checkoutBranch
 type checkoutBranch struct {
 	params BranchParams
+	refs   tempRefs
 }
 
 func (c checkoutBranch) do(gitCmd git.Git, fetchOptions fetchOptions, _ fallbackRetry) error {
-	if err := forceCheckoutRemoteBranch(gitCmd, originRemoteName, c.localRef(), fetchOptions); err != nil {
+	if err := forceCheckoutRemoteBranch(gitCmd, originRemoteName, refsHeadsPrefix+c.params.Branch, c.refs.branch(c.params.Branch), fetchOptions); err != nil {
 		return err
 	}
 
@@ -126,8 +154,12 @@ func (c checkoutBranch) getBuildTriggerRef() string {
 	return c.localRef()
 }
 
+func (c checkoutBranch) fetchTargets() []fetchTarget {
+	return []fetchTarget{{remote: originRemoteName, ref: refsHeadsPrefix + c.params.Branch}}
+}
+
 func (c checkoutBranch) localRef() string {
-	return refsHeadsPrefix + c.params.Branch
+	return refsHeadsPrefix + c.refs.branch(c.params.Branch)
 }
 
 // TagParams are parameters to check out a given tag
@@ -157,7 +189,7 @@ func (c checkoutTag) do(gitCmd git.Git, fetchOptions fetchOptions, fallback fall
 		return fmt.Errorf("failed to fetch tag (%s): %w", c.ref(), err)
 	}
 
-	if err := checkoutWithCustomRetry(gitCmd, c.params.Tag, fallback); err != nil {
+	if err := checkoutWithCustomRetry(gitCmd, c.params.Tag, withDeepenTargets(fallback, c.fetchTargets()...)); err != nil {
 		return err
 	}
 
@@ -168,6 +200,10 @@ func (c checkoutTag) getBuildTriggerRef() string {
 	return c.ref()
 }
 
+func (c checkoutTag) fetchTargets() []fetchTarget {
+	return []fetchTarget{{remote: originRemoteName, ref: c.ref()}}
+}
+
 func (c checkoutTag) ref() string {
 	return fmt.Sprintf("refs/tags/%s", c.params.Tag)
 }
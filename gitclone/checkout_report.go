@@ -0,0 +1,46 @@
+package gitclone
+
+import "time"
+
+// checkoutReport summarizes the fetches and the checkout of the repository, exported as JSON
+type checkoutReport struct {
+	Method     string `json:"method"`
+	DurationMS int64  `json:"duration_ms"`
+	// RequiredDepth is the clone depth the checkout needed (for example to find the merge base of a manual merge),
+	// only set if the strategy deepened the history as far as needed
+	RequiredDepth int `json:"required_depth,omitempty"`
+	// Objects and Bytes are the totals of the fetches
+	Objects int64         `json:"objects"`
+	Bytes   int64         `json:"bytes"`
+	Fetches []fetchReport `json:"fetches"`
+}
+
+type fetchReport struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+	// ThroughputBytesS is the last transfer rate reported by git
+	ThroughputBytesS int64 `json:"throughput_bytes_s"`
+	DurationMS       int64 `json:"duration_ms"`
+}
+
+func newCheckoutReport(method CheckoutMethod, strategy checkoutStrategy, duration time.Duration, fetchStats []FetchStats) *checkoutReport {
+	report := checkoutReport{
+		Method:     method.String(),
+		DurationMS: duration.Milliseconds(),
+		Fetches:    []fetchReport{},
+	}
+	if reporter, ok := strategy.(depthReporter); ok {
+		report.RequiredDepth = reporter.requiredDepth()
+	}
+	for _, stats := range fetchStats {
+		report.Objects += stats.Objects
+		report.Bytes += stats.Bytes
+		report.Fetches = append(report.Fetches, fetchReport{
+			Objects:          stats.Objects,
+			Bytes:            stats.Bytes,
+			ThroughputBytesS: stats.Throughput,
+			DurationMS:       stats.Duration.Milliseconds(),
+		})
+	}
+	return &report
+}
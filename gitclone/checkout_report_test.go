@@ -0,0 +1,52 @@
+package gitclone
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newCheckoutReport(t *testing.T) {
+	report := newCheckoutReport(CheckoutCommitMethod, checkoutCommit{}, 5*time.Second, []FetchStats{
+		{Objects: 1000, Bytes: 4 << 20, Throughput: 2 << 20, Duration: 3 * time.Second},
+		{Objects: 234, Bytes: 1 << 20, Throughput: 1 << 20, Duration: time.Second},
+	})
+
+	reportJSON, err := json.Marshal(report)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+  "method": "CheckoutCommitMethod",
+  "duration_ms": 5000,
+  "objects": 1234,
+  "bytes": 5242880,
+  "fetches": [
+    {"objects": 1000, "bytes": 4194304, "throughput_bytes_s": 2097152, "duration_ms": 3000},
+    {"objects": 234, "bytes": 1048576, "throughput_bytes_s": 1048576, "duration_ms": 1000}
+  ]
+}`, string(reportJSON))
+
+	skipped, err := json.Marshal(newCheckoutReport(CheckoutCommitMethod, checkoutCommit{}, 0, nil))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"method": "CheckoutCommitMethod", "duration_ms": 0, "objects": 0, "bytes": 0, "fetches": []}`, string(skipped))
+}
+
+func Test_newCheckoutReport_requiredDepth(t *testing.T) {
+	mergeDepth := 49
+	manualMerge := checkoutPRManualMerge{mergeDepth: &mergeDepth}
+
+	report := newCheckoutReport(CheckoutPRManualMergeMethod, manualMerge, time.Second, nil)
+	require.Equal(t, 49, report.RequiredDepth)
+
+	reportJSON, err := json.Marshal(report)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"method": "CheckoutPRManualMergeMethod", "duration_ms": 1000, "required_depth": 49, "objects": 0, "bytes": 0, "fetches": []}`, string(reportJSON))
+
+	// The diff file strategy reports the depth of its manual merge fallback
+	diffFile := checkoutPRDiffFile{params: PRDiffFileParams{PRManualMergeStrategy: manualMerge}}
+	require.Equal(t, 49, newCheckoutReport(CheckoutPRDiffFileMethod, diffFile, time.Second, nil).RequiredDepth)
+
+	// Not deepened
+	require.Equal(t, 0, newCheckoutReport(CheckoutPRManualMergeMethod, checkoutPRManualMerge{}, time.Second, nil).RequiredDepth)
+}
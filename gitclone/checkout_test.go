@@ -14,6 +14,7 @@ func Test_selectCheckoutMethod(t *testing.T) {
 		cfg             Config
 		patchSource     bitriseapi.PatchSource
 		mergeRefChecker bitriseapi.MergeRefChecker
+		forkProbe       forkAccessProbe
 		want            CheckoutMethod
 	}{
 		{
@@ -231,7 +232,22 @@ func Test_selectCheckoutMethod(t *testing.T) {
 				Commit:                "76a934ae",
 				ShouldMergePR:         false,
 			},
-			want: CheckoutForkCommitMethod,
+			forkProbe: accessibleFork,
+			want:      CheckoutForkCommitMethod,
+		},
+		{
+			name: "PR - no merge - fork - accessible private fork",
+			cfg: Config{
+				RepositoryURL:         "https://github.com/bitrise-io/git-clone-test.git",
+				PRSourceRepositoryURL: "git@github.com:bitrise-io/other-repo.git",
+				Branch:                "test/commit-messages",
+				PRDestBranch:          "master",
+				Commit:                "76a934ae",
+				ShouldMergePR:         false,
+			},
+			patchSource: FakePatchSource{diffFilePath: "dummy_path"},
+			forkProbe:   accessibleFork,
+			want:        CheckoutForkCommitMethod,
 		},
 		{
 			name: "PR - no merge - fork - diff file: private fork",
@@ -244,6 +260,21 @@ func Test_selectCheckoutMethod(t *testing.T) {
 				ShouldMergePR:         false,
 			},
 			patchSource: FakePatchSource{diffFilePath: "dummy_path"},
+			forkProbe:   inaccessibleFork,
+			want:        CheckoutPRDiffFileMethod,
+		},
+		{
+			name: "PR - no merge - fork - diff file: inaccessible HTTPS fork",
+			cfg: Config{
+				RepositoryURL:         "https://github.com/bitrise-io/git-clone-test.git",
+				PRSourceRepositoryURL: "https://github.com/bitrise-io/other-repo.git",
+				Branch:                "test/commit-messages",
+				PRDestBranch:          "master",
+				Commit:                "76a934ae",
+				ShouldMergePR:         false,
+			},
+			patchSource: FakePatchSource{diffFilePath: "dummy_path"},
+			forkProbe:   inaccessibleFork,
 			want:        CheckoutPRDiffFileMethod,
 		},
 		{
@@ -257,18 +288,27 @@ func Test_selectCheckoutMethod(t *testing.T) {
 				ShouldMergePR:         false,
 			},
 			patchSource: FakePatchSource{diffFilePath: ""},
+			forkProbe:   inaccessibleFork,
 			want:        CheckoutForkCommitMethod,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got, _ := selectCheckoutMethod(tt.cfg, tt.patchSource, tt.mergeRefChecker); got != tt.want {
+			if got, _ := selectCheckoutMethod(tt.cfg, tt.patchSource, tt.mergeRefChecker, tt.forkProbe); got != tt.want {
 				t.Errorf("selectCheckoutMethod() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func accessibleFork(url, branch string) error {
+	return nil
+}
+
+func inaccessibleFork(url, branch string) error {
+	return fmt.Errorf("Permission denied (publickey)")
+}
+
 func Test_getBuildTriggerRef(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -350,61 +390,11 @@ func Test_getBuildTriggerRef(t *testing.T) {
 	}
 }
 
-func Test_idealDefaultCloneDepth(t *testing.T) {
-	tests := []struct {
-		method CheckoutMethod
-		want   int
-	}{
-		{
-			method: CheckoutNoneMethod,
-			want:   1,
-		},
-		{
-			method: CheckoutPRMergeBranchMethod,
-			want:   1,
-		},
-		{
-			method: CheckoutPRManualMergeMethod,
-			want:   50,
-		},
-		{
-			method: CheckoutPRDiffFileMethod,
-			want:   1,
-		},
-		{
-			method: CheckoutCommitMethod,
-			want:   1,
-		},
-		{
-			method: CheckoutTagMethod,
-			want:   1,
-		},
-		{
-			method: CheckoutBranchMethod,
-			want:   1,
-		},
-		{
-			method: CheckoutHeadBranchCommitMethod,
-			want:   1,
-		},
-		{
-			method: CheckoutForkCommitMethod,
-			want:   1,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.method.String(), func(t *testing.T) {
-			if got := idealDefaultCloneDepth(tt.method); got != tt.want {
-				t.Errorf("idealDefaultCloneDepth() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
 func Test_selectFetchOptions(t *testing.T) {
 	type args struct {
 		method          CheckoutMethod
 		cloneDepth      int
+		limit           historyLimit
 		fetchTags       bool
 		fetchSubmodules bool
 		filterTree      bool
@@ -465,10 +455,30 @@ func Test_selectFetchOptions(t *testing.T) {
 				filterTree:      false,
 			},
 		},
+		{
+			name: "date and ref limit replaces the depth limit",
+			args: args{
+				method:          CheckoutPRManualMergeMethod,
+				cloneDepth:      0,
+				limit:           historyLimit{since: "30 days ago", exclude: []string{"v1.0.0"}},
+				fetchTags:       false,
+				fetchSubmodules: false,
+				filterTree:      false,
+			},
+			want: fetchOptions{
+				tags:            false,
+				limitDepth:      false,
+				depth:           0,
+				shallowSince:    "30 days ago",
+				shallowExclude:  []string{"v1.0.0"},
+				fetchSubmodules: false,
+				filterTree:      false,
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equalf(t, tt.want, selectFetchOptions(tt.args.method, tt.args.cloneDepth, tt.args.fetchTags, tt.args.fetchSubmodules, tt.args.filterTree), "selectFetchOptions(%v, %v, %v, %v, %v)", tt.args.method, tt.args.cloneDepth, tt.args.fetchTags, tt.args.fetchSubmodules, tt.args.filterTree)
+			assert.Equalf(t, tt.want, selectFetchOptions(tt.args.method, tt.args.cloneDepth, tt.args.limit, tt.args.fetchTags, tt.args.fetchSubmodules, tt.args.filterTree), "selectFetchOptions(%v, %v, %v, %v, %v, %v)", tt.args.method, tt.args.cloneDepth, tt.args.limit, tt.args.fetchTags, tt.args.fetchSubmodules, tt.args.filterTree)
 		})
 	}
 }
@@ -6,7 +6,12 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/bitrise-io/go-utils/command"
 	"github.com/bitrise-io/go-utils/errorutil"
@@ -18,25 +23,83 @@ import (
 type CommandRunner interface {
 	RunForOutput(c *command.Model) (string, error)
 	Run(c *command.Model) error
+	RunWithTimeout(c *command.Model, timeout time.Duration) error
 	RunWithRetry(getCommmand func() *command.Model) error
+	SetTimeouts(timeouts CommandTimeouts)
+	FetchStats(dir string) []FetchStats
 	SetPerformanceMonitoring(enable bool)
+	TraceEventsDir(dir string) string
 	PausePerformanceMonitoring()
 	ResumePerformanceMonitoring()
+	StopRepositoryPerformanceMonitoring(dir string)
+	StopPerformanceMonitoring()
+	SetSharedRepositoryLock(dir string, lock func() (release func(), err error))
+	SetOutputPrefix(dir, prefix string)
 }
 
+// CommandTimeouts are the time limits of the long-running git operations.
+// A zero value means the operation can run without a time limit.
+type CommandTimeouts struct {
+	Fetch           time.Duration
+	Checkout        time.Duration
+	Merge           time.Duration
+	SubmoduleUpdate time.Duration
+}
+
+// commandTimeoutError is returned when a git command is terminated for exceeding the time limit of its phase
+type commandTimeoutError struct {
+	phase   string
+	timeout time.Duration
+}
+
+func (e commandTimeoutError) Error() string {
+	return fmt.Sprintf("git %s did not finish in %s and was terminated", e.phase, e.timeout)
+}
+
+func isCommandTimeoutError(err error) bool {
+	var timeoutErr commandTimeoutError
+	return errors.As(err, &timeoutErr)
+}
+
+// Once a timed out process is killed, its child processes (ssh, index-pack) might still hold the output pipes open.
+// Waiting is given up after this delay, so a stuck child can't block the step.
+const killedProcessWaitDelay = 10 * time.Second
+
+// killedProcessExitTimeout is the time given to the killed processes to exit before the lock files are cleaned up
+const killedProcessExitTimeout = 5 * time.Second
+
 // DefaultRunner ...
 type DefaultRunner struct {
+	// mu guards every field, as the additional repositories are checked out in parallel
+	mu sync.Mutex
+
 	performanceMonitoringEnabled             bool
 	performanceMonitoringTemporarilyDisabled bool
+	// traceEventsDirs are the directories of the trace2 events keyed by the directory of the repository, so the events
+	// of the repositories checked out in parallel are summarized separately. An empty value means monitoring is stopped for the repository.
+	traceEventsDirs map[string]string
+
+	timeouts   CommandTimeouts
+	fetchStats map[string][]FetchStats
+	// sharedRepositoryLocks lock the repository shared by the worktrees, keyed by the directory of the worktree
+	sharedRepositoryLocks map[string]func() (func(), error)
+	// outputPrefixes are the prefixes of the output of the commands, keyed by the directory of the repository
+	outputPrefixes map[string]string
 }
 
 // RunForOutput ...
 func (r *DefaultRunner) RunForOutput(c *command.Model) (string, error) {
 	fmt.Println()
-	log.Infof("$ %s &> out", c.PrintableCommandArgs())
+	log.Infof("%s$ %s &> out", r.outputPrefix(c), c.PrintableCommandArgs())
 
 	r.setupPerformanceMonitoring(c)
 
+	releaseLock, err := r.lockSharedRepository(c)
+	if err != nil {
+		return "", err
+	}
+	defer releaseLock()
+
 	out, err := c.RunAndReturnTrimmedCombinedOutput()
 	if err != nil && errorutil.IsExitStatusError(err) {
 		return out, errors.New(out)
@@ -47,14 +110,57 @@ func (r *DefaultRunner) RunForOutput(c *command.Model) (string, error) {
 
 // Run ...
 func (r *DefaultRunner) Run(c *command.Model) error {
+	return r.run(c, 0, time.Time{})
+}
+
+// RunWithTimeout runs the command with the given time limit instead of the time limit of its phase
+func (r *DefaultRunner) RunWithTimeout(c *command.Model, timeout time.Duration) error {
+	return r.run(c, timeout, time.Time{})
+}
+
+// run runs the command, a non-zero timeout or deadline overrides the time limit of its phase
+func (r *DefaultRunner) run(c *command.Model, timeout time.Duration, deadline time.Time) error {
+	isFetch := isFetchCommand(c)
+	if isFetch {
+		withFetchProgress(c)
+	}
+
+	prefix := r.outputPrefix(c)
 	fmt.Println()
-	log.Infof("$ %s", c.PrintableCommandArgs())
+	log.Infof("%s$ %s", prefix, c.PrintableCommandArgs())
 	var buffer bytes.Buffer
 
 	r.setupPerformanceMonitoring(c)
 
-	err := c.SetStdout(os.Stdout).SetStderr(io.MultiWriter(os.Stderr, &buffer)).Run()
+	releaseLock, err := r.lockSharedRepository(c)
+	if err != nil {
+		return err
+	}
+	defer releaseLock()
+
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	if prefix != "" {
+		stdout, stderr = newPrefixWriter(stdout, prefix), newPrefixWriter(stderr, prefix)
+	}
+	stderr = io.MultiWriter(stderr, &buffer)
+	var fetchProgress *fetchProgressWriter
+	if isFetch {
+		fetchProgress = newFetchProgressWriter(stderr, prefix)
+		fetchProgress.startHeartbeat(fetchProgressInterval)
+		stderr = fetchProgress
+	}
+
+	err = r.runWithTimeout(c.SetStdout(stdout).SetStderr(stderr), timeout, deadline)
+	if fetchProgress != nil {
+		stats := fetchProgress.Close()
+		if err == nil {
+			r.addFetchStats(c.GetCmd().Dir, stats)
+		}
+	}
 	if err != nil {
+		if isCommandTimeoutError(err) {
+			return err
+		}
 		if errorutil.IsExitStatusError(err) {
 			errorStr := buffer.String()
 			if errorStr == "" {
@@ -70,40 +176,363 @@ func (r *DefaultRunner) Run(c *command.Model) error {
 
 // RunWithRetry ...
 func (r *DefaultRunner) RunWithRetry(getCommand func() *command.Model) error {
-	return retry.Times(2).Wait(5).Try(func(attempt uint) error {
+	// The time limit of the phase applies to all attempts together: a failed attempt is retried in the remaining time,
+	// but an attempt which is terminated for the time limit is not retried, as no time is left.
+	var deadline time.Time
+	return retry.Times(2).Wait(5).TryWithAbort(func(attempt uint) (error, bool) {
+		c := getCommand()
+		prefix := r.outputPrefix(c)
 		if attempt > 0 {
-			log.Warnf("Retrying...")
+			log.Warnf("%sRetrying...", prefix)
+		}
+
+		if attempt == 0 {
+			if _, timeout := r.timeout(c); timeout > 0 {
+				deadline = time.Now().Add(timeout)
+			}
 		}
 
-		err := r.Run(getCommand())
+		err := r.run(c, 0, deadline)
 		if err != nil {
-			log.Warnf("Attempt %d failed:", attempt+1)
-			fmt.Println(err.Error())
+			log.Warnf("%sAttempt %d failed:", prefix, attempt+1)
+			fmt.Println(prefix + err.Error())
 		}
 
-		return err
+		return err, !deadline.IsZero() && !time.Now().Before(deadline)
 	})
 }
 
+func (r *DefaultRunner) SetTimeouts(timeouts CommandTimeouts) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timeouts = timeouts
+}
+
+// FetchStats returns the transfer totals of the successful fetches run in the directory so far
+func (r *DefaultRunner) FetchStats(dir string) []FetchStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]FetchStats(nil), r.fetchStats[dir]...)
+}
+
+func (r *DefaultRunner) addFetchStats(dir string, stats FetchStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fetchStats == nil {
+		r.fetchStats = map[string][]FetchStats{}
+	}
+	r.fetchStats[dir] = append(r.fetchStats[dir], stats)
+}
+
+// SetSharedRepositoryLock registers the lock of the shared repository of the worktree in dir,
+// the lock is held while a git command changing the shared refs or config runs in the worktree. A nil lock unregisters it.
+func (r *DefaultRunner) SetSharedRepositoryLock(dir string, lock func() (release func(), err error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if lock == nil {
+		delete(r.sharedRepositoryLocks, dir)
+		return
+	}
+	if r.sharedRepositoryLocks == nil {
+		r.sharedRepositoryLocks = map[string]func() (func(), error){}
+	}
+	r.sharedRepositoryLocks[dir] = lock
+}
+
+// lockSharedRepository locks the shared repository if the command changes its refs or config
+func (r *DefaultRunner) lockSharedRepository(c *command.Model) (func(), error) {
+	r.mu.Lock()
+	lock := r.sharedRepositoryLocks[c.GetCmd().Dir]
+	r.mu.Unlock()
+
+	if lock == nil || !changesSharedRepository(c.GetCmd().Args) {
+		return func() {}, nil
+	}
+	return lock()
+}
+
+// changesSharedRepository reports whether the git command changes the refs or the config shared by the worktrees of the repository.
+// The HEAD, the index and the per-worktree config (extensions.worktreeConfig) belong to the worktree.
+func changesSharedRepository(args []string) bool {
+	if len(args) < 2 || args[0] != "git" {
+		return false
+	}
+
+	switch args[1] {
+	case "fetch", "pull", "update-ref", "branch", "tag", "remote", "worktree", "gc", "pack-refs":
+		return true
+	case "checkout":
+		return slices.Contains(args[2:], "-b") || slices.Contains(args[2:], "-B")
+	case "config":
+		return !slices.ContainsFunc(args[2:], func(arg string) bool {
+			return arg == "--worktree" || arg == "--file" || strings.HasPrefix(arg, "--file=") ||
+				arg == "-l" || arg == "--list" || strings.HasPrefix(arg, "--get")
+		})
+	case "submodule":
+		// The submodules are registered in the shared config
+		if len(args) < 3 {
+			return false
+		}
+		switch args[2] {
+		case "init", "sync", "deinit":
+			return true
+		case "update":
+			return slices.Contains(args[3:], "--init")
+		}
+	}
+
+	return false
+}
+
+// SetOutputPrefix sets the prefix of the output of the commands run in dir, an empty prefix removes it
+func (r *DefaultRunner) SetOutputPrefix(dir, prefix string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prefix == "" {
+		delete(r.outputPrefixes, dir)
+		return
+	}
+	if r.outputPrefixes == nil {
+		r.outputPrefixes = map[string]string{}
+	}
+	r.outputPrefixes[dir] = prefix
+}
+
+func (r *DefaultRunner) outputPrefix(c *command.Model) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.outputPrefixes[c.GetCmd().Dir]
+}
+
 func (r *DefaultRunner) SetPerformanceMonitoring(enable bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.performanceMonitoringEnabled = enable
 }
 
+// TraceEventsDir returns the directory where the git trace2 events of the repository in dir are collected,
+// or an empty string if performance monitoring is disabled.
+func (r *DefaultRunner) TraceEventsDir(dir string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.performanceMonitoringEnabled {
+		return ""
+	}
+	return r.traceEventsDirs[dir]
+}
+
 func (r *DefaultRunner) PausePerformanceMonitoring() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.performanceMonitoringTemporarilyDisabled = true
 }
 
 func (r *DefaultRunner) ResumePerformanceMonitoring() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.performanceMonitoringTemporarilyDisabled = false
 }
 
+// StopRepositoryPerformanceMonitoring stops collecting the trace2 events of the repository in dir and removes the collected events
+func (r *DefaultRunner) StopRepositoryPerformanceMonitoring(dir string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.traceEventsDirs == nil {
+		r.traceEventsDirs = map[string]string{}
+	}
+	removeTraceEventsDir(r.traceEventsDirs[dir])
+	r.traceEventsDirs[dir] = ""
+}
+
+// StopPerformanceMonitoring disables performance monitoring and removes the collected trace2 events
+func (r *DefaultRunner) StopPerformanceMonitoring() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.performanceMonitoringEnabled = false
+	for _, eventsDir := range r.traceEventsDirs {
+		removeTraceEventsDir(eventsDir)
+	}
+	r.traceEventsDirs = nil
+}
+
+func removeTraceEventsDir(eventsDir string) {
+	if eventsDir == "" {
+		return
+	}
+	if err := os.RemoveAll(eventsDir); err != nil {
+		log.Warnf("Failed to remove the performance monitoring events: %s", err)
+	}
+}
+
 func (r *DefaultRunner) setupPerformanceMonitoring(c *command.Model) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if r.performanceMonitoringTemporarilyDisabled {
-		c.AppendEnvs("GIT_TRACE2_PERF=0")
+		c.AppendEnvs("GIT_TRACE2_EVENT=0")
+		return
+	}
+	if !r.performanceMonitoringEnabled {
 		return
 	}
 
-	if r.performanceMonitoringEnabled {
-		c.AppendEnvs("GIT_TRACE2_PERF=1")
+	dir := c.GetCmd().Dir
+	eventsDir, ok := r.traceEventsDirs[dir]
+	if !ok {
+		// Git writes the trace2 events of each process into a separate file when the target is a directory,
+		// so the events of parallel child processes (e.g. submodule fetches) don't interleave.
+		var err error
+		if eventsDir, err = os.MkdirTemp("", "git-trace2-events"); err != nil {
+			log.Warnf("Failed to create directory for performance monitoring, disabling it for %s: %s", dir, err)
+			eventsDir = ""
+		}
+		if r.traceEventsDirs == nil {
+			r.traceEventsDirs = map[string]string{}
+		}
+		r.traceEventsDirs[dir] = eventsDir
+	}
+	if eventsDir != "" {
+		c.AppendEnvs("GIT_TRACE2_EVENT=" + eventsDir)
+	}
+}
+
+// timeout returns the phase of a git command and the time limit configured for that phase
+func (r *DefaultRunner) timeout(c *command.Model) (string, time.Duration) {
+	args := c.GetCmd().Args
+	if len(args) < 2 || args[0] != "git" {
+		return "", 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch args[1] {
+	case "fetch":
+		return "fetch", r.timeouts.Fetch
+	case "checkout":
+		return "checkout", r.timeouts.Checkout
+	case "merge":
+		return "merge", r.timeouts.Merge
+	case "submodule":
+		if len(args) > 2 && args[2] == "update" {
+			return "submodule update", r.timeouts.SubmoduleUpdate
+		}
+	case "ls-remote":
+		return "ls-remote", 0
+	}
+
+	return "", 0
+}
+
+// runWithTimeout runs the command with the time limit of its phase (or the given timeout, or until the deadline if set).
+// The timed out git process is killed together with its child processes, and their lock files are cleaned up once all of them exited.
+func (r *DefaultRunner) runWithTimeout(c *command.Model, timeoutOverride time.Duration, deadline time.Time) error {
+	phase, timeout := r.timeout(c)
+	if timeoutOverride > 0 {
+		timeout = timeoutOverride
+	}
+	if timeout <= 0 {
+		return c.Run()
+	}
+
+	remaining := timeout
+	if !deadline.IsZero() {
+		if remaining = time.Until(deadline); remaining <= 0 {
+			return commandTimeoutError{phase: phase, timeout: timeout}
+		}
+	}
+
+	cmd := c.GetCmd()
+	cmd.WaitDelay = killedProcessWaitDelay
+	// The child processes (git-remote-https, index-pack) are started in the process group of git, so they can be killed together
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var timedOut atomic.Bool
+	prefix := r.outputPrefix(c)
+	timer := time.AfterFunc(remaining, func() {
+		timedOut.Store(true)
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+			log.Warnf("%sFailed to terminate git %s: %s", prefix, phase, err)
+		}
+	})
+	err := cmd.Wait()
+	timer.Stop()
+
+	if !timedOut.Load() {
+		return err
+	}
+
+	log.Warnf("%sgit %s did not finish in %s, terminated", prefix, phase, timeout)
+
+	// git ls-remote doesn't write the repository, the lock files belong to other git processes
+	if phase == "ls-remote" {
+		return commandTimeoutError{phase: phase, timeout: timeout}
+	}
+
+	// The lock files of a process which is still running must be kept, it would corrupt the repository after the cleanup
+	if !waitForProcessGroupExit(cmd.Process.Pid, killedProcessExitTimeout) {
+		log.Warnf("%sThe terminated git %s processes are still running, keeping their lock files", prefix, phase)
+		return commandTimeoutError{phase: phase, timeout: timeout}
+	}
+
+	// The killed process can't release its locks, so every following git command would fail with
+	// "Unable to create '.../shallow.lock': File exists" if we didn't clean them up here.
+	removed, lockErr := removeLockFilesOf(cmd.Dir, changesSharedRepository(cmd.Args))
+	if lockErr != nil {
+		log.Warnf("%sFailed to clean up lock files: %s", prefix, lockErr)
+	}
+	for _, path := range removed {
+		log.Printf("%sRemoved %s", prefix, path)
+	}
+
+	return commandTimeoutError{phase: phase, timeout: timeout}
+}
+
+// waitForProcessGroupExit waits until every process of the group exited, and reports whether they did so within the timeout
+func waitForProcessGroupExit(pgid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		// Signal 0 only checks if any process of the group exists
+		if err := syscall.Kill(-pgid, 0); errors.Is(err, syscall.ESRCH) {
+			return true
+		}
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// prefixWriter prefixes every line written to the underlying writer, so the output of the repositories checked out in parallel can be told apart.
+// The progress lines of git end with a carriage return, these are prefixed too.
+type prefixWriter struct {
+	w           io.Writer
+	prefix      []byte
+	atLineStart bool
+	afterCR     bool
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte(prefix), atLineStart: true}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	var out []byte
+	for _, c := range b {
+		// A CRLF line ending is prefixed only once
+		if p.atLineStart && !(c == '\n' && p.afterCR) {
+			out = append(out, p.prefix...)
+			p.atLineStart = false
+		}
+		out = append(out, c)
+		p.afterCR = c == '\r'
+		if c == '\n' || c == '\r' {
+			p.atLineStart = true
+		}
+	}
+	if _, err := p.w.Write(out); err != nil {
+		return 0, err
 	}
+	return len(b), nil
 }
@@ -1,9 +1,12 @@
 package gitclone
 
 import (
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/bitrise-io/go-utils/command"
 	"github.com/stretchr/testify/require"
@@ -14,6 +17,7 @@ func TestPerformanceMonitoring(t *testing.T) {
 		name          string
 		initialState  *bool
 		shouldDisable bool
+		wantEventsDir bool
 		want          *string
 	}{
 		{
@@ -22,9 +26,9 @@ func TestPerformanceMonitoring(t *testing.T) {
 			want:         nil,
 		},
 		{
-			name:         "Enable performance monitoring",
-			initialState: pointer(true),
-			want:         pointer("1"),
+			name:          "Enable performance monitoring",
+			initialState:  pointer(true),
+			wantEventsDir: true,
 		},
 		{
 			name:         "Disable performance monitoring",
@@ -54,17 +58,19 @@ func TestPerformanceMonitoring(t *testing.T) {
 			err := r.Run(cmd)
 			require.NoError(t, err)
 
-			value, ok := getEnv(cmd.GetCmd(), "GIT_TRACE2_PERF")
+			value, ok := getEnv(cmd.GetCmd(), "GIT_TRACE2_EVENT")
 
-			if tt.want == nil {
-				require.Equal(t, "", value)
-				require.False(t, ok)
+			if tt.wantEventsDir {
+				require.NotEmpty(t, r.TraceEventsDir(""))
+				require.DirExists(t, r.TraceEventsDir(""))
+				require.Equal(t, r.TraceEventsDir(""), value)
+				require.True(t, ok)
 				return
 			}
 
-			if tt.shouldDisable {
-				require.Equal(t, "0", value)
-				require.True(t, ok)
+			if tt.want == nil {
+				require.Equal(t, "", value)
+				require.False(t, ok)
 				return
 			}
 
@@ -74,6 +80,152 @@ func TestPerformanceMonitoring(t *testing.T) {
 	}
 }
 
+func TestStopPerformanceMonitoring(t *testing.T) {
+	r := DefaultRunner{}
+	r.SetPerformanceMonitoring(true)
+	require.NoError(t, r.Run(command.New("echo", "hello")))
+	eventsDir := r.TraceEventsDir("")
+	require.DirExists(t, eventsDir)
+
+	r.StopPerformanceMonitoring()
+
+	require.NoDirExists(t, eventsDir)
+	require.Empty(t, r.TraceEventsDir(""))
+	cmd := command.New("echo", "hello")
+	require.NoError(t, r.Run(cmd))
+	_, ok := getEnv(cmd.GetCmd(), "GIT_TRACE2_EVENT")
+	require.False(t, ok)
+}
+
+func TestRepositoryPerformanceMonitoring(t *testing.T) {
+	r := DefaultRunner{}
+	r.SetPerformanceMonitoring(true)
+	defer r.StopPerformanceMonitoring()
+	mainDir, otherDir := t.TempDir(), t.TempDir()
+	require.NoError(t, r.Run(command.New("echo", "hello").SetDir(mainDir)))
+	require.NoError(t, r.Run(command.New("echo", "hello").SetDir(otherDir)))
+
+	// The events of each repository are collected separately
+	mainEventsDir := r.TraceEventsDir(mainDir)
+	require.DirExists(t, mainEventsDir)
+	require.DirExists(t, r.TraceEventsDir(otherDir))
+	require.NotEqual(t, mainEventsDir, r.TraceEventsDir(otherDir))
+
+	r.StopRepositoryPerformanceMonitoring(mainDir)
+
+	require.NoDirExists(t, mainEventsDir)
+	require.Empty(t, r.TraceEventsDir(mainDir))
+	require.DirExists(t, r.TraceEventsDir(otherDir))
+	cmd := command.New("echo", "hello").SetDir(mainDir)
+	require.NoError(t, r.Run(cmd))
+	_, ok := getEnv(cmd.GetCmd(), "GIT_TRACE2_EVENT")
+	require.False(t, ok)
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out strings.Builder
+	w := newPrefixWriter(&out, "[sdk] ")
+
+	for _, chunk := range []string{"Receiving objects:  50%\r", "Receiving objects: 100%\r\nremote: done", ".\n"} {
+		n, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+		require.Equal(t, len(chunk), n)
+	}
+
+	require.Equal(t, "[sdk] Receiving objects:  50%\r[sdk] Receiving objects: 100%\r\n[sdk] remote: done.\n", out.String())
+}
+
+func TestCommandTimeouts(t *testing.T) {
+	r := DefaultRunner{}
+	r.SetTimeouts(CommandTimeouts{
+		Fetch:           1 * time.Minute,
+		Checkout:        2 * time.Minute,
+		Merge:           3 * time.Minute,
+		SubmoduleUpdate: 4 * time.Minute,
+	})
+
+	tests := []struct {
+		args        []string
+		wantPhase   string
+		wantTimeout time.Duration
+	}{
+		{args: []string{"fetch", "--jobs=10", "origin"}, wantPhase: "fetch", wantTimeout: 1 * time.Minute},
+		{args: []string{"checkout", "master"}, wantPhase: "checkout", wantTimeout: 2 * time.Minute},
+		{args: []string{"merge", "feature"}, wantPhase: "merge", wantTimeout: 3 * time.Minute},
+		{args: []string{"submodule", "update", "--init"}, wantPhase: "submodule update", wantTimeout: 4 * time.Minute},
+		{args: []string{"submodule", "foreach", "git", "reset"}},
+		{args: []string{"status", "--porcelain"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			phase, timeout := r.timeout(command.New("git", tt.args...))
+
+			require.Equal(t, tt.wantPhase, phase)
+			require.Equal(t, tt.wantTimeout, timeout)
+		})
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	// A fake git that leaves a lock file behind and hangs, like a fetch stalled on the network
+	binDir := t.TempDir()
+	fakeGit := "#!/bin/sh\nmkdir -p .git/objects/pack && touch .git/shallow.lock .git/objects/pack/tmp_pack_x && exec sleep 10\n"
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "git"), []byte(fakeGit), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	repoDir := t.TempDir()
+	r := DefaultRunner{}
+	r.SetTimeouts(CommandTimeouts{Fetch: 200 * time.Millisecond})
+
+	start := time.Now()
+	err := r.Run(command.New("git", "fetch").SetDir(repoDir))
+
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.Equal(t, commandTimeoutError{phase: "fetch", timeout: 200 * time.Millisecond}, err)
+	require.NoFileExists(t, filepath.Join(repoDir, ".git", "shallow.lock"))
+	require.NoFileExists(t, filepath.Join(repoDir, ".git", "objects", "pack", "tmp_pack_x"))
+}
+
+func TestRunWithRetryTimeout(t *testing.T) {
+	// A fake git that hangs in a child process, like a stalled git-remote-https
+	binDir := t.TempDir()
+	fakeGit := "#!/bin/sh\necho fetch >> attempts\nmkdir -p .git && touch .git/shallow.lock\nsleep 10 &\nwait\n"
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "git"), []byte(fakeGit), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	repoDir := t.TempDir()
+	r := DefaultRunner{}
+	r.SetTimeouts(CommandTimeouts{Fetch: 500 * time.Millisecond})
+
+	err := r.RunWithRetry(func() *command.Model { return command.New("git", "fetch").SetDir(repoDir) })
+
+	// The attempt terminated for the time limit is not retried, the retries don't get a fresh time limit
+	attempts, readErr := os.ReadFile(filepath.Join(repoDir, "attempts"))
+	require.NoError(t, readErr)
+	require.Equal(t, "fetch\n", string(attempts))
+	require.Equal(t, commandTimeoutError{phase: "fetch", timeout: 500 * time.Millisecond}, err)
+	require.NoFileExists(t, filepath.Join(repoDir, ".git", "shallow.lock"))
+}
+
+func TestRunLsRemoteTimeout(t *testing.T) {
+	// A fake git that hangs while another git process holds a lock in the repository
+	binDir := t.TempDir()
+	fakeGit := "#!/bin/sh\nsleep 10 &\nwait\n"
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "git"), []byte(fakeGit), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	repoDir := t.TempDir()
+	lockPath := filepath.Join(repoDir, ".git", "index.lock")
+	require.NoError(t, os.MkdirAll(filepath.Dir(lockPath), 0755))
+	require.NoError(t, os.WriteFile(lockPath, nil, 0644))
+	r := DefaultRunner{}
+
+	err := r.RunWithTimeout(command.New("git", "ls-remote", "origin").SetDir(repoDir), 500*time.Millisecond)
+
+	require.Equal(t, commandTimeoutError{phase: "ls-remote", timeout: 500 * time.Millisecond}, err)
+	require.FileExists(t, lockPath)
+}
+
 func pointer[T any](d T) *T {
 	return &d
 }
@@ -0,0 +1,110 @@
+package gitclone
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// checkedOutStatePrefix marks the commit outputs evaluated against HEAD instead of the build trigger ref
+const checkedOutStatePrefix = "HEAD:"
+
+// builtInOutputPrefix is the prefix of the Step's own outputs, the commit outputs can't shadow them
+const builtInOutputPrefix = "GIT_CLONE_"
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// commitOutputPresets are the named git pretty-format placeholders, see https://git-scm.com/docs/pretty-formats
+var commitOutputPresets = map[string]string{
+	"hash":                "%H",
+	"abbreviated_hash":    "%h",
+	"tree_hash":           "%T",
+	"parent_hashes":       "%P",
+	"author_name":         "%an",
+	"author_email":        "%ae",
+	"author_date_iso":     "%aI",
+	"author_date_unix":    "%at",
+	"committer_name":      "%cn",
+	"committer_email":     "%ce",
+	"commit_date_iso":     "%cI",
+	"commit_date_unix":    "%ct",
+	"subject":             "%s",
+	"body":                "%b",
+	"abbreviated_parents": "%p",
+	"abbreviated_tree":    "%t",
+	"ref_names":           "%D",
+}
+
+// CommitOutput is a user defined output with a git pretty-format evaluated against the checked out commit
+type CommitOutput struct {
+	EnvKey string
+	Format string
+	// CheckedOutState evaluates the format against HEAD (the merge commit of Pull Request builds)
+	// instead of the build trigger ref
+	CheckedOutState bool
+}
+
+// ParseCommitOutputs parses the commit output definitions, one per line in the ENV_KEY=FORMAT form.
+// FORMAT is a git pretty-format (for example %aI) or a preset name (for example author_date_iso),
+// prefixed with HEAD: to evaluate it against the checked out state instead of the build trigger ref.
+func ParseCommitOutputs(lines []string) ([]CommitOutput, error) {
+	var outputs []CommitOutput
+	seen := map[string]bool{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		key, format, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || strings.TrimSpace(format) == "" {
+			return nil, fmt.Errorf("invalid commit output (%s): expected ENV_KEY=FORMAT", line)
+		}
+		if !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid commit output (%s): %s is not a valid env var name", line, key)
+		}
+		if strings.HasPrefix(key, builtInOutputPrefix) {
+			return nil, fmt.Errorf("invalid commit output (%s): the %s prefix is reserved for the outputs of the Step", line, builtInOutputPrefix)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("invalid commit output (%s): %s is defined more than once", line, key)
+		}
+		seen[key] = true
+
+		output := CommitOutput{EnvKey: key}
+		if strings.HasPrefix(format, checkedOutStatePrefix) {
+			output.CheckedOutState = true
+			format = strings.TrimPrefix(format, checkedOutStatePrefix)
+		}
+
+		// The formats are joined with NUL separators into a single git log call (see readGitOutputs), a NUL in a format would break the split.
+		// An escaped %% is a literal percent sign, so %%x00 is not a NUL.
+		if strings.Contains(strings.ReplaceAll(format, "%%", ""), gitOutputFieldSeparator) {
+			return nil, fmt.Errorf("invalid commit output (%s): the format can't contain %s", line, gitOutputFieldSeparator)
+		}
+
+		if preset, ok := commitOutputPresets[strings.TrimSpace(format)]; ok {
+			output.Format = preset
+		} else if strings.Contains(format, "%") {
+			output.Format = format
+		} else {
+			return nil, fmt.Errorf("invalid commit output (%s): %s is neither a pretty-format nor a preset, available presets: %s",
+				line, format, strings.Join(commitOutputPresetNames(), ", "))
+		}
+
+		outputs = append(outputs, output)
+	}
+
+	return outputs, nil
+}
+
+func commitOutputPresetNames() []string {
+	var names []string
+	for name := range commitOutputPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
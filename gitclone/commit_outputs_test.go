@@ -0,0 +1,84 @@
+package gitclone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseCommitOutputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		want    []CommitOutput
+		wantErr string
+	}{
+		{
+			name: "Formats and presets",
+			lines: []string{
+				"AUTHOR_DATE=%aI",
+				"",
+				"  SHORT_HASH = abbreviated_hash ",
+				"MERGE_PARENTS=HEAD:parent_hashes",
+				"SUMMARY=HEAD:%h %s (%an)",
+			},
+			want: []CommitOutput{
+				{EnvKey: "AUTHOR_DATE", Format: "%aI"},
+				{EnvKey: "SHORT_HASH", Format: "%h"},
+				{EnvKey: "MERGE_PARENTS", Format: "%P", CheckedOutState: true},
+				{EnvKey: "SUMMARY", Format: "%h %s (%an)", CheckedOutState: true},
+			},
+		},
+		{
+			name:  "Format containing the separator",
+			lines: []string{"TRAILER=%(trailers:key=Jira)"},
+			want:  []CommitOutput{{EnvKey: "TRAILER", Format: "%(trailers:key=Jira)"}},
+		},
+		{
+			name:    "Missing format",
+			lines:   []string{"AUTHOR_DATE="},
+			wantErr: "expected ENV_KEY=FORMAT",
+		},
+		{
+			name:    "Invalid env key",
+			lines:   []string{"AUTHOR-DATE=%aI"},
+			wantErr: "not a valid env var name",
+		},
+		{
+			name:    "Env key shadowing a built-in output",
+			lines:   []string{"GIT_CLONE_COMMIT_HASH=%h"},
+			wantErr: "prefix is reserved for the outputs of the Step",
+		},
+		{
+			name:    "Format containing a NUL character",
+			lines:   []string{"NAMES=%an%x00%cn"},
+			wantErr: "the format can't contain %x00",
+		},
+		{
+			name:  "Format containing an escaped percent sign",
+			lines: []string{"LITERAL=%h %%x00"},
+			want:  []CommitOutput{{EnvKey: "LITERAL", Format: "%h %%x00"}},
+		},
+		{
+			name:    "Duplicated env key",
+			lines:   []string{"DATE=%aI", "DATE=%cI"},
+			wantErr: "defined more than once",
+		},
+		{
+			name:    "Unknown preset",
+			lines:   []string{"DATE=author_date"},
+			wantErr: "neither a pretty-format nor a preset",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCommitOutputs(tt.lines)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package gitclone
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/command/git"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	// The shallow history is deepened by a doubling number of commits (16, 32, 64, ...) until the searched commit becomes reachable.
+	// This way a commit close to HEAD needs a single small fetch, while a far one still takes only a few rounds.
+	deepenInitialCommits = 16
+	deepenMaxRounds      = 8
+
+	// Unit and record separator characters, these can't occur in the log fields
+	commitFieldSeparator  = "\x1f"
+	commitRecordSeparator = "\x1e"
+	commitRangeLogFormat  = "%H%x1f%an%x1f%ae%x1f%aI%x1f%s%x1e"
+)
+
+var (
+	errNoMergeBase        = errors.New("the commits have no common history")
+	errHistoryComplete    = errors.New("the complete history is available")
+	errDeepenLimitReached = errors.New("gave up after deepening the history")
+	errNoDeepenTargets    = errors.New("no fetched ref to deepen")
+)
+
+// commitRange holds the commits introduced by the build: the commits reachable from HEAD, but not from the base
+type commitRange struct {
+	// base is the merge-base of the configured base and HEAD
+	base    string
+	commits []rangeCommit
+}
+
+type rangeCommit struct {
+	Hash        string `json:"hash"`
+	AuthorName  string `json:"author_name"`
+	AuthorEmail string `json:"author_email"`
+	AuthorDate  string `json:"author_date"`
+	Subject     string `json:"subject"`
+}
+
+// collectRangeOutputs resolves the base of the build's changes, then collects the requested commit range and changed files.
+// Failures are only reported as warnings, the checkout itself has already succeeded at this point.
+// The history is deepened through the given fetched refs (see checkoutStrategy.fetchTargets) and the base.
+func (g GitCloner) collectRangeOutputs(gitCmd git.Git, cfg Config, isPR bool, targets []fetchTarget) (*commitRange, *changedFiles) {
+	g.logger.Println()
+	g.logger.Infof("Resolving the base of the changes")
+
+	base, err := g.resolveRangeBase(gitCmd, cfg, isPR, targets)
+	if err != nil {
+		g.logger.Warnf("Commit range and changed files are not exported: %s", err)
+		return nil, nil
+	}
+
+	var commits *commitRange
+	if cfg.ExportCommitRange || cfg.scansCommitRange() {
+		if commits, err = listCommitRange(gitCmd, base); err != nil {
+			g.logger.Warnf("Commit range is not exported: failed to list commits: %s", err)
+		} else {
+			g.logger.Printf("%d commit(s) since %s", len(commits.commits), base)
+		}
+	}
+
+	var changes *changedFiles
+	if cfg.ExportChangedFiles {
+		if changes, err = listChangedFiles(gitCmd, base, cfg.ChangedFilesPatterns); err != nil {
+			g.logger.Warnf("Changed files are not exported: %s", err)
+		} else {
+			g.logger.Printf("%d file(s) changed since %s", len(changes.files), base)
+		}
+	}
+
+	return commits, changes
+}
+
+// resolveRangeBase returns the merge-base of the configured base and HEAD
+func (g GitCloner) resolveRangeBase(gitCmd git.Git, cfg Config, isPR bool, targets []fetchTarget) (string, error) {
+	baseRef, baseRefspec := selectCommitRangeBase(cfg, isPR)
+	if baseRef == "" {
+		return "", errors.New("no base is available, set the commit range base input for non Pull Request builds")
+	}
+
+	gitDir := cfg.commonGitDir()
+	base, err := fetchCommitRangeBase(gitCmd, gitDir, newHistoryLimit(cfg), baseRef, baseRefspec)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch the base (%s): %w", baseRef, err)
+	}
+
+	targets = appendFetchTarget(targets, fetchTarget{remote: originRemoteName, ref: baseRefspec})
+	mergeBase, err := deepenUntilMergeBase(gitCmd, gitDir, targets, base, "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("the base (%s) is not reachable from the checked out commit: %w", baseRef, err)
+	}
+
+	return mergeBase, nil
+}
+
+func listCommitRange(gitCmd git.Git, base string) (*commitRange, error) {
+	out, err := runner.RunForOutput(gitCommand(gitCmd, "log", "--format="+commitRangeLogFormat, base+"..HEAD"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &commitRange{
+		base:    base,
+		commits: parseCommitRangeLog(out),
+	}, nil
+}
+
+// selectCommitRangeBase returns the base of the commit range and the refspec it can be fetched with.
+// A configured base takes precedence, Pull Requests are compared to their destination branch by default.
+func selectCommitRangeBase(cfg Config, isPR bool) (ref string, refspec string) {
+	if cfg.CommitRangeBase != "" {
+		return cfg.CommitRangeBase, cfg.CommitRangeBase
+	}
+
+	if isPR && cfg.PRDestBranch != "" {
+		remoteBranchRef := fmt.Sprintf("refs/remotes/%s/%s", originRemoteName, cfg.PRDestBranch)
+		return remoteBranchRef, fmt.Sprintf("%s%s:%s", refsHeadsPrefix, cfg.PRDestBranch, remoteBranchRef)
+	}
+
+	return "", ""
+}
+
+// fetchCommitRangeBase makes sure the base commit is available locally and returns its hash
+func fetchCommitRangeBase(gitCmd git.Git, gitDir string, limit historyLimit, ref, refspec string) (string, error) {
+	if hash, err := runner.RunForOutput(gitCommand(gitCmd, "rev-parse", "--verify", "--quiet", ref+"^{commit}")); err == nil {
+		return hash, nil
+	}
+
+	fetchBase := func(historyOpts []string) error {
+		opts := append([]string{jobsFlag}, historyOpts...)
+		opts = append(opts, "--no-tags", "--no-recurse-submodules", originRemoteName, refspec)
+
+		return runner.RunWithRetry(func() *command.Model {
+			return gitCmd.Fetch(opts...)
+		})
+	}
+
+	// In shallow clones only the history within the configured limit (or only the tip) of the base is fetched,
+	// its history is fetched later only as deep as needed
+	var historyOpts []string
+	if isShallowRepository(gitDir) {
+		historyOpts = []string{"--depth=1"}
+		if limit.isSet() {
+			historyOpts = limit.fetchFlags()
+		}
+	}
+
+	err := fetchBase(historyOpts)
+	if err != nil && limit.isSet() && strings.Contains(err.Error(), noShallowCommitsSelectedError) {
+		log.Warnf("%s is outside of the requested history, fetching only its latest commit", ref)
+		err = fetchBase([]string{"--depth=1"})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return runner.RunForOutput(gitCmd.RevParse("FETCH_HEAD^{commit}"))
+}
+
+// deepenUntilMergeBase returns the merge-base of the two commits, deepening the history of the targets until one is found
+func deepenUntilMergeBase(gitCmd git.Git, gitDir string, targets []fetchTarget, commit, otherCommit string) (string, error) {
+	var mergeBase string
+	err := deepenHistoryUntil(gitCmd, gitDir, targets, func() bool {
+		out, err := runner.RunForOutput(gitCommand(gitCmd, "merge-base", commit, otherCommit))
+		mergeBase = out
+		return err == nil
+	})
+	if errors.Is(err, errHistoryComplete) {
+		return "", errNoMergeBase
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return mergeBase, nil
+}
+
+// deepenHistoryUntil deepens the history of the fetched refs until done reports true.
+// The refs are deepened instead of the shallow boundary commits for the same reason as in withDeepenTargets.
+// It returns errHistoryComplete if the history became complete without done reporting true.
+func deepenHistoryUntil(gitCmd git.Git, gitDir string, targets []fetchTarget, done func() bool) error {
+	deepen := deepenInitialCommits
+	for round := 0; ; round++ {
+		if done() {
+			return nil
+		}
+
+		boundaries, err := shallowBoundaries(gitDir)
+		if err != nil {
+			return err
+		}
+		if len(boundaries) == 0 {
+			return errHistoryComplete
+		}
+		if len(targets) == 0 {
+			return errNoDeepenTargets
+		}
+		if round == deepenMaxRounds {
+			return fmt.Errorf("%w by %d commits", errDeepenLimitReached, deepenInitialCommits*(1<<deepenMaxRounds-1))
+		}
+
+		for _, target := range targets {
+			if err := deepenFetch(gitCmd, target.remote, deepen, []string{target.ref}, unshallowFetchOptions{}); err != nil {
+				return fmt.Errorf("deepen %w", err)
+			}
+		}
+
+		deepen *= 2
+	}
+}
+
+func isShallowRepository(gitDir string) bool {
+	boundaries, err := shallowBoundaries(gitDir)
+	return err == nil && len(boundaries) > 0
+}
+
+// shallowBoundaries returns the commits whose parents are missing from the shallow history
+// See: https://git-scm.com/docs/shallow
+func shallowBoundaries(gitDir string) ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(gitDir, "shallow"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return strings.Fields(string(content)), nil
+}
+
+func parseCommitRangeLog(out string) []rangeCommit {
+	commits := []rangeCommit{}
+	for _, record := range strings.Split(out, commitRecordSeparator) {
+		record = strings.TrimSpace(record)
+		if record == "" {
+			continue
+		}
+
+		fields := strings.SplitN(record, commitFieldSeparator, 5)
+		if len(fields) < 5 {
+			continue
+		}
+
+		commits = append(commits, rangeCommit{
+			Hash:        fields[0],
+			AuthorName:  fields[1],
+			AuthorEmail: fields[2],
+			AuthorDate:  fields[3],
+			Subject:     fields[4],
+		})
+	}
+
+	return commits
+}
+
+// appendFetchTarget appends the target unless it is already listed
+func appendFetchTarget(targets []fetchTarget, target fetchTarget) []fetchTarget {
+	if slices.Contains(targets, target) {
+		return targets
+	}
+	return append(slices.Clone(targets), target)
+}
@@ -0,0 +1,227 @@
+package gitclone
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/command/git"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_selectCommitRangeBase(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         Config
+		isPR        bool
+		wantRef     string
+		wantRefspec string
+	}{
+		{
+			name:        "Configured base",
+			cfg:         Config{CommitRangeBase: "1.0.0", PRDestBranch: "master"},
+			isPR:        true,
+			wantRef:     "1.0.0",
+			wantRefspec: "1.0.0",
+		},
+		{
+			name:        "PR destination branch",
+			cfg:         Config{PRDestBranch: "master"},
+			isPR:        true,
+			wantRef:     "refs/remotes/origin/master",
+			wantRefspec: "refs/heads/master:refs/remotes/origin/master",
+		},
+		{
+			name: "No base for non-PR build",
+			cfg:  Config{PRDestBranch: "master"},
+			isPR: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref, refspec := selectCommitRangeBase(tt.cfg, tt.isPR)
+			assert.Equal(t, tt.wantRef, ref)
+			assert.Equal(t, tt.wantRefspec, refspec)
+		})
+	}
+}
+
+func Test_fetchCommitRangeBase(t *testing.T) {
+	revParseCmd := `git "rev-parse" "--verify" "--quiet" "refs/remotes/origin/master^{commit}"`
+	refspec := "refs/heads/master:refs/remotes/origin/master"
+
+	tests := []struct {
+		name       string
+		shallow    bool
+		limit      historyLimit
+		failingCmd string
+		wantCmds   []string
+	}{
+		{
+			name: "Full history",
+			wantCmds: []string{
+				revParseCmd,
+				`git "fetch" "--jobs=10" "--no-tags" "--no-recurse-submodules" "origin" "` + refspec + `"`,
+				`git "rev-parse" "FETCH_HEAD^{commit}"`,
+			},
+		},
+		{
+			name:    "Shallow history",
+			shallow: true,
+			wantCmds: []string{
+				revParseCmd,
+				`git "fetch" "--jobs=10" "--depth=1" "--no-tags" "--no-recurse-submodules" "origin" "` + refspec + `"`,
+				`git "rev-parse" "FETCH_HEAD^{commit}"`,
+			},
+		},
+		{
+			name:    "Shallow history with limit",
+			shallow: true,
+			limit:   historyLimit{since: "2024-01-31", exclude: []string{"1.0.0"}},
+			wantCmds: []string{
+				revParseCmd,
+				`git "fetch" "--jobs=10" "--shallow-since=2024-01-31" "--shallow-exclude=1.0.0" "--no-tags" "--no-recurse-submodules" "origin" "` + refspec + `"`,
+				`git "rev-parse" "FETCH_HEAD^{commit}"`,
+			},
+		},
+		{
+			name:       "Base outside of the limit",
+			shallow:    true,
+			limit:      historyLimit{since: "2024-01-31"},
+			failingCmd: `git "fetch" "--jobs=10" "--shallow-since=2024-01-31" "--no-tags" "--no-recurse-submodules" "origin" "` + refspec + `"`,
+			wantCmds: []string{
+				revParseCmd,
+				`git "fetch" "--jobs=10" "--shallow-since=2024-01-31" "--no-tags" "--no-recurse-submodules" "origin" "` + refspec + `"`,
+				`git "fetch" "--jobs=10" "--depth=1" "--no-tags" "--no-recurse-submodules" "origin" "` + refspec + `"`,
+				`git "rev-parse" "FETCH_HEAD^{commit}"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			gitDir := t.TempDir()
+			if tt.shallow {
+				assert.NoError(t, os.WriteFile(filepath.Join(gitDir, "shallow"), []byte("aaa\n"), 0600))
+			}
+
+			mockRunner := new(MockRunner).GivenRunForOutputFailsForCommand(revParseCmd, 1)
+			if tt.failingCmd != "" {
+				mockRunner.GivenRunWithRetryFailsForCommandWithError(tt.failingCmd, errors.New("fatal: no commits selected for shallow requests"))
+			}
+			mockRunner.GivenRunForOutputSucceeds().GivenRunWithRetrySucceeds()
+			runner = mockRunner
+
+			// When
+			_, err := fetchCommitRangeBase(git.Git{}, gitDir, tt.limit, "refs/remotes/origin/master", refspec)
+
+			// Then
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantCmds, mockRunner.Cmds())
+		})
+	}
+}
+
+func Test_deepenUntilMergeBase(t *testing.T) {
+	mergeBaseCmd := `git "merge-base" "76a934a" "HEAD"`
+
+	targets := []fetchTarget{{remote: originRemoteName, ref: "refs/heads/main"}, {remote: "fork", ref: "refs/heads/feature"}}
+
+	tests := []struct {
+		name           string
+		shallow        string
+		targets        []fetchTarget
+		mergeBaseFails int
+		wantMergeBase  string
+		wantErr        error
+		wantCmds       []string
+	}{
+		{
+			name:          "Base already reachable",
+			shallow:       "aaa\n",
+			targets:       targets,
+			wantMergeBase: "whatever",
+			wantCmds:      []string{mergeBaseCmd},
+		},
+		{
+			name:           "Deepened until reachable",
+			shallow:        "aaa\nbbb\n",
+			targets:        targets,
+			mergeBaseFails: 2,
+			wantMergeBase:  "whatever",
+			wantCmds: []string{
+				mergeBaseCmd,
+				`git "fetch" "--jobs=10" "--deepen=16" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/main"`,
+				`git "fetch" "--jobs=10" "--deepen=16" "--no-tags" "--no-recurse-submodules" "fork" "refs/heads/feature"`,
+				mergeBaseCmd,
+				`git "fetch" "--jobs=10" "--deepen=32" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/main"`,
+				`git "fetch" "--jobs=10" "--deepen=32" "--no-tags" "--no-recurse-submodules" "fork" "refs/heads/feature"`,
+				mergeBaseCmd,
+			},
+		},
+		{
+			name:           "Complete history without common ancestor",
+			targets:        targets,
+			mergeBaseFails: 1,
+			wantErr:        errNoMergeBase,
+			wantCmds:       []string{mergeBaseCmd},
+		},
+		{
+			name:           "No fetched ref to deepen",
+			shallow:        "aaa\n",
+			mergeBaseFails: 1,
+			wantErr:        errNoDeepenTargets,
+			wantCmds:       []string{mergeBaseCmd},
+		},
+		{
+			name:           "Deepen limit reached",
+			shallow:        "aaa\n",
+			targets:        targets,
+			mergeBaseFails: deepenMaxRounds + 1,
+			wantErr:        errDeepenLimitReached,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			gitDir := t.TempDir()
+			if tt.shallow != "" {
+				assert.NoError(t, os.WriteFile(filepath.Join(gitDir, "shallow"), []byte(tt.shallow), 0600))
+			}
+
+			mockRunner := new(MockRunner)
+			if tt.mergeBaseFails > 0 {
+				mockRunner.GivenRunForOutputFailsForCommand(mergeBaseCmd, tt.mergeBaseFails)
+			}
+			mockRunner.GivenRunForOutputSucceeds().GivenRunWithRetrySucceeds()
+			runner = mockRunner
+
+			// When
+			mergeBase, err := deepenUntilMergeBase(git.Git{}, gitDir, tt.targets, "76a934a", "HEAD")
+
+			// Then
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantMergeBase, mergeBase)
+			}
+			if tt.wantCmds != nil {
+				assert.Equal(t, tt.wantCmds, mockRunner.Cmds())
+			}
+		})
+	}
+}
+
+func Test_parseCommitRangeLog(t *testing.T) {
+	out := "76a934ae\x1fJane Doe\x1fjane@example.com\x1f2024-03-01T10:00:00+01:00\x1fAdd feature\x1e\n" +
+		"5b3dfe10\x1fJohn Doe\x1fjohn@example.com\x1f2024-02-28T09:30:00+01:00\x1fFix: crash | on start\x1e"
+
+	want := []rangeCommit{
+		{Hash: "76a934ae", AuthorName: "Jane Doe", AuthorEmail: "jane@example.com", AuthorDate: "2024-03-01T10:00:00+01:00", Subject: "Add feature"},
+		{Hash: "5b3dfe10", AuthorName: "John Doe", AuthorEmail: "john@example.com", AuthorDate: "2024-02-28T09:30:00+01:00", Subject: "Fix: crash | on start"},
+	}
+	assert.Equal(t, want, parseCommitRangeLog(out))
+	assert.Equal(t, []rangeCommit{}, parseCommitRangeLog(""))
+}
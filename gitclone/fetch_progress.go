@@ -0,0 +1,252 @@
+package gitclone
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// Git redraws its progress lines many times per second, only the latest state is printed this often.
+// The heartbeat is printed even if git reports no progress, so a stalled fetch is visible in the log.
+const fetchProgressInterval = 10 * time.Second
+
+// FetchStats are the transfer totals of a single git fetch, parsed from its progress output
+type FetchStats struct {
+	Objects int64
+	Bytes   int64
+	// Throughput is the last transfer rate (bytes per second) reported by git
+	Throughput int64
+	Duration   time.Duration
+}
+
+// Examples:
+// Receiving objects:  45% (556/1234), 1.20 MiB | 2.40 MiB/s
+// Unpacking objects: 100% (9/9), 700 bytes | 700.00 KiB/s, done.
+// remote: Enumerating objects: 1234, done.
+var progressLinePattern = regexp.MustCompile(`^(?:remote: )?([A-Z][A-Za-z ]+):\s+(?:\d+% \((\d+)/(\d+)\)|(\d+))(?:, ([\d.]+ (?:bytes?|KiB|MiB|GiB)) \| ([\d.]+ (?:bytes?|KiB|MiB|GiB))/s)?`)
+
+// fetchProgressWriter consumes the stderr of `git fetch --progress`.
+// Progress lines are condensed into periodic heartbeat log lines, everything else is passed through to out.
+type fetchProgressWriter struct {
+	out io.Writer
+	// prefix is the output prefix of the repository (see CommandRunner.SetOutputPrefix), printed before the heartbeat log lines
+	prefix    string
+	printf    func(format string, v ...interface{})
+	startTime time.Time
+	now       func() time.Time
+
+	pending []byte
+	stats   FetchStats
+
+	// mu guards the latest progress line, which is read by the heartbeat goroutine
+	mu               sync.Mutex
+	lastProgress     string
+	lastProgressTime time.Time
+
+	stopHeartbeat chan struct{}
+	heartbeatDone chan struct{}
+}
+
+func newFetchProgressWriter(out io.Writer, prefix string) *fetchProgressWriter {
+	now := time.Now()
+	return &fetchProgressWriter{
+		out:              out,
+		prefix:           prefix,
+		printf:           log.Printf,
+		startTime:        now,
+		now:              time.Now,
+		lastProgressTime: now,
+	}
+}
+
+// startHeartbeat prints the latest progress periodically until the writer is closed
+func (w *fetchProgressWriter) startHeartbeat(interval time.Duration) {
+	w.stopHeartbeat = make(chan struct{})
+	w.heartbeatDone = make(chan struct{})
+
+	go func() {
+		defer close(w.heartbeatDone)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stopHeartbeat:
+				return
+			case <-ticker.C:
+				w.printf("%s%s", w.prefix, w.heartbeat())
+			}
+		}
+	}()
+}
+
+// heartbeat returns the latest progress, or how long the fetch has been stalled
+func (w *fetchProgressWriter) heartbeat() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	elapsed := now.Sub(w.startTime).Round(time.Second)
+	stalled := now.Sub(w.lastProgressTime).Round(time.Second)
+	switch {
+	case w.lastProgress == "":
+		return fmt.Sprintf("[%s] No progress reported by git yet", elapsed)
+	case stalled >= fetchProgressInterval:
+		return fmt.Sprintf("[%s] No progress for %s: %s", elapsed, stalled, w.lastProgress)
+	default:
+		return fmt.Sprintf("[%s] %s", elapsed, w.lastProgress)
+	}
+}
+
+// Write ...
+func (w *fetchProgressWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+
+	for {
+		idx := bytes.IndexAny(w.pending, "\r\n")
+		if idx < 0 {
+			break
+		}
+
+		line := string(w.pending[:idx])
+		isFinal := w.pending[idx] == '\n'
+		w.pending = w.pending[idx+1:]
+
+		if err := w.processLine(line, isFinal); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close stops the heartbeat, flushes the incomplete last line and returns the totals of the fetch
+func (w *fetchProgressWriter) Close() FetchStats {
+	if w.stopHeartbeat != nil {
+		close(w.stopHeartbeat)
+		<-w.heartbeatDone
+	}
+
+	if len(w.pending) > 0 {
+		line := string(w.pending)
+		w.pending = nil
+		if err := w.processLine(line, true); err != nil {
+			log.Warnf("%sFailed to write fetch output: %s", w.prefix, err)
+		}
+	}
+
+	w.stats.Duration = w.now().Sub(w.startTime)
+	return w.stats
+}
+
+func (w *fetchProgressWriter) processLine(line string, isFinal bool) error {
+	match := progressLinePattern.FindStringSubmatch(line)
+	if match == nil {
+		if line == "" && !isFinal {
+			return nil
+		}
+		_, err := fmt.Fprintln(w.out, line)
+		return err
+	}
+
+	w.updateStats(match)
+	w.setProgress(line)
+
+	// Completed progress lines (such as "Receiving objects: 100% (9/9), done.") are short and informative enough to be kept
+	if isFinal {
+		_, err := fmt.Fprintln(w.out, line)
+		return err
+	}
+
+	return nil
+}
+
+func (w *fetchProgressWriter) setProgress(line string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// A redrawn, unchanged line is not progress
+	if line == w.lastProgress {
+		return
+	}
+	w.lastProgress = line
+	w.lastProgressTime = w.now()
+}
+
+func (w *fetchProgressWriter) updateStats(match []string) {
+	title := match[1]
+	if title != "Receiving objects" && title != "Unpacking objects" {
+		return
+	}
+
+	if total, err := strconv.ParseInt(match[3], 10, 64); err == nil {
+		w.stats.Objects = total
+	}
+	if match[5] != "" {
+		w.stats.Bytes = parseHumanisedBytes(match[5])
+	}
+	if match[6] != "" {
+		w.stats.Throughput = parseHumanisedBytes(match[6])
+	}
+}
+
+// parseHumanisedBytes parses the byte counts git prints in progress lines (for example "700 bytes" or "1.20 MiB")
+func parseHumanisedBytes(s string) int64 {
+	var value float64
+	var unit string
+	if _, err := fmt.Sscanf(s, "%g %s", &value, &unit); err != nil {
+		return 0
+	}
+
+	multiplier := map[string]float64{
+		"byte":  1,
+		"bytes": 1,
+		"KiB":   1 << 10,
+		"MiB":   1 << 20,
+		"GiB":   1 << 30,
+	}[unit]
+
+	return int64(value * multiplier)
+}
+
+func (s FetchStats) String() string {
+	return fmt.Sprintf("%d objects, %s in %s (%s/s)", s.Objects, humaniseBytes(s.Bytes), s.Duration.Round(time.Millisecond), humaniseBytes(s.Throughput))
+}
+
+func humaniseBytes(b int64) string {
+	switch {
+	case b >= 1<<30:
+		return fmt.Sprintf("%.2f GiB", float64(b)/(1<<30))
+	case b >= 1<<20:
+		return fmt.Sprintf("%.2f MiB", float64(b)/(1<<20))
+	case b >= 1<<10:
+		return fmt.Sprintf("%.2f KiB", float64(b)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", b)
+	}
+}
+
+// withFetchProgress makes git report the fetch progress, which it only does by default if stderr is a terminal
+func withFetchProgress(c *command.Model) {
+	cmd := c.GetCmd()
+	for _, arg := range cmd.Args {
+		if arg == "--progress" || arg == "--quiet" || arg == "-q" {
+			return
+		}
+	}
+
+	args := []string{cmd.Args[0], cmd.Args[1], "--progress"}
+	cmd.Args = append(args, cmd.Args[2:]...)
+}
+
+func isFetchCommand(c *command.Model) bool {
+	args := c.GetCmd().Args
+	return len(args) > 1 && args[0] == "git" && args[1] == "fetch"
+}
@@ -0,0 +1,147 @@
+package gitclone
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchProgressWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newFetchProgressWriter(&out, "")
+	now := w.startTime
+	w.now = func() time.Time { return now }
+
+	write := func(s string) {
+		_, err := w.Write([]byte(s))
+		require.NoError(t, err)
+	}
+
+	write("remote: Enumerating objects: 1234, done.\n")
+	write("Receiving objects:  10% (124/1234), 1.00 MiB | 2.00 MiB/s\r")
+	now = now.Add(5 * time.Second)
+	write("Receiving objects:  45% (556/1234), 2.50 MiB | 2.40 MiB/s\r")
+	now = now.Add(5 * time.Second)
+	write("Receiving objects: 100% (1234/1234), 5.00 MiB | 3.00 MiB/s, done.\n")
+	write("Resolving deltas: 100% (300/300), done.\n")
+	write("From https://github.com/bitrise-io/git-clone-test")
+
+	stats := w.Close()
+
+	require.Equal(t, FetchStats{
+		Objects:    1234,
+		Bytes:      5 << 20,
+		Throughput: 3 << 20,
+		Duration:   10 * time.Second,
+	}, stats)
+	require.Equal(t, `remote: Enumerating objects: 1234, done.
+Receiving objects: 100% (1234/1234), 5.00 MiB | 3.00 MiB/s, done.
+Resolving deltas: 100% (300/300), done.
+From https://github.com/bitrise-io/git-clone-test
+`, out.String())
+}
+
+func TestFetchProgressWriter_Heartbeat(t *testing.T) {
+	w := newFetchProgressWriter(&bytes.Buffer{}, "")
+	now := w.startTime
+	w.now = func() time.Time { return now }
+
+	now = now.Add(10 * time.Second)
+	require.Equal(t, "[10s] No progress reported by git yet", w.heartbeat())
+
+	require.NoError(t, w.processLine("Receiving objects:  10% (124/1234), 1.00 MiB | 2.00 MiB/s", false))
+	now = now.Add(5 * time.Second)
+	require.Equal(t, "[15s] Receiving objects:  10% (124/1234), 1.00 MiB | 2.00 MiB/s", w.heartbeat())
+
+	// The fetch is stalled, git only redraws the same line
+	now = now.Add(5 * time.Second)
+	require.NoError(t, w.processLine("Receiving objects:  10% (124/1234), 1.00 MiB | 2.00 MiB/s", false))
+	now = now.Add(20 * time.Second)
+	require.Equal(t, "[40s] No progress for 30s: Receiving objects:  10% (124/1234), 1.00 MiB | 2.00 MiB/s", w.heartbeat())
+}
+
+func TestFetchProgressWriter_HeartbeatWithoutOutput(t *testing.T) {
+	w := newFetchProgressWriter(&bytes.Buffer{}, "")
+	var heartbeats atomic.Int32
+	w.now = func() time.Time {
+		heartbeats.Add(1)
+		return time.Now()
+	}
+
+	w.startHeartbeat(10 * time.Millisecond)
+	require.Eventually(t, func() bool { return heartbeats.Load() >= 2 }, time.Second, 10*time.Millisecond)
+	w.Close()
+}
+
+func TestFetchProgressWriter_HeartbeatPrefix(t *testing.T) {
+	w := newFetchProgressWriter(&bytes.Buffer{}, "[sdk] ")
+	lines := make(chan string, 10)
+	w.printf = func(format string, v ...interface{}) {
+		lines <- fmt.Sprintf(format, v...)
+	}
+
+	w.startHeartbeat(10 * time.Millisecond)
+	line := <-lines
+	w.Close()
+
+	require.True(t, strings.HasPrefix(line, "[sdk] ["), line)
+	require.Contains(t, line, "No progress reported by git yet")
+}
+
+func Test_parseHumanisedBytes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{in: "1 byte", want: 1},
+		{in: "700 bytes", want: 700},
+		{in: "1.50 KiB", want: 1536},
+		{in: "2.00 MiB", want: 2 << 20},
+		{in: "1.00 GiB", want: 1 << 30},
+		{in: "invalid", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			require.Equal(t, tt.want, parseHumanisedBytes(tt.in))
+		})
+	}
+}
+
+func Test_withFetchProgress(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "progress added",
+			args: []string{"fetch", "--jobs=10", "origin", "master"},
+			want: []string{"git", "fetch", "--progress", "--jobs=10", "origin", "master"},
+		},
+		{
+			name: "progress already enabled",
+			args: []string{"fetch", "--progress", "origin"},
+			want: []string{"git", "fetch", "--progress", "origin"},
+		},
+		{
+			name: "quiet fetch",
+			args: []string{"fetch", "--quiet", "origin"},
+			want: []string{"git", "fetch", "--quiet", "origin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := command.New("git", tt.args...)
+			require.True(t, isFetchCommand(cmd))
+
+			withFetchProgress(cmd)
+			require.Equal(t, tt.want, cmd.GetCmd().Args)
+		})
+	}
+}
@@ -2,12 +2,16 @@ package gitclone
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/bitrise-io/go-utils/command"
 	"github.com/bitrise-io/go-utils/command/git"
 	"github.com/bitrise-io/go-utils/pathutil"
 	"github.com/bitrise-io/go-utils/sliceutil"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/repourl"
 )
 
 const (
@@ -18,28 +22,38 @@ const (
 
 var runner CommandRunner = &DefaultRunner{}
 
-func isOriginPresent(gitCmd git.Git, dir, repoURL string) (bool, error) {
+// originURL returns the URL of the origin remote of the repository in dir,
+// or an empty string if there is no repository (or it has no origin remote) yet
+func originURL(gitCmd git.Git, dir string) (string, error) {
 	absDir, err := pathutil.AbsPath(dir)
 	if err != nil {
-		return false, err
+		return "", err
 	}
 
+	// .git is a file in linked worktrees and submodules
 	gitDir := filepath.Join(absDir, ".git")
-	if exist, err := pathutil.IsDirExists(gitDir); err != nil {
-		return false, err
-	} else if exist {
-		remotes, err := runner.RunForOutput(gitCmd.RemoteList())
-		if err != nil {
-			return false, err
-		}
+	if exist, err := pathutil.IsPathExists(gitDir); err != nil {
+		return "", err
+	} else if !exist {
+		return "", nil
+	}
 
-		if !strings.Contains(remotes, repoURL) {
-			return false, fmt.Errorf(".git folder exists in the directory (%s), but using a different remote", dir)
-		}
-		return true, nil
+	remotes, err := runner.RunForOutput(gitCmd.RemoteList())
+	if err != nil {
+		return "", err
 	}
+	return parseRemoteURL(remotes, originRemoteName), nil
+}
 
-	return false, nil
+// parseRemoteURL returns the fetch URL of the remote from the output of `git remote -v`
+func parseRemoteURL(remoteList, remote string) string {
+	for _, line := range strings.Split(remoteList, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == remote && fields[2] == "(fetch)" {
+			return fields[1]
+		}
+	}
+	return ""
 }
 
 func resetRepo(gitCmd git.Git) error {
@@ -56,39 +70,45 @@ func resetRepo(gitCmd git.Git) error {
 }
 
 func isFork(repoURL, prRepoURL string) bool {
-	return prRepoURL != "" && getRepo(repoURL) != getRepo(prRepoURL)
+	return prRepoURL != "" && !isSameRepo(repoURL, prRepoURL)
 }
 
-// formats:
-// https://hostname/owner/repository.git
-// git@hostname:owner/repository.git
-// ssh://git@hostname:port/owner/repository.git
-func getRepo(url string) string {
-	var host, repo string
-	switch {
-	case strings.HasPrefix(url, "https://"):
-		url = strings.TrimPrefix(url, "https://")
-		idx := strings.Index(url, "/")
-		host, repo = url[:idx], url[idx+1:]
-	case strings.HasPrefix(url, "git@"):
-		url = url[strings.Index(url, "@")+1:]
-		idx := strings.Index(url, ":")
-		host, repo = url[:idx], url[idx+1:]
-	case strings.HasPrefix(url, "ssh://"):
-		url = url[strings.Index(url, "@")+1:]
-		if strings.Contains(url, ":") {
-			idxColon, idxSlash := strings.Index(url, ":"), strings.Index(url, "/")
-			host, repo = url[:idxColon], url[idxSlash+1:]
-		} else {
-			idx := strings.Index(url, "/")
-			host, repo = url[:idx], url[idx+1:]
-		}
+// isSameRepo reports whether the URLs point to the same repository, regardless of the transport and the .git suffix
+func isSameRepo(url, otherURL string) bool {
+	repo, err := repourl.Parse(url)
+	otherRepo, otherErr := repourl.Parse(otherURL)
+	if err != nil || otherErr != nil {
+		return strings.TrimSpace(url) == strings.TrimSpace(otherURL)
 	}
-	return host + "/" + strings.TrimSuffix(repo, ".git")
+	return repo.Equal(otherRepo)
 }
 
-func isPrivate(repoURL string) bool {
-	return strings.HasPrefix(repoURL, "git")
+// forkAccessProbe checks whether the branch of the Pull Request's source repository can be fetched
+type forkAccessProbe func(url, branch string) error
+
+// lsRemoteTimeout bounds the ref advertisement request of the fork probe, which should be quick as only a single ref is listed
+const lsRemoteTimeout = 30 * time.Second
+
+// newForkAccessProbe probes the fork with a ref advertisement (git ls-remote) of the source branch.
+// It uses the same credentials (SSH key, .netrc) as the fetch would, but never prompts for them.
+func newForkAccessProbe(gitCmd git.Git) forkAccessProbe {
+	return func(url, branch string) error {
+		ref := "HEAD"
+		if branch != "" {
+			ref = "refs/heads/" + branch
+		}
+
+		cmd := gitCommand(gitCmd, "ls-remote", "--exit-code", url, ref)
+		cmd.AppendEnvs("GIT_TERMINAL_PROMPT=0")
+		if os.Getenv("GIT_SSH_COMMAND") == "" {
+			cmd.AppendEnvs("GIT_SSH_COMMAND=ssh -o BatchMode=yes -o ConnectTimeout=10")
+		}
+		// --exit-code fails (without any error message) if the branch doesn't exist
+		if err := runner.RunWithTimeout(cmd, lsRemoteTimeout); err != nil {
+			return fmt.Errorf("listing %s failed: %w", ref, err)
+		}
+		return nil
+	}
 }
 
 type getAvailableBranches func() (map[string][]string, error)
@@ -220,3 +240,16 @@ func isWorkingTreeClean(gitCmd git.Git) (bool, error) {
 	}
 	return strings.TrimSpace(out) == "", nil
 }
+
+// gitCommand creates a command for the git subcommands that git.Git doesn't provide.
+// It runs in the project directory with the environment of the git.Git commands (no credential prompts).
+func gitCommand(gitCmd git.Git, args ...string) *command.Model {
+	return command.New("git", args...).
+		SetDir(projectDir(gitCmd)).
+		SetEnvs(append(os.Environ(), "GIT_ASKPASS=echo")...)
+}
+
+// projectDir returns the directory of the project, git.Git doesn't expose it but runs all of its commands there
+func projectDir(gitCmd git.Git) string {
+	return gitCmd.Status().GetCmd().Dir
+}
@@ -7,42 +7,40 @@ import (
 
 	"github.com/bitrise-io/bitrise-init/errormapper"
 	"github.com/bitrise-io/go-steputils/step"
+	"github.com/bitrise-io/go-utils/command/git"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func Test_getRepo(t *testing.T) {
-	tests := []struct {
-		name string
-		url  string
-		want string
-	}{
-		{
-			name: "HTTPS URL",
-			url:  "https://github.com/bitrise-samples/git-clone-test.git",
-			want: "github.com/bitrise-samples/git-clone-test",
-		},
-		{
-			name: "Long SSH URL",
-			url:  "ssh://git@github.com/bitrise-samples/git-clone-test.git",
-			want: "github.com/bitrise-samples/git-clone-test",
-		},
-		{
-			name: "Long SSH URL with a specific port",
-			url:  "ssh://git@github.com:22/bitrise-samples/git-clone-test.git",
-			want: "github.com/bitrise-samples/git-clone-test",
-		},
-		{
-			name: "Short SSH URL",
-			url:  "git@github.com:bitrise-samples/git-clone-test.git",
-			want: "github.com/bitrise-samples/git-clone-test",
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := getRepo(tt.url); got != tt.want {
-				t.Errorf("getRepo() = %v, want %v", got, tt.want)
-			}
-		})
-	}
+func Test_gitCommand(t *testing.T) {
+	dir := t.TempDir()
+	gitCmd, err := git.New(dir)
+	require.NoError(t, err)
+
+	cmd := gitCommand(gitCmd, "ls-remote", "--tags", "origin").GetCmd()
+
+	assert.Equal(t, []string{"git", "ls-remote", "--tags", "origin"}, cmd.Args)
+	assert.Equal(t, dir, cmd.Dir)
+	assert.Contains(t, cmd.Env, "GIT_ASKPASS=echo")
+}
+
+func Test_isSameRepo(t *testing.T) {
+	assert.True(t, isSameRepo("https://github.com/bitrise-io/git-clone-test", "git@github.com:bitrise-io/git-clone-test.git"))
+	assert.True(t, isSameRepo("ssh://git@github.com:22/bitrise-io/git-clone-test.git", "https://github.com/bitrise-io/git-clone-test.git"))
+	assert.False(t, isSameRepo("https://github.com/bitrise-io/git-clone-test.git", "https://github.com/bitrise-io/other-repo.git"))
+	assert.False(t, isSameRepo("https://github.com/bitrise-io/git-clone-test.git", "https://gitlab.com/bitrise-io/git-clone-test.git"))
+	assert.True(t, isSameRepo("git@ssh.dev.azure.com:v3/org/project/git-clone-test", "https://org@dev.azure.com/org/project/_git/git-clone-test"))
+}
+
+func Test_parseRemoteURL(t *testing.T) {
+	remoteList := `fork	git@github.com:bitrise-io/other-repo.git (fetch)
+fork	git@github.com:bitrise-io/other-repo.git (push)
+origin	https://github.com/bitrise-io/git-clone-test.git (fetch)
+origin	https://github.com/bitrise-io/git-clone-test-push.git (push)`
+
+	assert.Equal(t, "https://github.com/bitrise-io/git-clone-test.git", parseRemoteURL(remoteList, originRemoteName))
+	assert.Equal(t, "git@github.com:bitrise-io/other-repo.git", parseRemoteURL(remoteList, forkRemoteName))
+	assert.Equal(t, "", parseRemoteURL(remoteList, "upstream"))
 }
 
 func Test_parseListBranchesOutput(t *testing.T) {
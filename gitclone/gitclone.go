@@ -2,12 +2,20 @@ package gitclone
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	v1command "github.com/bitrise-io/go-utils/command"
 	"github.com/bitrise-io/go-utils/command/git"
 	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/bitrise-steplib/steps-git-clone/gitclone/bitriseapi"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/repourl"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/trace2"
 	"github.com/bitrise-steplib/steps-git-clone/gitclone/tracker"
 )
 
@@ -17,6 +25,7 @@ const (
 	forkRemoteName           = "fork"
 	updateSubmoduleFailedTag = "update_submodule_failed"
 	sparseCheckoutFailedTag  = "sparse_checkout_failed"
+	commandTimedOutTag       = "command_timed_out"
 )
 
 // Config is the git clone step configuration
@@ -30,6 +39,23 @@ type Config struct {
 	FetchTags                  bool
 	SparseDirectories          []string
 	IgnoreBranchForCommitFetch bool
+	// ShallowSince and ShallowExclude limit the fetched history by date or by refs instead of CloneDepth
+	ShallowSince   string
+	ShallowExclude []string
+	// DeepenSteps are the number of commits the shallow history is deepened by (one step after the other)
+	// before unshallowing, when a checkout or merge fails because of the shallow history
+	DeepenSteps []int
+
+	CommandTimeouts CommandTimeouts
+	// LowSpeedLimit (bytes per second) and LowSpeedTime (seconds) abort a HTTP transfer that is slower than the limit
+	// for longer than the given time, see http.lowSpeedLimit in https://git-scm.com/docs/git-config
+	LowSpeedLimit int
+	LowSpeedTime  int
+	// WorkspaceLockTimeout is the time to wait for another process (build) to release the lock of the clone directory
+	WorkspaceLockTimeout time.Duration
+	// WorktreeCacheDir enables the worktree mode if set: the clone directory is created as a linked worktree
+	// of a bare repository in this directory, shared by the builds of the same remote
+	WorktreeCacheDir string
 
 	RepositoryURL         string
 	Commit                string
@@ -41,7 +67,63 @@ type Config struct {
 	PRUnverifiedMergeRef  string
 	PRHeadBranch          string
 
+	// ExportCommitRange enables collecting the commits between CommitRangeBase (a commit or tag) and the checked out state.
+	// Pull Requests are compared to PRDestBranch if no base is set.
+	ExportCommitRange bool
+	CommitRangeBase   string
+	// ExportChangedFiles enables listing the files changed since the CommitRangeBase,
+	// ChangedFilesPatterns are the path globs checked against the changes
+	ExportChangedFiles   bool
+	ChangedFilesPatterns []string
+	// ExportVersionInfo enables describing HEAD with the nearest tag matching VersionTagPattern
+	ExportVersionInfo bool
+	VersionTagPattern string
+	// SignaturePolicy controls the verification of the checked out commit's (or tag's) signature.
+	// GPG signatures are accepted if signed by a key of SignatureKeyring (a public key file),
+	// SSH signatures if signed by a key listed in SignatureAllowedSigners (an allowed signers file).
+	SignaturePolicy         SignaturePolicy
+	SignatureKeyring        string
+	SignatureAllowedSigners string
+
+	// CommitOutputs are the user defined commit metadata outputs
+	CommitOutputs []CommitOutput
+	// CommitTrailers are the trailer keys (for example Signed-off-by) exported from the commit messages,
+	// IssueKeyPattern matches the issue keys exported from the branch name and the commit messages.
+	// ScanCommitRange extends both from the build trigger commit to the commits of the commit range.
+	CommitTrailers  []string
+	IssueKeyPattern *regexp.Regexp
+	ScanCommitRange bool
+	// OutputFilesDir is the directory of the JSON and dotenv files containing all outputs (a temporary directory if empty)
+	OutputFilesDir string
+
 	ResetRepository bool
+	// RemoteMismatchPolicy controls what happens if the clone directory already contains a different repository
+	RemoteMismatchPolicy RemoteMismatchPolicy
+	// NonEmptyDirPolicy controls what happens if the clone directory contains files, but no git repository
+	NonEmptyDirPolicy NonEmptyDirPolicy
+
+	// AdditionalRepositories are checked out (in parallel) after the main repository
+	AdditionalRepositories []AdditionalRepository
+
+	// name is the name of the additional repository, empty for the main repository
+	name string
+	// tempRefs names the temporary refs of the checkout, these are namespaced per worktree in worktree mode
+	tempRefs tempRefs
+	// gitCommonDir is the git directory shared by the worktrees (the .git directory of a regular clone)
+	gitCommonDir string
+}
+
+// commonGitDir returns the git directory containing the refs, the objects and the shallow file of the repository
+func (cfg Config) commonGitDir() string {
+	if cfg.gitCommonDir != "" {
+		return cfg.gitCommonDir
+	}
+	return filepath.Join(cfg.CloneIntoDir, ".git")
+}
+
+// scansCommitRange reports whether the commit messages of the commit range are scanned for trailers or issue keys
+func (cfg Config) scansCommitRange() bool {
+	return cfg.ScanCommitRange && (len(cfg.CommitTrailers) > 0 || cfg.IssueKeyPattern != nil)
 }
 
 type GitCloner struct {
@@ -65,31 +147,102 @@ func NewGitCloner(logger log.Logger, tracker tracker.StepTracker, cmdFactory com
 }
 
 type CheckoutStateResult struct {
-	gitRef string
-	isPR   bool
-	gitCmd git.Git
+	gitRef         string
+	isPR           bool
+	gitCmd         git.Git
+	phaseTimings   trace2.Summary
+	checkoutReport *checkoutReport
+	commitRange    *commitRange
+	changedFiles   *changedFiles
+	versionInfo    *versionInfo
+	prMergeInfo    *prMergeInfo
+	signature      *signatureInfo
+	// commitOutputs, commitMessageScan and outputFilesDir are passed on to the OutputExporter
+	commitOutputs     []CommitOutput
+	commitMessageScan commitMessageScan
+	outputFilesDir    string
+	// additionalRepositories are in the order of the configuration
+	additionalRepositories []additionalRepositoryResult
 }
 
 // CheckoutState is the entry point of the git clone process
 func (g GitCloner) CheckoutState(cfg Config) (CheckoutStateResult, error) {
 	defer g.tracker.Wait()
+	// The trace2 events are only needed for the phase timings of the main repository, which are summarized during its checkout
+	defer runner.StopPerformanceMonitoring()
+	// The additional repositories inherit the timeouts, these are set once as the repositories are checked out in parallel
+	runner.SetTimeouts(cfg.CommandTimeouts)
+
+	result, err := g.checkout(cfg)
+	if err != nil {
+		return CheckoutStateResult{}, err
+	}
+
+	if len(cfg.AdditionalRepositories) > 0 {
+		if result.additionalRepositories, err = g.checkoutAdditionalRepositories(cfg); err != nil {
+			return CheckoutStateResult{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// checkout checks out the repository of the config into its clone directory
+func (g GitCloner) checkout(cfg Config) (CheckoutStateResult, error) {
+	lock, err := acquireWorkspaceLock(cfg.CloneIntoDir, cfg.WorkspaceLockTimeout, g.logger)
+	if err != nil {
+		return CheckoutStateResult{}, newStepError(
+			"workspace_lock_failed",
+			fmt.Errorf("locking the clone directory failed: %v", err),
+			"Locking the clone directory failed",
+		)
+	}
+	defer func() {
+		if err := lock.release(); err != nil {
+			g.logger.Warnf("Failed to release the lock of the clone directory: %s", err)
+		}
+	}()
+
+	if err := g.prepareCloneDir(cfg); err != nil {
+		return CheckoutStateResult{}, err
+	}
+
+	var wt *worktree
+	if cfg.WorktreeCacheDir != "" {
+		wt, err = g.setupWorktree(cfg)
+		if err != nil {
+			return CheckoutStateResult{}, err
+		}
+	}
 
 	gitCmd, err := git.New(cfg.CloneIntoDir)
 	if err != nil {
+		if wt != nil {
+			g.finishWorktree(wt, gitCmd, cfg.CloneIntoDir)
+		}
 		return CheckoutStateResult{}, newStepError(
 			"git_new",
 			fmt.Errorf("failed to create git project directory: %v", err),
 			"Creating new git project directory failed",
 		)
 	}
+	if wt != nil {
+		defer g.finishWorktree(wt, gitCmd, cfg.CloneIntoDir)
+		cfg.tempRefs = wt.refs
+
+		var limited bool
+		if cfg, limited = withoutHistoryLimit(cfg); limited {
+			g.logger.Warnf("The history limit (clone depth, shallow since and exclude) is ignored in worktree mode, the full history is fetched")
+		}
+	}
+	// A previous build might have been killed in the middle of a git command in the reused clone directory
+	if _, err := os.Lstat(filepath.Join(cfg.CloneIntoDir, ".git")); err == nil {
+		g.recoverInterruptedRun(gitCmd)
+	}
 
-	originPresent, err := isOriginPresent(gitCmd, cfg.CloneIntoDir, cfg.RepositoryURL)
+	originPresent, err := g.reconcileOrigin(gitCmd, cfg)
 	if err != nil {
-		return CheckoutStateResult{}, newStepError(
-			"check_origin_present_failed",
-			fmt.Errorf("checking if origin is present failed: %v", err),
-			"Checking whether origin is present failed",
-		)
+		return CheckoutStateResult{}, err
 	}
 
 	if originPresent && cfg.ResetRepository {
@@ -101,23 +254,33 @@ func (g GitCloner) CheckoutState(cfg Config) (CheckoutStateResult, error) {
 			)
 		}
 	}
-	if err := runner.Run(gitCmd.Init()); err != nil {
-		return CheckoutStateResult{}, newStepError(
-			"init_git_failed",
-			fmt.Errorf("initializing repository failed: %v", err),
-			"Initializing git has failed",
-		)
+	// Reinitializing a linked worktree would turn the shared repository into a non-bare repository
+	if wt == nil {
+		if err := runner.Run(gitCmd.Init()); err != nil {
+			return CheckoutStateResult{}, newStepError(
+				"init_git_failed",
+				fmt.Errorf("initializing repository failed: %v", err),
+				"Initializing git has failed",
+			)
+		}
 	}
 	if !originPresent {
 		if err := runner.Run(gitCmd.RemoteAdd(originRemoteName, cfg.RepositoryURL)); err != nil {
 			return CheckoutStateResult{}, newStepError(
 				"add_remote_failed",
-				fmt.Errorf("adding remote repository failed (%s): %v", cfg.RepositoryURL, err),
+				fmt.Errorf("adding remote repository failed (%s): %v", repourl.Redact(cfg.RepositoryURL), err),
 				"Adding remote repository failed",
 			)
 		}
 	}
 
+	// In a linked worktree .git is a file, the refs and the shallow file are in the git directory of the shared repository
+	if commonDir, err := runner.RunForOutput(gitCommand(gitCmd, "rev-parse", "--path-format=absolute", "--git-common-dir")); err != nil {
+		g.logger.Warnf("Failed to find the git directory of the repository: %s", err)
+	} else {
+		cfg.gitCommonDir = commonDir
+	}
+
 	// Disable automatic GC as it may be triggered by other git commands (making run times nondeterministic).
 	// And we run in ephemeral VMs anyway, so GC isn't really needed.
 	// https://mirrors.edge.kernel.org/pub/software/scm/git/docs/git-gc.html
@@ -130,7 +293,11 @@ func (g GitCloner) CheckoutState(cfg Config) (CheckoutStateResult, error) {
 		)
 	}
 
-	if err := setupSparseCheckout(gitCmd, cfg.SparseDirectories); err != nil {
+	if err := setupLowSpeedLimit(gitCmd, cfg.LowSpeedLimit, cfg.LowSpeedTime); err != nil {
+		return CheckoutStateResult{}, err
+	}
+
+	if err := setupSparseCheckout(gitCmd, cfg.SparseDirectories, wt != nil); err != nil {
 		return CheckoutStateResult{}, err
 	}
 
@@ -153,7 +320,33 @@ func (g GitCloner) CheckoutState(cfg Config) (CheckoutStateResult, error) {
 		}
 	}
 
-	checkoutStrategy, isPR, err := g.checkoutState(gitCmd, cfg)
+	// The clone directory is reused from a previous build (on persistent agents)
+	reusedWorkspace := originPresent
+	// The remote-tracking refs of the shared repository are used by the other worktrees too,
+	// the temporary refs of the worktrees are pruned by setupWorktree
+	if reusedWorkspace && wt == nil {
+		if refs, err := pruneStaleRefs(gitCmd); err != nil {
+			g.logger.Warnf("Failed to delete the refs of previous builds: %s", err)
+		} else if len(refs) > 0 {
+			g.logger.Printf("Deleted %d refs of previous builds", len(refs))
+		}
+	}
+
+	checkoutStrategy, isPR, checkoutReport, err := g.checkoutState(gitCmd, cfg, reusedWorkspace)
+	if err != nil {
+		return CheckoutStateResult{}, err
+	}
+
+	// The submodule config of the shared repository is used by the other worktrees too
+	if reusedWorkspace && wt == nil {
+		if removed, err := removeStaleSubmodules(gitCmd, cfg.CloneIntoDir); err != nil {
+			g.logger.Warnf("Failed to remove the submodules of previous builds: %s", err)
+		} else if len(removed) > 0 {
+			g.logger.Printf("Removed the submodules which are no longer part of the repository: %s", strings.Join(removed, ", "))
+		}
+	}
+
+	signature, err := g.checkSignature(gitCmd, cfg, checkoutStrategy, isPR)
 	if err != nil {
 		return CheckoutStateResult{}, err
 	}
@@ -169,38 +362,142 @@ func (g GitCloner) CheckoutState(cfg Config) (CheckoutStateResult, error) {
 		g.tracker.LogSubmoduleUpdate(updateTime)
 	}
 
+	// The phase timings only cover the checkout, the commands collecting the outputs are not monitored
+	phaseTimings := g.summarizePhaseTimings(cfg)
+	runner.StopRepositoryPerformanceMonitoring(cfg.CloneIntoDir)
+
+	var prMergeInfo *prMergeInfo
+	if isPR {
+		prMergeInfo = g.collectPRMergeInfo(gitCmd, cfg, checkoutStrategy)
+	}
+
+	var commitRange, scannedCommitRange *commitRange
+	var changedFiles *changedFiles
+	if cfg.ExportCommitRange || cfg.ExportChangedFiles || cfg.scansCommitRange() {
+		commitRange, changedFiles = g.collectRangeOutputs(gitCmd, cfg, isPR, checkoutStrategy.fetchTargets())
+		if cfg.scansCommitRange() {
+			scannedCommitRange = commitRange
+		}
+		if !cfg.ExportCommitRange {
+			commitRange = nil
+		}
+	}
+
+	var versionInfo *versionInfo
+	if cfg.ExportVersionInfo {
+		versionInfo = g.collectVersionInfo(gitCmd, cfg, checkoutStrategy.fetchTargets())
+	}
+
+	gitRef := checkoutStrategy.getBuildTriggerRef()
+	if wt != nil && gitRef != "" {
+		// The temporary refs (such as the Pull Request refs) of the worktree are deleted at the end of the checkout
+		if commit, err := runner.RunForOutput(gitCmd.RevParse(gitRef)); err == nil {
+			gitRef = commit
+		}
+	}
+
 	return CheckoutStateResult{
-		gitRef: checkoutStrategy.getBuildTriggerRef(),
-		isPR:   isPR,
-		gitCmd: gitCmd,
+		gitRef:         gitRef,
+		isPR:           isPR,
+		gitCmd:         gitCmd,
+		phaseTimings:   phaseTimings,
+		checkoutReport: checkoutReport,
+		commitRange:    commitRange,
+		changedFiles:   changedFiles,
+		versionInfo:    versionInfo,
+		prMergeInfo:    prMergeInfo,
+		signature:      signature,
+		commitOutputs:  cfg.CommitOutputs,
+		commitMessageScan: commitMessageScan{
+			trailerKeys:     cfg.CommitTrailers,
+			issueKeyPattern: cfg.IssueKeyPattern,
+			scanCommitRange: cfg.ScanCommitRange,
+			commitRange:     scannedCommitRange,
+			branch:          cfg.Branch,
+		},
+		outputFilesDir: cfg.OutputFilesDir,
 	}, nil
 }
 
-func (g GitCloner) checkoutState(gitCmd git.Git, cfg Config) (strategy checkoutStrategy, isPR bool, err error) {
+// summarizePhaseTimings processes the git trace2 events collected during the checkout (if performance monitoring is enabled).
+// Only the phase timings of the main repository are tracked.
+func (g GitCloner) summarizePhaseTimings(cfg Config) trace2.Summary {
+	eventsDir := runner.TraceEventsDir(cfg.CloneIntoDir)
+	if eventsDir == "" {
+		return nil
+	}
+
+	summary, err := trace2.SummarizeDir(eventsDir)
+	if err != nil {
+		g.logger.Warnf("Failed to process performance monitoring events: %s", err)
+		return nil
+	}
+	if len(summary) == 0 {
+		return nil
+	}
+
+	g.logger.Println()
+	g.logger.Infof("Performance summary:")
+	g.logger.Printf("%s", summary.Table())
+	if cfg.name == "" {
+		g.tracker.LogPhaseTimings(summary)
+	}
+
+	return summary
+}
+
+func (g GitCloner) checkoutState(gitCmd git.Git, cfg Config, reusedWorkspace bool) (strategy checkoutStrategy, isPR bool, report *checkoutReport, err error) {
 	checkoutStartTime := time.Now()
-	checkoutMethod, diffFile := selectCheckoutMethod(cfg, g.patchSource, g.mergeRefChecker)
+	checkoutMethod, diffFile := selectCheckoutMethod(cfg, g.patchSource, g.mergeRefChecker, newForkAccessProbe(gitCmd))
 
-	fetchOpts := selectFetchOptions(checkoutMethod, cfg.CloneDepth, cfg.FetchTags, cfg.UpdateSubmodules, len(cfg.SparseDirectories) != 0)
+	fetchOpts := selectFetchOptions(checkoutMethod, cfg.CloneDepth, newHistoryLimit(cfg), cfg.FetchTags, cfg.UpdateSubmodules, len(cfg.SparseDirectories) != 0)
 
 	checkoutStrategy, err := createCheckoutStrategy(checkoutMethod, cfg, diffFile)
 	if err != nil {
-		return nil, false, err
+		return nil, false, nil, err
 	}
 	if checkoutStrategy == nil {
-		return nil, false, fmt.Errorf("failed to select a checkout stategy")
+		return nil, false, nil, fmt.Errorf("failed to select a checkout stategy")
 	}
 
-	if err := checkoutStrategy.do(gitCmd, fetchOpts, selectFallbacks(checkoutMethod, fetchOpts)); err != nil {
+	if reusedWorkspace {
+		if canSkipCheckout(gitCmd, checkoutMethod, cfg.Commit) {
+			g.logger.Println()
+			g.logger.Donef("%s is already checked out, skipping fetch and checkout", cfg.Commit)
+			return checkoutStrategy, isPRCheckout(checkoutMethod), newCheckoutReport(checkoutMethod, checkoutStrategy, time.Since(checkoutStartTime), nil), nil
+		}
+		fetchOpts.forceUpdate = true
+	}
+
+	if err := checkoutStrategy.do(gitCmd, fetchOpts, selectFallbacks(checkoutMethod, fetchOpts, newDeepenOptions(cfg))); err != nil {
 		g.logger.Infof("Checkout strategy used: %T", checkoutStrategy)
-		return nil, false, err
+		return nil, false, nil, wrapCommandTimeoutError(err, "Checkout has timed out")
 	}
 
-	checkoutDuration := time.Since(checkoutStartTime).Round(time.Second)
+	checkoutDuration := time.Since(checkoutStartTime)
 	g.logger.Println()
-	g.logger.Infof("Fetch and checkout took %s", checkoutDuration)
-	g.tracker.LogCheckout(checkoutDuration, checkoutMethod.String(), cfg.RepositoryURL)
+	g.logger.Infof("Fetch and checkout took %s", checkoutDuration.Round(time.Second))
+	g.tracker.LogCheckout(checkoutDuration.Round(time.Second), checkoutMethod.String(), cfg.RepositoryURL)
+	fetchStats := runner.FetchStats(cfg.CloneIntoDir)
+	g.reportFetchStats(fetchStats)
+
+	return checkoutStrategy, isPRCheckout(checkoutMethod), newCheckoutReport(checkoutMethod, checkoutStrategy, checkoutDuration, fetchStats), nil
+}
+
+func (g GitCloner) reportFetchStats(fetchStats []FetchStats) {
+	var total FetchStats
+	for i, stats := range fetchStats {
+		g.logger.Printf("Fetch #%d: %s", i+1, stats)
+		g.tracker.LogFetch(stats.Duration, stats.Objects, stats.Bytes, stats.Throughput)
+
+		total.Objects += stats.Objects
+		total.Bytes += stats.Bytes
+		total.Duration += stats.Duration
+	}
 
-	return checkoutStrategy, isPRCheckout(checkoutMethod), nil
+	if len(fetchStats) > 1 {
+		g.logger.Printf("Fetched %d objects, %s in %s in total", total.Objects, humaniseBytes(total.Bytes), total.Duration.Round(time.Millisecond))
+	}
 }
 
 func updateSubmodules(gitCmd git.Git, cfg Config) error {
@@ -212,6 +509,14 @@ func updateSubmodules(gitCmd git.Git, cfg Config) error {
 	}
 
 	if err := runner.Run(gitCmd.SubmoduleUpdate(opts...)); err != nil {
+		if isCommandTimeoutError(err) {
+			return newStepError(
+				commandTimedOutTag,
+				fmt.Errorf("submodule update: %w", err),
+				"Updating submodules has timed out",
+			)
+		}
+
 		return newStepError(
 			updateSubmoduleFailedTag,
 			fmt.Errorf("submodule update: %v", err),
@@ -222,7 +527,33 @@ func updateSubmodules(gitCmd git.Git, cfg Config) error {
 	return nil
 }
 
-func setupSparseCheckout(gitCmd git.Git, sparseDirectories []string) error {
+func setupLowSpeedLimit(gitCmd git.Git, lowSpeedLimit, lowSpeedTime int) error {
+	if lowSpeedLimit <= 0 || lowSpeedTime <= 0 {
+		return nil
+	}
+
+	if err := runner.Run(gitCmd.Config("http.lowSpeedLimit", strconv.Itoa(lowSpeedLimit))); err != nil {
+		return newStepError(
+			"low_speed_limit_config_failed",
+			fmt.Errorf("failed to set low speed limit: %v", err),
+			"Failed to configure stalled transfer detection",
+		)
+	}
+
+	if err := runner.Run(gitCmd.Config("http.lowSpeedTime", strconv.Itoa(lowSpeedTime))); err != nil {
+		return newStepError(
+			"low_speed_limit_config_failed",
+			fmt.Errorf("failed to set low speed time: %v", err),
+			"Failed to configure stalled transfer detection",
+		)
+	}
+
+	return nil
+}
+
+// setupSparseCheckout sets up the sparse-checkout and the partial clone of the origin remote.
+// In worktree mode the config is written into the config of the worktree, so it doesn't apply to the other worktrees of the shared repository.
+func setupSparseCheckout(gitCmd git.Git, sparseDirectories []string, perWorktree bool) error {
 	if len(sparseDirectories) == 0 {
 		return nil
 	}
@@ -246,8 +577,25 @@ func setupSparseCheckout(gitCmd git.Git, sparseDirectories []string) error {
 	}
 
 	// Enable partial clone support for the remote
-	sparseConfigCmd := gitCmd.Config("extensions.partialClone", originRemoteName, "--local")
-	if err := runner.Run(sparseConfigCmd); err != nil {
+	if !perWorktree {
+		return enablePartialClone(gitCmd.Config("extensions.partialClone", originRemoteName, "--local"))
+	}
+	// git would write the missing promisor settings of the remote into the shared config on the first filtered fetch
+	for _, setting := range [][2]string{
+		{"extensions.partialClone", originRemoteName},
+		{"remote." + originRemoteName + ".promisor", "true"},
+		{"remote." + originRemoteName + ".partialclonefilter", "tree:0"},
+	} {
+		if err := enablePartialClone(gitCommand(gitCmd, "config", "--worktree", setting[0], setting[1])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func enablePartialClone(configCmd *v1command.Model) error {
+	if err := runner.Run(configCmd); err != nil {
 		return newStepError(
 			sparseCheckoutFailedTag,
 			fmt.Errorf("enable partial clone support for the remote has failed: %v", err),
@@ -20,6 +20,7 @@ func Test_checkoutState(t *testing.T) {
 	tests := [...]struct {
 		name            string
 		cfg             Config
+		reusedWorkspace bool
 		patchSource     bitriseapi.PatchSource
 		mergeRefChecker bitriseapi.MergeRefChecker
 		mockRunner      *MockRunner
@@ -210,9 +211,9 @@ func Test_checkoutState(t *testing.T) {
 				GivenRunWithRetryFailsAfter(2).
 				GivenRunSucceeds(),
 			wantCmds: []string{
-				`git "fetch" "--jobs=10" "--depth=50" "--no-tags" "origin" "refs/heads/master"`,
-				`git "fetch" "--jobs=10" "--depth=50" "--no-tags" "origin" "refs/heads/master"`,
-				`git "fetch" "--jobs=10" "--depth=50" "--no-tags" "origin" "refs/heads/master"`,
+				`git "fetch" "--jobs=10" "--depth=1" "--no-tags" "origin" "refs/heads/master"`,
+				`git "fetch" "--jobs=10" "--depth=1" "--no-tags" "origin" "refs/heads/master"`,
+				`git "fetch" "--jobs=10" "--depth=1" "--no-tags" "origin" "refs/heads/master"`,
 				`git "remote" "-v"`,
 				`git "ls-remote" "-b"`,
 			},
@@ -260,6 +261,7 @@ func Test_checkoutState(t *testing.T) {
 				`git "checkout" "-B" "master" "origin/master"`,
 				`git "log" "-1" "--format=%H"`,
 				`git "fetch" "--jobs=10" "--depth=1" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/test/commit-messages"`,
+				`git "merge-base" "HEAD" "76a934ae"`,
 				`git "merge" "76a934ae"`,
 				`git "checkout" "--detach"`,
 			},
@@ -288,10 +290,45 @@ func Test_checkoutState(t *testing.T) {
 				`git "log" "-1" "--format=%H"`,
 				`git "remote" "add" "fork" "git@github.com:bitrise-io/other-repo.git"`,
 				`git "fetch" "--jobs=10" "--depth=1" "--no-tags" "--no-recurse-submodules" "fork" "refs/heads/test/commit-messages"`,
+				`git "merge-base" "HEAD" "fork/test/commit-messages"`,
 				`git "merge" "fork/test/commit-messages"`,
 				`git "checkout" "--detach"`,
 			},
 		},
+		{
+			name: "PR - no fork - manual merge: deepen both branches until the merge base is fetched",
+			cfg: Config{
+				RepositoryURL: "https://github.com/bitrise-io/git-clone-test.git",
+				Branch:        "test/commit-messages",
+				PRDestBranch:  "master",
+				Commit:        "76a934ae",
+				ShouldMergePR: true,
+			},
+			patchSource: FakePatchSource{"", errors.New(rawCmdError)},
+			mockRunner: new(MockRunner).
+				GivenRunForOutputFailsForCommand(`git "merge-base" "HEAD" "76a934ae"`, 2).
+				GivenRunForOutputReturnsForCommand(`git "rev-parse" "--is-shallow-repository"`, "true").
+				GivenRunForOutputSucceeds().
+				GivenRunWithRetrySucceeds().
+				GivenRunSucceeds(),
+			wantCmds: []string{
+				`git "fetch" "--jobs=10" "--depth=1" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/master"`,
+				`git "checkout" "-B" "master" "origin/master"`,
+				`git "log" "-1" "--format=%H"`,
+				`git "fetch" "--jobs=10" "--depth=1" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/test/commit-messages"`,
+				`git "merge-base" "HEAD" "76a934ae"`,
+				`git "rev-parse" "--is-shallow-repository"`,
+				`git "fetch" "--jobs=10" "--deepen=16" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/master"`,
+				`git "fetch" "--jobs=10" "--deepen=16" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/test/commit-messages"`,
+				`git "merge-base" "HEAD" "76a934ae"`,
+				`git "rev-parse" "--is-shallow-repository"`,
+				`git "fetch" "--jobs=10" "--deepen=32" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/master"`,
+				`git "fetch" "--jobs=10" "--deepen=32" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/test/commit-messages"`,
+				`git "merge-base" "HEAD" "76a934ae"`,
+				`git "merge" "76a934ae"`,
+				`git "checkout" "--detach"`,
+			},
+		},
 
 		// PRs no merge
 		{
@@ -340,6 +377,7 @@ func Test_checkoutState(t *testing.T) {
 			patchSource: FakePatchSource{"diff_path", nil},
 			wantErr:     nil,
 			wantCmds: []string{
+				`git "ls-remote" "--exit-code" "https://github.com/bitrise-io/git-clone-test2.git" "refs/heads/test/commit-messages"`,
 				`git "remote" "add" "fork" "https://github.com/bitrise-io/git-clone-test2.git"`,
 				`git "fetch" "--jobs=10" "--depth=1" "--no-tags" "fork" "refs/heads/test/commit-messages"`,
 				`git "checkout" "76a934ae"`,
@@ -358,14 +396,39 @@ func Test_checkoutState(t *testing.T) {
 				UpdateSubmodules:      true,
 			},
 			patchSource: FakePatchSource{"diff_path", nil},
-			wantErr:     nil,
+			mockRunner: givenMockRunner().
+				GivenRunFailsForCommand(`git "ls-remote" "--exit-code" "git@github.com:bitrise-io/other-repo.git" "refs/heads/test/commit-messages"`, 1).
+				GivenRunWithRetrySucceeds().
+				GivenRunSucceeds(),
+			wantErr: nil,
 			wantCmds: []string{
+				`git "ls-remote" "--exit-code" "git@github.com:bitrise-io/other-repo.git" "refs/heads/test/commit-messages"`,
 				`git "fetch" "--jobs=10" "--depth=1" "--no-tags" "origin" "refs/heads/master"`,
 				`git "checkout" "master"`,
 				`git "apply" "--index" "diff_path"`,
 				`git "checkout" "--detach"`,
 			},
 		},
+		{
+			name: "PR - no merge - fork - accessible private fork",
+			cfg: Config{
+				RepositoryURL:         "https://github.com/bitrise-io/git-clone-test.git",
+				PRSourceRepositoryURL: "git@github.com:bitrise-io/other-repo.git",
+				Branch:                "test/commit-messages",
+				PRDestBranch:          "master",
+				Commit:                "76a934ae",
+				CloneDepth:            1,
+				ShouldMergePR:         false,
+			},
+			patchSource: FakePatchSource{"diff_path", nil},
+			wantErr:     nil,
+			wantCmds: []string{
+				`git "ls-remote" "--exit-code" "git@github.com:bitrise-io/other-repo.git" "refs/heads/test/commit-messages"`,
+				`git "remote" "add" "fork" "git@github.com:bitrise-io/other-repo.git"`,
+				`git "fetch" "--jobs=10" "--depth=1" "--no-tags" "--no-recurse-submodules" "fork" "refs/heads/test/commit-messages"`,
+				`git "checkout" "76a934ae"`,
+			},
+		},
 
 		// ** Errors **
 		{
@@ -462,6 +525,125 @@ func Test_checkoutState(t *testing.T) {
 			},
 		},
 
+		// ** Date and ref limited history **
+		{
+			name: "Checkout branch, shallow since",
+			cfg: Config{
+				Branch:       "hcnarb",
+				ShallowSince: "2024-01-31",
+			},
+			wantCmds: []string{
+				`git "fetch" "--jobs=10" "--shallow-since=2024-01-31" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/hcnarb"`,
+				`git "checkout" "-B" "hcnarb" "origin/hcnarb"`,
+			},
+		},
+		{
+			name: "PR - no fork - manual merge, shallow exclude",
+			cfg: Config{
+				RepositoryURL:  "https://github.com/bitrise-io/git-clone-test.git",
+				Branch:         "test/commit-messages",
+				PRDestBranch:   "master",
+				Commit:         "76a934ae",
+				ShouldMergePR:  true,
+				ShallowExclude: []string{"v1.0.0", "v1.1.0"},
+			},
+			patchSource: FakePatchSource{"", errors.New(rawCmdError)},
+			wantCmds: []string{
+				`git "fetch" "--jobs=10" "--shallow-exclude=v1.0.0" "--shallow-exclude=v1.1.0" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/master"`,
+				`git "checkout" "-B" "master" "origin/master"`,
+				`git "log" "-1" "--format=%H"`,
+				`git "fetch" "--jobs=10" "--shallow-exclude=v1.0.0" "--shallow-exclude=v1.1.0" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/test/commit-messages"`,
+				`git "merge-base" "HEAD" "76a934ae"`,
+				`git "merge" "76a934ae"`,
+				`git "checkout" "--detach"`,
+			},
+		},
+		{
+			name: "Checkout commit, shallow since excludes the branch, fetch latest commit",
+			cfg: Config{
+				Commit:       "76a934ae",
+				Branch:       "hcnarb",
+				ShallowSince: "30 days ago",
+			},
+			mockRunner: givenMockRunner().
+				GivenRunWithRetryFailsForCommandWithError(
+					`git "fetch" "--jobs=10" "--shallow-since=30 days ago" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/hcnarb"`,
+					errors.New("fatal: no commits selected for shallow requests"),
+				).
+				GivenRunWithRetrySucceeds().
+				GivenRunSucceeds(),
+			wantCmds: []string{
+				`git "fetch" "--jobs=10" "--shallow-since=30 days ago" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/hcnarb"`,
+				`git "fetch" "--jobs=10" "--depth=1" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/hcnarb"`,
+				`git "checkout" "76a934ae"`,
+			},
+		},
+		{
+			name: "Checkout commit, shallow since, older history needed",
+			cfg: Config{
+				Commit:       "76a934ae",
+				ShallowSince: "2024-01-31",
+			},
+			mockRunner: givenMockRunner().
+				GivenRunFailsForCommand(`git "checkout" "76a934ae"`, 1).
+				GivenRunWithRetrySucceeds().
+				GivenRunSucceeds(),
+			wantCmds: []string{
+				`git "fetch" "--jobs=10" "--shallow-since=2024-01-31" "--no-tags" "--no-recurse-submodules" "origin" "76a934ae"`,
+				`git "checkout" "76a934ae"`,
+				`git "fetch" "--jobs=10" "--shallow-since=2024-01-31" "--no-tags" "--no-recurse-submodules"`,
+				`git "checkout" "76a934ae"`,
+			},
+		},
+
+		// ** Reused clone directory **
+		{
+			name: "Reused workspace - commit already checked out",
+			cfg: Config{
+				Commit: "76a934ae",
+				Branch: "hcnarb",
+			},
+			reusedWorkspace: true,
+			mockRunner: new(MockRunner).
+				GivenRunForOutputReturnsForCommand(`git "rev-parse" "HEAD"`, "76a934ae5b3c3a7c3c8d2c7e0f5a1b2c3d4e5f60").
+				GivenRunForOutputReturnsForCommand(`git "rev-parse" "--verify" "--quiet" "76a934ae^{commit}"`, "76a934ae5b3c3a7c3c8d2c7e0f5a1b2c3d4e5f60").
+				GivenRunWithRetrySucceeds().
+				GivenRunSucceeds(),
+			wantCmds: []string{
+				`git "rev-parse" "HEAD"`,
+				`git "rev-parse" "--verify" "--quiet" "76a934ae^{commit}"`,
+			},
+		},
+		{
+			name: "Reused workspace - checkout commit, force update refs",
+			cfg: Config{
+				Commit:    "76a934ae",
+				Branch:    "hcnarb",
+				FetchTags: true,
+			},
+			reusedWorkspace: true,
+			mockRunner: new(MockRunner).
+				GivenRunForOutputReturnsForCommand(`git "rev-parse" "HEAD"`, "cfba2b01332e31cb1568dbf3f22edce063118bae").
+				GivenRunWithRetrySucceeds().
+				GivenRunSucceeds(),
+			wantCmds: []string{
+				`git "rev-parse" "HEAD"`,
+				`git "fetch" "--jobs=10" "--depth=1" "--tags" "--force" "--no-recurse-submodules" "origin" "refs/heads/hcnarb"`,
+				`git "checkout" "76a934ae"`,
+			},
+		},
+		{
+			name: "Reused workspace - checkout tag, force update refs",
+			cfg: Config{
+				Tag: "gat",
+			},
+			reusedWorkspace: true,
+			wantCmds: []string{
+				`git "fetch" "--jobs=10" "--depth=1" "--no-tags" "--force" "--no-recurse-submodules" "origin" "refs/tags/gat:refs/tags/gat"`,
+				`git "checkout" "gat"`,
+			},
+		},
+
 		// ** Sparse-checkout **
 		{
 			name: "Checkout commit - sparse",
@@ -529,7 +711,7 @@ func Test_checkoutState(t *testing.T) {
 			logger := log.NewLogger()
 			tracker := tracker.NewStepTracker(envRepo, logger)
 			cloner := NewGitCloner(log.NewLogger(), tracker, command.NewFactory(envRepo), tt.patchSource, tt.mergeRefChecker, false)
-			_, _, actualErr := cloner.checkoutState(git.Git{}, tt.cfg)
+			_, _, _, actualErr := cloner.checkoutState(git.Git{}, tt.cfg, tt.reusedWorkspace)
 
 			// Then
 			if tt.wantErrType != nil {
@@ -608,6 +790,7 @@ func Test_SetupSparseCheckout(t *testing.T) {
 	tests := [...]struct {
 		name              string
 		sparseDirectories []string
+		perWorktree       bool
 		wantCmds          []string
 	}{
 		{
@@ -628,6 +811,18 @@ func Test_SetupSparseCheckout(t *testing.T) {
 				`git "config" "extensions.partialClone" "origin" "--local"`,
 			},
 		},
+		{
+			name:              "Sparse-checkout in worktree",
+			sparseDirectories: []string{"client/android"},
+			perWorktree:       true,
+			wantCmds: []string{
+				`git "sparse-checkout" "init" "--cone"`,
+				`git "sparse-checkout" "set" "client/android"`,
+				`git "config" "--worktree" "extensions.partialClone" "origin"`,
+				`git "config" "--worktree" "remote.origin.promisor" "true"`,
+				`git "config" "--worktree" "remote.origin.partialclonefilter" "tree:0"`,
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -637,7 +832,7 @@ func Test_SetupSparseCheckout(t *testing.T) {
 			runner = mockRunner
 
 			// When
-			actualErr := setupSparseCheckout(git.Git{}, tt.sparseDirectories)
+			actualErr := setupSparseCheckout(git.Git{}, tt.sparseDirectories, tt.perWorktree)
 
 			// Then
 			assert.NoError(t, actualErr)
@@ -0,0 +1,143 @@
+package gitclone
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const lockFileSuffix = ".lock"
+
+// removeLockFiles deletes the lock files and the temporary pack files of an interrupted git process from the git directory
+// (including the git directories of submodules) and returns the paths of the removed files.
+// It must only be called when no other git process is running in the repository.
+// The skipped directories (for example the git directories of the other worktrees) are not walked.
+func removeLockFiles(gitDir string, skipDirs ...string) ([]string, error) {
+	if _, err := os.Stat(gitDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var removed []string
+	err := filepath.WalkDir(gitDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if slices.Contains(skipDirs, path) {
+				return filepath.SkipDir
+			}
+			// Loose objects are never locked, walking them would only slow down the cleanup of big repositories
+			if d.Name() == "objects" {
+				return removeTemporaryPackFiles(filepath.Join(path, "pack"), &removed)
+			}
+			return nil
+		}
+
+		if !strings.HasSuffix(d.Name(), lockFileSuffix) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed = append(removed, path)
+
+		return nil
+	})
+
+	return removed, err
+}
+
+// removeLockFilesOf deletes the lock files of an interrupted git process which worked in the directory.
+// In a linked worktree only the git directory of the worktree is cleaned up, and the shared git directory
+// if the process changed the shared refs or config: the other worktrees might be in use by other processes.
+func removeLockFilesOf(dir string, changedSharedRepository bool) ([]string, error) {
+	gitDir, commonDir, err := resolveGitDirs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if gitDir == commonDir {
+		return removeLockFiles(gitDir)
+	}
+
+	removed, err := removeLockFiles(gitDir)
+	if err != nil || !changedSharedRepository {
+		return removed, err
+	}
+	removedShared, err := removeLockFiles(commonDir, filepath.Join(commonDir, "worktrees"))
+	return append(removed, removedShared...), err
+}
+
+// resolveGitDirs returns the git directory of the repository in dir, and the git directory shared by its worktrees.
+// In a linked worktree .git is a file pointing to the git directory of the worktree (<common dir>/worktrees/<id>),
+// which contains the path of the common directory. The paths are read without running git, as the repository might be locked.
+func resolveGitDirs(dir string) (string, string, error) {
+	gitDir := filepath.Join(dir, ".git")
+	info, err := os.Stat(gitDir)
+	if err != nil || info.IsDir() {
+		return gitDir, gitDir, nil
+	}
+
+	content, err := os.ReadFile(gitDir)
+	if err != nil {
+		return "", "", err
+	}
+	path, found := strings.CutPrefix(strings.TrimSpace(string(content)), "gitdir:")
+	if !found {
+		return "", "", fmt.Errorf("invalid .git file in %s", dir)
+	}
+	gitDir = resolvePath(dir, strings.TrimSpace(path))
+
+	commonDir, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// For example the git directory of a submodule
+			return gitDir, gitDir, nil
+		}
+		return "", "", err
+	}
+	return gitDir, resolvePath(gitDir, strings.TrimSpace(string(commonDir))), nil
+}
+
+func resolvePath(base, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(base, path)
+}
+
+func removeTemporaryPackFiles(packDir string, removed *[]string) error {
+	entries, err := os.ReadDir(packDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return filepath.SkipDir
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isTemporaryPackFile(entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(packDir, entry.Name())
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		*removed = append(*removed, path)
+	}
+
+	return filepath.SkipDir
+}
+
+// isTemporaryPackFile reports whether the file is an incomplete pack written by fetch (tmp_pack_XXXXXX, tmp_idx_XXXXXX)
+// or by index-pack (.tmp-1234-pack-...)
+func isTemporaryPackFile(name string) bool {
+	return strings.HasPrefix(name, "tmp_") || strings.HasPrefix(name, ".tmp-")
+}
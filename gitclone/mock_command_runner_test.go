@@ -2,6 +2,7 @@ package gitclone
 
 import (
 	"errors"
+	"time"
 
 	"github.com/bitrise-io/go-utils/command"
 	"github.com/stretchr/testify/mock"
@@ -33,12 +34,38 @@ func (m *MockRunner) GivenRunForOutputSucceeds() *MockRunner {
 	return m
 }
 
+// GivenRunForOutputFailsForCommand ...
+func (m *MockRunner) GivenRunForOutputFailsForCommand(cmdString string, times int) *MockRunner {
+	m.On("RunForOutput", mock.MatchedBy(func(command *command.Model) bool {
+		return m.isCommandMatching(command, cmdString)
+	})).
+		Run(m.rememberCommand).
+		Times(times).
+		Return("", errDummy)
+	return m
+}
+
+// GivenRunForOutputReturnsForCommand ...
+func (m *MockRunner) GivenRunForOutputReturnsForCommand(cmdString, output string) *MockRunner {
+	m.On("RunForOutput", mock.MatchedBy(func(command *command.Model) bool {
+		return m.isCommandMatching(command, cmdString)
+	})).
+		Run(m.rememberCommand).
+		Return(output, nil)
+	return m
+}
+
 // Run ...
 func (m *MockRunner) Run(c *command.Model) error {
 	args := m.Called(c)
 	return args.Error(0)
 }
 
+// RunWithTimeout ...
+func (m *MockRunner) RunWithTimeout(c *command.Model, _ time.Duration) error {
+	return m.Run(c)
+}
+
 // GivenRunSucceeds ...
 func (m *MockRunner) GivenRunSucceeds() *MockRunner {
 	m.On("Run", mock.Anything).
@@ -91,25 +118,53 @@ func (m *MockRunner) GivenRunWithRetryFailsAfter(times int) *MockRunner {
 
 // GivenRunWithRetryFailsForCommand ...
 func (m *MockRunner) GivenRunWithRetryFailsForCommand(cmdString string) *MockRunner {
+	return m.GivenRunWithRetryFailsForCommandWithError(cmdString, errDummy)
+}
+
+// GivenRunWithRetryFailsForCommandWithError ...
+func (m *MockRunner) GivenRunWithRetryFailsForCommandWithError(cmdString string, err error) *MockRunner {
 	m.On("RunWithRetry", mock.MatchedBy(func(getCommand func() *command.Model) bool {
 		return m.isCommandMatching(getCommand(), cmdString)
 	})).
 		Run(func(args mock.Arguments) {
 			m.rememberCommands(args, 0)
 		}).
-		Return(errDummy)
+		Return(err)
 	return m
 }
 
+func (m *MockRunner) SetTimeouts(timeouts CommandTimeouts) {
+}
+
+func (m *MockRunner) FetchStats(dir string) []FetchStats {
+	return nil
+}
+
 func (m *MockRunner) SetPerformanceMonitoring(enable bool) {
 }
 
+func (m *MockRunner) TraceEventsDir(dir string) string {
+	return ""
+}
+
 func (m *MockRunner) PausePerformanceMonitoring() {
 }
 
 func (m *MockRunner) ResumePerformanceMonitoring() {
 }
 
+func (m *MockRunner) StopRepositoryPerformanceMonitoring(dir string) {
+}
+
+func (m *MockRunner) StopPerformanceMonitoring() {
+}
+
+func (m *MockRunner) SetSharedRepositoryLock(dir string, lock func() (release func(), err error)) {
+}
+
+func (m *MockRunner) SetOutputPrefix(dir, prefix string) {
+}
+
 func (m *MockRunner) rememberCommand(args mock.Arguments) {
 	var cmdModel *command.Model
 	switch res := args[0].(type) {
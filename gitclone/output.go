@@ -1,11 +1,18 @@
 package gitclone
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/bitrise-io/envman/envman"
 	"github.com/bitrise-io/go-steputils/v2/export"
 	v1command "github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/command/git"
 	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/log"
 )
@@ -13,16 +20,69 @@ import (
 const outputCommitterName = "GIT_CLONE_COMMIT_COMMITTER_NAME"
 const outputCommitterEmail = "GIT_CLONE_COMMIT_COMMITTER_EMAIL"
 const outputCommitCount = "GIT_CLONE_COMMIT_COUNT"
+const outputPhaseTimings = "GIT_CLONE_PHASE_TIMINGS"
+const outputCheckoutReport = "GIT_CLONE_CHECKOUT_REPORT"
+const outputCommitRangeBase = "GIT_CLONE_COMMIT_RANGE_BASE"
+const outputCommitRange = "GIT_CLONE_COMMIT_RANGE"
+const outputCommitRangeJSON = "GIT_CLONE_COMMIT_RANGE_JSON"
+const outputChangedFiles = "GIT_CLONE_CHANGED_FILES"
+const outputChangedFilesPath = "GIT_CLONE_CHANGED_FILES_PATH"
+const outputChangedFilesMatch = "GIT_CLONE_CHANGED_FILES_MATCH"
+const outputHeadTags = "GIT_CLONE_HEAD_TAGS"
+const outputNearestTag = "GIT_CLONE_NEAREST_TAG"
+const outputNearestTagDistance = "GIT_CLONE_NEAREST_TAG_DISTANCE"
+const outputDescribe = "GIT_CLONE_DESCRIBE"
+const outputVersion = "GIT_CLONE_VERSION"
+const outputVersionMajor = "GIT_CLONE_VERSION_MAJOR"
+const outputVersionMinor = "GIT_CLONE_VERSION_MINOR"
+const outputVersionPatch = "GIT_CLONE_VERSION_PATCH"
+const outputBuildNumber = "GIT_CLONE_BUILD_NUMBER"
+const outputVersionApproximate = "GIT_CLONE_VERSION_APPROXIMATE"
+const outputPRSyntheticMerge = "GIT_CLONE_PR_SYNTHETIC_MERGE"
+const outputPRMergeCommitHash = "GIT_CLONE_PR_MERGE_COMMIT_HASH"
+const outputPRDestCommitHash = "GIT_CLONE_PR_DEST_COMMIT_HASH"
+const outputPRMergeBaseHash = "GIT_CLONE_PR_MERGE_BASE_HASH"
+const outputSignatureStatus = "GIT_CLONE_SIGNATURE_STATUS"
+const outputSignatureSigner = "GIT_CLONE_SIGNATURE_SIGNER"
+const outputSignatureFingerprint = "GIT_CLONE_SIGNATURE_FINGERPRINT"
+const outputTrailerPrefix = "GIT_CLONE_TRAILER_"
+const outputTrailersJSON = "GIT_CLONE_TRAILERS_JSON"
+const outputIssueKeys = "GIT_CLONE_ISSUE_KEYS"
+const outputOutputsJSONPath = "GIT_CLONE_OUTPUTS_JSON_PATH"
+const outputOutputsDotenvPath = "GIT_CLONE_OUTPUTS_DOTENV_PATH"
 
+const fullValuePathSuffix = "_PATH"
+
+const outputsJSONFileName = "git_clone_outputs.json"
+const outputsDotenvFileName = "git_clone_outputs.env"
+
+// gitOutput is a commit detail output, format is a git pretty-format evaluated against the ref
 type gitOutput struct {
 	envKey string
-	gitCmd *v1command.Model
+	format string
+	ref    string
 }
 
+// The fields of the outputs are separated by NUL characters, which can't occur in commit metadata
+const gitOutputFieldSeparator = "%x00"
+
 type OutputExporter struct {
 	logger         log.Logger
 	checkoutResult CheckoutStateResult
 	exporter       export.Exporter
+
+	// outputs are the untrimmed values of the exported outputs, in the order of export
+	outputs []exportedOutput
+	// outputsDir is the directory of the output files, created on first use
+	outputsDir string
+	// maxEnvLength is the env var size limit in bytes, read on first use
+	maxEnvLength int
+	// fullValuePathKeys are the generated <key>_PATH outputs of the trimmed outputs, mapped to the trimmed output key
+	fullValuePathKeys map[string]string
+}
+
+type exportedOutput struct {
+	key, value string
 }
 
 func NewOutputExporter(logger log.Logger, cmdFactory command.Factory, checkoutResult CheckoutStateResult) OutputExporter {
@@ -44,13 +104,210 @@ Try using the env vars based on the webhook contents instead, such as $BITRISE_G
 		return nil
 	}
 
-	maxEnvLength, err := getMaxEnvLength()
+	outputs := e.gitOutputs(gitRef, e.checkoutResult.isPR)
+	values, err := e.readGitOutputs(e.checkoutResult.gitCmd, outputs)
+	if err != nil {
+		return e.wrapErrorForExportCommitInfo(err)
+	}
+	for i, output := range outputs {
+		if err := e.exportOutput(output.envKey, values[i]); err != nil {
+			return e.wrapErrorForExportCommitInfo(err)
+		}
+	}
+
+	if e.checkoutResult.isPR {
+		e.logger.Printf("The following outputs are not exported for Pull Requests:")
+		e.logger.Printf("- %s", outputCommitCount)
+		return nil
+	}
+
+	count, err := e.runGitOutputCommand(e.checkoutResult.gitCmd.RevList("HEAD", "--count"))
+	if err != nil {
+		return e.wrapErrorForExportCommitInfo(err)
+	}
+	if err := e.exportOutput(outputCommitCount, count); err != nil {
+		return e.wrapErrorForExportCommitInfo(err)
+	}
+
+	return nil
+}
+
+// ExportPhaseTimings exports the performance monitoring summary (if available) as JSON
+func (e *OutputExporter) ExportPhaseTimings() error {
+	if len(e.checkoutResult.phaseTimings) == 0 {
+		return nil
+	}
+
+	phaseTimings, err := json.Marshal(e.checkoutResult.phaseTimings)
+	if err != nil {
+		return e.wrapErrorForExportCommitInfo(fmt.Errorf("failed to serialize phase timings: %w", err))
+	}
+
+	if err := e.exportOutput(outputPhaseTimings, string(phaseTimings)); err != nil {
+		return e.wrapErrorForExportCommitInfo(err)
+	}
+
+	return nil
+}
+
+// ExportCheckoutReport exports the fetch statistics and the duration of the checkout as JSON
+func (e *OutputExporter) ExportCheckoutReport() error {
+	if e.checkoutResult.checkoutReport == nil {
+		return nil
+	}
+
+	report, err := json.Marshal(e.checkoutResult.checkoutReport)
+	if err != nil {
+		return e.wrapErrorForExportCommitInfo(fmt.Errorf("failed to serialize checkout report: %w", err))
+	}
+
+	if err := e.exportOutput(outputCheckoutReport, string(report)); err != nil {
+		return e.wrapErrorForExportCommitInfo(err)
+	}
+
+	return nil
+}
+
+// ExportCommitRange exports the commits introduced by the build (if the commit range was collected)
+func (e *OutputExporter) ExportCommitRange() error {
+	commitRange := e.checkoutResult.commitRange
+	if commitRange == nil {
+		return nil
+	}
+
+	var hashes []string
+	for _, commit := range commitRange.commits {
+		hashes = append(hashes, commit.Hash)
+	}
+
+	commitsJSON, err := json.Marshal(commitRange.commits)
+	if err != nil {
+		return e.wrapErrorForExportCommitInfo(fmt.Errorf("failed to serialize commit range: %w", err))
+	}
+
+	for _, output := range []struct{ key, value string }{
+		{key: outputCommitRangeBase, value: commitRange.base},
+		{key: outputCommitRange, value: strings.Join(hashes, "\n")},
+		{key: outputCommitRangeJSON, value: string(commitsJSON)},
+	} {
+		if err := e.exportOutput(output.key, output.value); err != nil {
+			return e.wrapErrorForExportCommitInfo(err)
+		}
+	}
+
+	return nil
+}
+
+// ExportChangedFiles exports the files changed since the base (if the changed files were collected)
+func (e *OutputExporter) ExportChangedFiles() error {
+	changedFiles := e.checkoutResult.changedFiles
+	if changedFiles == nil {
+		return nil
+	}
+
+	filesJSON, err := json.MarshalIndent(changedFiles.files, "", "  ")
+	if err != nil {
+		return e.wrapErrorForExportCommitInfo(fmt.Errorf("failed to serialize changed files: %w", err))
+	}
+
+	outputDir, err := e.outputDir()
 	if err != nil {
 		return e.wrapErrorForExportCommitInfo(err)
 	}
+	filesPath := filepath.Join(outputDir, "changed_files.json")
+
+	e.logger.Printf("=> %s\n   value: %s", outputChangedFilesPath, filesPath)
+	if err := e.exporter.ExportStringToFileOutput(outputChangedFilesPath, string(filesJSON), filesPath); err != nil {
+		return e.wrapErrorForExportCommitInfo(fmt.Errorf("envman export failed: %v", err))
+	}
+	e.recordOutput(outputChangedFilesPath, filesPath)
+
+	for _, output := range []struct{ key, value string }{
+		{key: outputChangedFiles, value: compactChangedFiles(changedFiles.files)},
+		{key: outputChangedFilesMatch, value: fmt.Sprintf("%t", changedFiles.matchesPatterns)},
+	} {
+		if err := e.exportOutput(output.key, output.value); err != nil {
+			return e.wrapErrorForExportCommitInfo(err)
+		}
+	}
+
+	return nil
+}
+
+// ExportVersionInfo exports the tag based version details (if they were collected)
+func (e *OutputExporter) ExportVersionInfo() error {
+	info := e.checkoutResult.versionInfo
+	if info == nil {
+		return nil
+	}
+
+	var version, major, minor, patch string
+	if info.version != nil {
+		version = info.version.String()
+		major = strconv.Itoa(info.version.major)
+		minor = strconv.Itoa(info.version.minor)
+		patch = strconv.Itoa(info.version.patch)
+	}
+
+	var distance string
+	if info.nearestTag != "" {
+		distance = strconv.Itoa(info.distance)
+	}
+
+	for _, output := range []struct{ key, value string }{
+		{key: outputHeadTags, value: strings.Join(info.headTags, "\n")},
+		{key: outputNearestTag, value: info.nearestTag},
+		{key: outputNearestTagDistance, value: distance},
+		{key: outputDescribe, value: info.describe},
+		{key: outputVersion, value: version},
+		{key: outputVersionMajor, value: major},
+		{key: outputVersionMinor, value: minor},
+		{key: outputVersionPatch, value: patch},
+		{key: outputBuildNumber, value: strconv.Itoa(info.buildNumber)},
+		{key: outputVersionApproximate, value: strconv.FormatBool(info.approximate)},
+	} {
+		if err := e.exportOutput(output.key, output.value); err != nil {
+			return e.wrapErrorForExportCommitInfo(err)
+		}
+	}
+
+	return nil
+}
+
+// ExportPRMergeInfo exports the details of the checked out Pull Request merge state (for Pull Request builds)
+func (e *OutputExporter) ExportPRMergeInfo() error {
+	info := e.checkoutResult.prMergeInfo
+	if info == nil {
+		return nil
+	}
+
+	for _, output := range []struct{ key, value string }{
+		{key: outputPRSyntheticMerge, value: strconv.FormatBool(info.syntheticMerge)},
+		{key: outputPRMergeCommitHash, value: info.mergeCommit},
+		{key: outputPRDestCommitHash, value: info.destCommit},
+		{key: outputPRMergeBaseHash, value: info.mergeBase},
+	} {
+		if err := e.exportOutput(output.key, output.value); err != nil {
+			return e.wrapErrorForExportCommitInfo(err)
+		}
+	}
+
+	return nil
+}
+
+// ExportSignature exports the result of the signature verification (if enabled)
+func (e *OutputExporter) ExportSignature() error {
+	signature := e.checkoutResult.signature
+	if signature == nil {
+		return nil
+	}
 
-	for _, commitInfo := range e.gitOutputs(gitRef, e.checkoutResult.isPR) {
-		if err := e.printLogAndExportEnv(commitInfo.gitCmd, commitInfo.envKey, maxEnvLength); err != nil {
+	for _, output := range []struct{ key, value string }{
+		{key: outputSignatureStatus, value: string(signature.status)},
+		{key: outputSignatureSigner, value: signature.signer},
+		{key: outputSignatureFingerprint, value: signature.fingerprint},
+	} {
+		if err := e.exportOutput(output.key, output.value); err != nil {
 			return e.wrapErrorForExportCommitInfo(err)
 		}
 	}
@@ -58,79 +315,348 @@ Try using the env vars based on the webhook contents instead, such as $BITRISE_G
 	return nil
 }
 
+// ExportCommitMessageDetails exports the requested trailers and the issue keys of the commit messages (if enabled)
+func (e *OutputExporter) ExportCommitMessageDetails() error {
+	scan := e.checkoutResult.commitMessageScan
+	if !scan.enabled() {
+		return nil
+	}
+
+	fmt.Println()
+	e.logger.Infof("Exporting commit message details")
+
+	var refs []string
+	if e.checkoutResult.gitRef != "" {
+		refs = append(refs, e.checkoutResult.gitRef)
+	}
+	if scan.scanCommitRange {
+		if scan.commitRange == nil {
+			e.logger.Warnf("The commit range is not available, only the build trigger commit is scanned.")
+		} else {
+			for _, commit := range scan.commitRange.commits {
+				refs = append(refs, commit.Hash)
+			}
+		}
+	}
+
+	var messages []commitMessage
+	if len(refs) > 0 {
+		args := append([]string{"log", "--no-walk=unsorted", "--format=" + commitMessageLogFormat}, refs...)
+		out, err := runner.RunForOutput(gitCommand(e.checkoutResult.gitCmd, args...))
+		if err != nil {
+			return e.wrapErrorForExportCommitInfo(fmt.Errorf("failed to read commit messages: %w", err))
+		}
+		messages = parseCommitMessageLog(out)
+	}
+
+	if len(scan.trailerKeys) > 0 {
+		trailers := collectTrailers(messages, scan.trailerKeys)
+		trailersJSON, err := json.Marshal(trailers)
+		if err != nil {
+			return e.wrapErrorForExportCommitInfo(fmt.Errorf("failed to serialize trailers: %w", err))
+		}
+
+		for _, key := range scan.trailerKeys {
+			if err := e.exportOutput(trailerOutputKey(key), strings.Join(trailers[key], "\n")); err != nil {
+				return e.wrapErrorForExportCommitInfo(err)
+			}
+		}
+		if err := e.exportOutput(outputTrailersJSON, string(trailersJSON)); err != nil {
+			return e.wrapErrorForExportCommitInfo(err)
+		}
+	}
+
+	if scan.issueKeyPattern != nil {
+		issueKeys := collectIssueKeys(scan.issueKeyPattern, scan.branch, messages)
+		if err := e.exportOutput(outputIssueKeys, strings.Join(issueKeys, "\n")); err != nil {
+			return e.wrapErrorForExportCommitInfo(err)
+		}
+	}
+
+	return nil
+}
+
+// ExportAdditionalRepositories exports the directory and the commit details of the additional repositories (if any),
+// namespaced by the repository name, for example GIT_CLONE_REPOSITORY_SDK_COMMIT_HASH
+func (e *OutputExporter) ExportAdditionalRepositories() error {
+	for _, repo := range e.checkoutResult.additionalRepositories {
+		fmt.Println()
+		e.logger.Infof("Exporting the details of %s", repo.name)
+
+		dir, err := filepath.Abs(repo.dir)
+		if err != nil {
+			return e.wrapErrorForExportCommitInfo(err)
+		}
+		if err := e.exportOutput(additionalRepositoryOutputKey(repo.name, outputAdditionalRepositoryDir), dir); err != nil {
+			return e.wrapErrorForExportCommitInfo(err)
+		}
+
+		outputs := commitInfoOutputs(repo.gitRef)
+		values, err := e.readGitOutputs(repo.gitCmd, outputs)
+		if err != nil {
+			return e.wrapErrorForExportCommitInfo(err)
+		}
+		for i, output := range outputs {
+			if err := e.exportOutput(additionalRepositoryOutputKey(repo.name, output.envKey), values[i]); err != nil {
+				return e.wrapErrorForExportCommitInfo(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ExportOutputFiles writes all exported outputs (untrimmed) to a JSON and a dotenv file and exports the file paths.
+// It should be called after all the other outputs are exported.
+func (e *OutputExporter) ExportOutputFiles() error {
+	fmt.Println()
+	e.logger.Infof("Writing output files")
+
+	outputDir, err := e.outputDir()
+	if err != nil {
+		return e.wrapErrorForExportCommitInfo(err)
+	}
+
+	jsonPath, dotenvPath, err := writeOutputFiles(outputDir, e.outputs)
+	if err != nil {
+		return e.wrapErrorForExportCommitInfo(err)
+	}
+
+	for _, output := range []struct{ key, value string }{
+		{key: outputOutputsJSONPath, value: jsonPath},
+		{key: outputOutputsDotenvPath, value: dotenvPath},
+	} {
+		e.logger.Printf("=> %s\n   value: %s", output.key, output.value)
+		if err := e.exporter.ExportOutput(output.key, output.value); err != nil {
+			return e.wrapErrorForExportCommitInfo(fmt.Errorf("envman export failed: %v", err))
+		}
+	}
+
+	return nil
+}
+
+// exportOutput exports the output with envman and records it for the output files.
+// Values bigger than the env size limit are trimmed, the full value is written to a file exported as <key>_PATH.
+func (e *OutputExporter) exportOutput(key, value string) error {
+	if trimmedKey, ok := e.fullValuePathKeys[key]; ok {
+		e.logger.Warnf("%s overwrites the full value path of %s, the full value is only available in the output files", key, trimmedKey)
+	}
+	// The output files are not affected by the env size limit
+	e.recordOutput(key, value)
+
+	maxEnvLength, err := e.envLengthLimit()
+	if err != nil {
+		return err
+	}
+
+	exportedValue, trimmed := trimValue(value, maxEnvLength)
+	if trimmed {
+		e.logger.Printf("Value %s  is bigger than maximum env variable size, trimming", key)
+		if err := e.exportFullValuePath(key, value); err != nil {
+			return err
+		}
+	}
+
+	e.logger.Printf("=> %s\n   value: %s", key, exportedValue)
+	if err := e.exporter.ExportOutput(key, exportedValue); err != nil {
+		return fmt.Errorf("envman export failed: %v", err)
+	}
+	return nil
+}
+
+// exportFullValuePath writes the untrimmed value of the output to a file and exports its path as <key>_PATH
+func (e *OutputExporter) exportFullValuePath(key, value string) error {
+	if key == outputChangedFiles {
+		// GIT_CLONE_CHANGED_FILES_PATH already points to the complete list of changed files (as JSON)
+		return nil
+	}
+
+	pathKey := key + fullValuePathSuffix
+	if e.isExported(pathKey) {
+		e.logger.Warnf("Not exporting the full value path of %s, %s is the name of another output. The full value is only available in the output files.", key, pathKey)
+		return nil
+	}
+
+	outputDir, err := e.outputDir()
+	if err != nil {
+		return err
+	}
+
+	fullValuePath := filepath.Join(outputDir, key+".txt")
+	e.logger.Printf("=> %s\n   value: %s", pathKey, fullValuePath)
+	if err := e.exporter.ExportStringToFileOutput(pathKey, value, fullValuePath); err != nil {
+		return fmt.Errorf("envman export failed: %v", err)
+	}
+	e.recordOutput(pathKey, fullValuePath)
+	if e.fullValuePathKeys == nil {
+		e.fullValuePathKeys = map[string]string{}
+	}
+	e.fullValuePathKeys[pathKey] = key
+
+	return nil
+}
+
+// envLengthLimit returns the maximum size of an env var value in bytes, as configured for envman
+func (e *OutputExporter) envLengthLimit() (int, error) {
+	if e.maxEnvLength == 0 {
+		maxEnvLength, err := getMaxEnvLength()
+		if err != nil {
+			return 0, err
+		}
+		e.maxEnvLength = maxEnvLength
+	}
+	return e.maxEnvLength, nil
+}
+
+func (e *OutputExporter) isExported(key string) bool {
+	for _, output := range e.outputs {
+		if output.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *OutputExporter) recordOutput(key, value string) {
+	e.outputs = append(e.outputs, exportedOutput{key: key, value: value})
+}
+
+// outputDir returns the configured output directory, or a temporary directory if none is configured
+func (e *OutputExporter) outputDir() (string, error) {
+	if e.outputsDir != "" {
+		return e.outputsDir, nil
+	}
+
+	if dir := e.checkoutResult.outputFilesDir; dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create output directory: %w", err)
+		}
+		e.outputsDir = dir
+		return dir, nil
+	}
+
+	dir, err := os.MkdirTemp("", "git-clone-outputs")
+	if err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+	e.outputsDir = dir
+	return dir, nil
+}
+
+// writeOutputFiles writes the outputs to a JSON object file and a dotenv file in the directory
+func writeOutputFiles(dir string, outputs []exportedOutput) (jsonPath, dotenvPath string, err error) {
+	values := map[string]string{}
+	for _, output := range outputs {
+		values[output.key] = output.value
+	}
+	outputsJSON, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to serialize outputs: %w", err)
+	}
+
+	jsonPath = filepath.Join(dir, outputsJSONFileName)
+	if err := os.WriteFile(jsonPath, outputsJSON, 0644); err != nil {
+		return "", "", fmt.Errorf("failed to write outputs JSON file: %w", err)
+	}
+
+	dotenvPath = filepath.Join(dir, outputsDotenvFileName)
+	if err := os.WriteFile(dotenvPath, []byte(formatDotenv(outputs)), 0644); err != nil {
+		return "", "", fmt.Errorf("failed to write outputs dotenv file: %w", err)
+	}
+
+	return jsonPath, dotenvPath, nil
+}
+
+// formatDotenv formats the outputs as KEY="value" lines.
+// Backslashes, double quotes and line breaks are escaped, so multiline values fit on a single line.
+// Dollar signs and backticks are escaped too, so sourcing the file doesn't expand variables or run commands in the values.
+func formatDotenv(outputs []exportedOutput) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, `$`, `\$`, "`", "\\`")
+
+	var b strings.Builder
+	for _, output := range outputs {
+		b.WriteString(fmt.Sprintf("%s=\"%s\"\n", output.key, escaper.Replace(output.value)))
+	}
+	return b.String()
+}
+
 func (e *OutputExporter) wrapErrorForExportCommitInfo(err error) error {
 	return newStepError("export_envs_failed", err, "Exporting envs failed")
 }
 
 func (e *OutputExporter) gitOutputs(gitRef string, isPR bool) []gitOutput {
-	outputs := []gitOutput{
-		{
-			envKey: "GIT_CLONE_COMMIT_AUTHOR_NAME",
-			gitCmd: e.checkoutResult.gitCmd.Log(`%an`, gitRef),
-		},
-		{
-			envKey: "GIT_CLONE_COMMIT_AUTHOR_EMAIL",
-			gitCmd: e.checkoutResult.gitCmd.Log(`%ae`, gitRef),
-		},
-		{
-			envKey: "GIT_CLONE_COMMIT_HASH",
-			gitCmd: e.checkoutResult.gitCmd.Log(`%H`, gitRef),
-		},
-		{
-			envKey: "GIT_CLONE_COMMIT_MESSAGE_SUBJECT",
-			gitCmd: e.checkoutResult.gitCmd.Log(`%s`, gitRef),
-		},
-		{
-			envKey: "GIT_CLONE_COMMIT_MESSAGE_BODY",
-			gitCmd: e.checkoutResult.gitCmd.Log(`%b`, gitRef),
-		},
-	}
-	if isPR {
-		e.logger.Printf("The following outputs are not exported for Pull Requests:")
-		e.logger.Printf("- %s", outputCommitterName)
-		e.logger.Printf("- %s", outputCommitterEmail)
-		e.logger.Printf("- %s", outputCommitCount)
-	} else {
-		extraOutputs := []gitOutput{
-			{
-				envKey: outputCommitterName,
-				gitCmd: e.checkoutResult.gitCmd.Log(`%cn`, gitRef),
-			},
-			{
-				envKey: outputCommitterEmail,
-				gitCmd: e.checkoutResult.gitCmd.Log(`%ce`, gitRef),
-			},
-			{
-				envKey: outputCommitCount,
-				gitCmd: e.checkoutResult.gitCmd.RevList("HEAD", "--count"),
-			},
-		}
-		outputs = append(outputs, extraOutputs...)
+	outputs := commitInfoOutputs(gitRef)
+	for _, commitOutput := range e.checkoutResult.commitOutputs {
+		ref := gitRef
+		if commitOutput.CheckedOutState {
+			ref = "HEAD"
+		}
+		outputs = append(outputs, gitOutput{envKey: commitOutput.EnvKey, format: commitOutput.Format, ref: ref})
 	}
 
 	return outputs
 }
 
-func (e *OutputExporter) printLogAndExportEnv(command *v1command.Model, env string, maxEnvLength int) error {
-	runner.PausePerformanceMonitoring()
-	defer runner.ResumePerformanceMonitoring()
+// commitInfoOutputs are the built-in commit detail outputs of the ref
+func commitInfoOutputs(gitRef string) []gitOutput {
+	return []gitOutput{
+		{envKey: "GIT_CLONE_COMMIT_AUTHOR_NAME", format: `%an`, ref: gitRef},
+		{envKey: "GIT_CLONE_COMMIT_AUTHOR_EMAIL", format: `%ae`, ref: gitRef},
+		{envKey: "GIT_CLONE_COMMIT_HASH", format: `%H`, ref: gitRef},
+		{envKey: "GIT_CLONE_COMMIT_MESSAGE_SUBJECT", format: `%s`, ref: gitRef},
+		{envKey: "GIT_CLONE_COMMIT_MESSAGE_BODY", format: `%b`, ref: gitRef},
+		{envKey: outputCommitterName, format: `%cn`, ref: gitRef},
+		{envKey: outputCommitterEmail, format: `%ce`, ref: gitRef},
+	}
+}
 
-	l, err := runner.RunForOutput(command)
-	if err != nil {
-		return fmt.Errorf("command failed: %s", err)
+// readGitOutputs evaluates the formats of the outputs with a single `git log` call per ref
+// (the build trigger ref, and HEAD if any of the user defined outputs needs the checked out state).
+// The returned values are in the order of the outputs.
+func (e *OutputExporter) readGitOutputs(gitCmd git.Git, outputs []gitOutput) ([]string, error) {
+	var refs []string
+	indexesByRef := map[string][]int{}
+	for i, output := range outputs {
+		if _, ok := indexesByRef[output.ref]; !ok {
+			refs = append(refs, output.ref)
+		}
+		indexesByRef[output.ref] = append(indexesByRef[output.ref], i)
 	}
 
-	if (env == "GIT_CLONE_COMMIT_MESSAGE_SUBJECT" || env == "GIT_CLONE_COMMIT_MESSAGE_BODY") && len(l) > maxEnvLength {
-		tv := l[:maxEnvLength-len(trimEnding)] + trimEnding
-		e.logger.Printf("Value %s  is bigger than maximum env variable size, trimming", env)
-		l = tv
+	values := make([]string, len(outputs))
+	for _, ref := range refs {
+		indexes := indexesByRef[ref]
+
+		var formats []string
+		for _, i := range indexes {
+			formats = append(formats, outputs[i].format)
+		}
+		out, err := e.runGitOutputCommand(gitCmd.Log(strings.Join(formats, gitOutputFieldSeparator), ref))
+		if err != nil {
+			return nil, err
+		}
+
+		fields := strings.Split(out, "\x00")
+		if len(fields) != len(indexes) {
+			return nil, fmt.Errorf("unexpected git log output: expected %d fields, got %d", len(indexes), len(fields))
+		}
+		for j, i := range indexes {
+			values[i] = strings.TrimSpace(fields[j])
+		}
 	}
 
-	e.logger.Printf("=> %s\n   value: %s", env, l)
-	if err := e.exporter.ExportOutput(env, l); err != nil {
-		return fmt.Errorf("envman export failed: %v", err)
+	return values, nil
+}
+
+func (e *OutputExporter) runGitOutputCommand(command *v1command.Model) (string, error) {
+	runner.PausePerformanceMonitoring()
+	defer runner.ResumePerformanceMonitoring()
+
+	out, err := runner.RunForOutput(command)
+	if err != nil {
+		return "", fmt.Errorf("command failed: %s", err)
 	}
-	return nil
+	return out, nil
 }
 
 func getMaxEnvLength() (int, error) {
@@ -141,3 +667,19 @@ func getMaxEnvLength() (int, error) {
 
 	return configs.EnvBytesLimitInKB * 1024, nil
 }
+
+// trimValue trims the value to fit into maxLength bytes (including the trim ending) without splitting a multi-byte character
+func trimValue(value string, maxLength int) (string, bool) {
+	if len(value) <= maxLength {
+		return value, false
+	}
+
+	end := maxLength - len(trimEnding)
+	if end < 0 {
+		end = 0
+	}
+	for end > 0 && !utf8.RuneStart(value[end]) {
+		end--
+	}
+	return value[:end] + trimEnding, true
+}
@@ -1,13 +1,20 @@
 package gitclone
 
 import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/bitrise-io/go-utils/command/git"
 	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/env"
 	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_gitOutputs(t *testing.T) {
@@ -20,9 +27,10 @@ func Test_gitOutputs(t *testing.T) {
 		isPR   bool
 	}
 	tests := []struct {
-		name string
-		args args
-		want []gitOutput
+		name          string
+		args          args
+		commitOutputs []CommitOutput
+		want          []gitOutput
 	}{
 		{
 			name: "Non-PR build",
@@ -31,79 +39,158 @@ func Test_gitOutputs(t *testing.T) {
 				isPR:   false,
 			},
 			want: []gitOutput{
-				{
-					envKey: "GIT_CLONE_COMMIT_AUTHOR_NAME",
-					gitCmd: gitCmd.Log("%an", "ref/tags/1.0.0"),
-				},
-				{
-					envKey: "GIT_CLONE_COMMIT_AUTHOR_EMAIL",
-					gitCmd: gitCmd.Log("%ae", "ref/tags/1.0.0"),
-				},
-				{
-					envKey: "GIT_CLONE_COMMIT_HASH",
-					gitCmd: gitCmd.Log("%H", "ref/tags/1.0.0"),
-				},
-				{
-					envKey: "GIT_CLONE_COMMIT_MESSAGE_SUBJECT",
-					gitCmd: gitCmd.Log("%s", "ref/tags/1.0.0"),
-				},
-				{
-					envKey: "GIT_CLONE_COMMIT_MESSAGE_BODY",
-					gitCmd: gitCmd.Log("%b", "ref/tags/1.0.0"),
-				},
-				{
-					envKey: "GIT_CLONE_COMMIT_COMMITTER_NAME",
-					gitCmd: gitCmd.Log("%cn", "ref/tags/1.0.0"),
-				},
-				{
-					envKey: "GIT_CLONE_COMMIT_COMMITTER_EMAIL",
-					gitCmd: gitCmd.Log("%ce", "ref/tags/1.0.0"),
-				},
-				{
-					envKey: "GIT_CLONE_COMMIT_COUNT",
-					gitCmd: gitCmd.RevList("HEAD", "--count"),
-				},
+				{envKey: "GIT_CLONE_COMMIT_AUTHOR_NAME", format: "%an", ref: "ref/tags/1.0.0"},
+				{envKey: "GIT_CLONE_COMMIT_AUTHOR_EMAIL", format: "%ae", ref: "ref/tags/1.0.0"},
+				{envKey: "GIT_CLONE_COMMIT_HASH", format: "%H", ref: "ref/tags/1.0.0"},
+				{envKey: "GIT_CLONE_COMMIT_MESSAGE_SUBJECT", format: "%s", ref: "ref/tags/1.0.0"},
+				{envKey: "GIT_CLONE_COMMIT_MESSAGE_BODY", format: "%b", ref: "ref/tags/1.0.0"},
+				{envKey: "GIT_CLONE_COMMIT_COMMITTER_NAME", format: "%cn", ref: "ref/tags/1.0.0"},
+				{envKey: "GIT_CLONE_COMMIT_COMMITTER_EMAIL", format: "%ce", ref: "ref/tags/1.0.0"},
 			},
 		},
 		{
-			name: "PR build",
+			name: "PR build with commit outputs",
 			args: args{
 				gitRef: "ref/pull/14/head",
 				isPR:   true,
 			},
+			commitOutputs: []CommitOutput{
+				{EnvKey: "PR_HEAD_DATE", Format: "%aI"},
+				{EnvKey: "MERGE_PARENTS", Format: "%P", CheckedOutState: true},
+			},
 			want: []gitOutput{
-				{
-					envKey: "GIT_CLONE_COMMIT_AUTHOR_NAME",
-					gitCmd: gitCmd.Log("%an", "ref/pull/14/head"),
-				},
-				{
-					envKey: "GIT_CLONE_COMMIT_AUTHOR_EMAIL",
-					gitCmd: gitCmd.Log("%ae", "ref/pull/14/head"),
-				},
-				{
-					envKey: "GIT_CLONE_COMMIT_HASH",
-					gitCmd: gitCmd.Log("%H", "ref/pull/14/head"),
-				},
-				{
-					envKey: "GIT_CLONE_COMMIT_MESSAGE_SUBJECT",
-					gitCmd: gitCmd.Log("%s", "ref/pull/14/head"),
-				},
-				{
-					envKey: "GIT_CLONE_COMMIT_MESSAGE_BODY",
-					gitCmd: gitCmd.Log("%b", "ref/pull/14/head"),
-				},
+				{envKey: "GIT_CLONE_COMMIT_AUTHOR_NAME", format: "%an", ref: "ref/pull/14/head"},
+				{envKey: "GIT_CLONE_COMMIT_AUTHOR_EMAIL", format: "%ae", ref: "ref/pull/14/head"},
+				{envKey: "GIT_CLONE_COMMIT_HASH", format: "%H", ref: "ref/pull/14/head"},
+				{envKey: "GIT_CLONE_COMMIT_MESSAGE_SUBJECT", format: "%s", ref: "ref/pull/14/head"},
+				{envKey: "GIT_CLONE_COMMIT_MESSAGE_BODY", format: "%b", ref: "ref/pull/14/head"},
+				{envKey: "GIT_CLONE_COMMIT_COMMITTER_NAME", format: "%cn", ref: "ref/pull/14/head"},
+				{envKey: "GIT_CLONE_COMMIT_COMMITTER_EMAIL", format: "%ce", ref: "ref/pull/14/head"},
+				{envKey: "PR_HEAD_DATE", format: "%aI", ref: "ref/pull/14/head"},
+				{envKey: "MERGE_PARENTS", format: "%P", ref: "HEAD"},
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			r := CheckoutStateResult{
-				gitRef: tt.args.gitRef,
-				isPR:   tt.args.isPR,
-				gitCmd: gitCmd,
+				gitRef:        tt.args.gitRef,
+				isPR:          tt.args.isPR,
+				gitCmd:        gitCmd,
+				commitOutputs: tt.commitOutputs,
 			}
 			e := NewOutputExporter(log.NewLogger(), command.NewFactory(env.NewRepository()), r)
 			assert.Equalf(t, tt.want, e.gitOutputs(tt.args.gitRef, tt.args.isPR), "gitOutputs(%v, %v)", tt.args.gitRef, tt.args.isPR)
 		})
 	}
 }
+
+func Test_readGitOutputs(t *testing.T) {
+	mockRunner := new(MockRunner)
+	mockRunner.
+		GivenRunForOutputReturnsForCommand(`git "log" "-1" "--format=%an%x00%b%x00%aI" "ref/pull/14/head"`, "Jane Doe\x00First line\n\nSecond line\n\x002024-03-01T10:00:00+01:00").
+		GivenRunForOutputReturnsForCommand(`git "log" "-1" "--format=%P" "HEAD"`, "76a934ae 5b3dfe10")
+	runner = mockRunner
+
+	r := CheckoutStateResult{gitCmd: git.Git{}}
+	e := NewOutputExporter(log.NewLogger(), command.NewFactory(env.NewRepository()), r)
+
+	values, err := e.readGitOutputs(r.gitCmd, []gitOutput{
+		{envKey: "GIT_CLONE_COMMIT_AUTHOR_NAME", format: "%an", ref: "ref/pull/14/head"},
+		{envKey: "GIT_CLONE_COMMIT_MESSAGE_BODY", format: "%b", ref: "ref/pull/14/head"},
+		{envKey: "MERGE_PARENTS", format: "%P", ref: "HEAD"},
+		{envKey: "PR_HEAD_DATE", format: "%aI", ref: "ref/pull/14/head"},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Jane Doe", "First line\n\nSecond line", "76a934ae 5b3dfe10", "2024-03-01T10:00:00+01:00"}, values)
+	assert.Len(t, mockRunner.Cmds(), 2)
+}
+
+func Test_exportOutput_fullValuePathCollision(t *testing.T) {
+	// A fake envman accepting every output
+	binDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "envman"), []byte("#!/bin/sh\n"), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	e := NewOutputExporter(log.NewLogger(), command.NewFactory(env.NewRepository()), CheckoutStateResult{outputFilesDir: t.TempDir()})
+	e.maxEnvLength = 10
+	longValue := strings.Repeat("x", 20)
+
+	// The generated path would overwrite an output
+	require.NoError(t, e.exportOutput("MY_OUTPUT_PATH", "value"))
+	require.NoError(t, e.exportOutput("MY_OUTPUT", longValue))
+	// An output overwrites the generated path
+	require.NoError(t, e.exportOutput("OTHER", longValue))
+	require.NoError(t, e.exportOutput("OTHER_PATH", "value"))
+
+	assert.Equal(t, []exportedOutput{
+		{key: "MY_OUTPUT_PATH", value: "value"},
+		{key: "MY_OUTPUT", value: longValue},
+		{key: "OTHER", value: longValue},
+		{key: "OTHER_PATH", value: filepath.Join(e.outputsDir, "OTHER.txt")},
+		{key: "OTHER_PATH", value: "value"},
+	}, e.outputs)
+}
+
+func Test_writeOutputFiles(t *testing.T) {
+	outputs := []exportedOutput{
+		{key: "GIT_CLONE_COMMIT_HASH", value: "76a934ae"},
+		{key: "GIT_CLONE_COMMIT_MESSAGE_BODY", value: "First line\r\nSecond \"quoted\" line\nC:\\path"},
+		{key: "GIT_CLONE_COMMIT_RANGE", value: ""},
+		{key: "GIT_CLONE_COMMIT_MESSAGE_SUBJECT", value: "Use $HOME and `id` in ${PATH}"},
+	}
+
+	jsonPath, dotenvPath, err := writeOutputFiles(t.TempDir(), outputs)
+	assert.NoError(t, err)
+
+	jsonContent, err := os.ReadFile(jsonPath)
+	assert.NoError(t, err)
+	var values map[string]string
+	assert.NoError(t, json.Unmarshal(jsonContent, &values))
+	assert.Equal(t, map[string]string{
+		"GIT_CLONE_COMMIT_HASH":            "76a934ae",
+		"GIT_CLONE_COMMIT_MESSAGE_BODY":    "First line\r\nSecond \"quoted\" line\nC:\\path",
+		"GIT_CLONE_COMMIT_RANGE":           "",
+		"GIT_CLONE_COMMIT_MESSAGE_SUBJECT": "Use $HOME and `id` in ${PATH}",
+	}, values)
+
+	dotenvContent, err := os.ReadFile(dotenvPath)
+	assert.NoError(t, err)
+	assert.Equal(t, `GIT_CLONE_COMMIT_HASH="76a934ae"
+GIT_CLONE_COMMIT_MESSAGE_BODY="First line\r\nSecond \"quoted\" line\nC:\\path"
+GIT_CLONE_COMMIT_RANGE=""
+GIT_CLONE_COMMIT_MESSAGE_SUBJECT="Use \$HOME and \`+"`"+`id\`+"`"+` in \${PATH}"
+`, string(dotenvContent))
+
+	// Sourcing the file doesn't expand the values
+	out, err := exec.Command("sh", "-c", `. "$1" && printf %s "$GIT_CLONE_COMMIT_MESSAGE_SUBJECT"`, "sh", dotenvPath).CombinedOutput()
+	assert.NoError(t, err, string(out))
+	assert.Equal(t, "Use $HOME and `id` in ${PATH}", string(out))
+}
+
+func Test_trimValue(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		maxLength   int
+		want        string
+		wantTrimmed bool
+	}{
+		{name: "Fits", value: "Fix crash", maxLength: 9, want: "Fix crash"},
+		{name: "ASCII", value: "Fix crash on start", maxLength: 9, want: "Fix cr...", wantTrimmed: true},
+		// "é" is 2 bytes, the cut at byte 4 would split it
+		{name: "Multi-byte character at the cut", value: "Café au lait", maxLength: 7, want: "Caf...", wantTrimmed: true},
+		// "🚀" is 4 bytes
+		{name: "Emoji at the cut", value: "Go🚀🚀", maxLength: 7, want: "Go...", wantTrimmed: true},
+		{name: "Limit shorter than the trim ending", value: "Fix crash", maxLength: 2, want: "...", wantTrimmed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, trimmed := trimValue(tt.value, tt.maxLength)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantTrimmed, trimmed)
+			assert.True(t, utf8.ValidString(got))
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package gitclone
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command/git"
+)
+
+// prMergeInfo describes how the checked out state of a Pull Request build relates to its branches
+type prMergeInfo struct {
+	// syntheticMerge is true if the checked out state is the result of merging the PR into the destination branch
+	// (as opposed to the PR head commit itself)
+	syntheticMerge bool
+	// mergeCommit is the checked out merge commit, it is empty if the merged state is not a commit
+	// (the PR head is checked out, the merge was a fast-forward or the PR diff is applied to the working tree)
+	mergeCommit string
+	// destCommit is the tip of the destination branch the PR was merged into (or would be merged into)
+	destCommit string
+	// mergeBase is the best common ancestor of the PR head and the destination branch tip
+	mergeBase string
+}
+
+// collectPRMergeInfo inspects the result of the PR checkout strategy.
+// All PR strategies are handled here, so the outputs are consistent regardless of how the merged state was created
+// (and whether the strategy had to fall back to a manual merge).
+// Failures are only reported as warnings, the checkout itself has already succeeded at this point.
+func (g GitCloner) collectPRMergeInfo(gitCmd git.Git, cfg Config, strategy checkoutStrategy) *prMergeInfo {
+	g.logger.Println()
+	g.logger.Infof("Collecting Pull Request merge details")
+
+	head, err := runner.RunForOutput(gitCmd.RevParse("HEAD"))
+	if err != nil {
+		g.logger.Warnf("Pull Request merge details are not exported: %s", err)
+		return nil
+	}
+
+	var info prMergeInfo
+	var prHead string
+	if ref := strategy.getBuildTriggerRef(); ref != "" {
+		if prHead, err = runner.RunForOutput(gitCmd.RevParse(ref + "^{commit}")); err != nil {
+			g.logger.Warnf("Failed to resolve the Pull Request head (%s): %s", ref, err)
+			prHead = ""
+		}
+	}
+
+	switch strategy.(type) {
+	case checkoutPRMergeRef, checkoutPRManualMerge, checkoutPRDiffFile:
+		info.syntheticMerge = true
+	}
+
+	if _, isDiffFile := strategy.(checkoutPRDiffFile); isDiffFile {
+		// The diff is applied to the index on top of the destination branch, unless applying it failed
+		// and the strategy fell back to a manual merge (which leaves a clean working tree behind)
+		if clean, err := isWorkingTreeClean(gitCmd); err == nil && !clean {
+			info.destCommit = head
+		}
+	}
+
+	if info.syntheticMerge && info.destCommit == "" {
+		parents, err := commitParents(gitCmd, "HEAD")
+		if err != nil {
+			g.logger.Warnf("Failed to read the parents of the merge commit: %s", err)
+		}
+		if len(parents) > 1 {
+			// The destination branch is the first parent of the merge commit, both for git server created merge refs and local merges
+			info.mergeCommit = head
+			info.destCommit = parents[0]
+		} else {
+			// Fast-forward merge, the PR head is checked out
+			info.syntheticMerge = false
+		}
+	}
+
+	if info.destCommit == "" && cfg.PRDestBranch != "" {
+		if info.destCommit, err = remoteBranchTip(gitCmd, cfg.PRDestBranch); err != nil {
+			g.logger.Warnf("Failed to resolve the destination branch tip (%s): %s", cfg.PRDestBranch, err)
+		}
+	}
+
+	if prHead != "" && info.destCommit != "" {
+		gitDir := cfg.commonGitDir()
+		if _, err := fetchCommitRangeBase(gitCmd, gitDir, newHistoryLimit(cfg), info.destCommit, info.destCommit); err != nil {
+			g.logger.Warnf("Failed to fetch the destination branch tip: %s", err)
+		} else if info.mergeBase, err = deepenUntilMergeBase(gitCmd, gitDir, prMergeDeepenTargets(cfg, strategy), info.destCommit, prHead); err != nil {
+			g.logger.Warnf("Failed to find the merge-base of the Pull Request: %s", err)
+		}
+	}
+
+	return &info
+}
+
+// prMergeDeepenTargets returns the refs fetched by the strategy and the destination branch, their history is deepened to find the merge-base
+func prMergeDeepenTargets(cfg Config, strategy checkoutStrategy) []fetchTarget {
+	targets := strategy.fetchTargets()
+	if cfg.PRDestBranch != "" {
+		targets = appendFetchTarget(targets, fetchTarget{remote: originRemoteName, ref: refsHeadsPrefix + cfg.PRDestBranch})
+	}
+	return targets
+}
+
+// commitParents returns the parent hashes of the commit as recorded in the commit object,
+// so the parents are known even if they are not part of the shallow history
+func commitParents(gitCmd git.Git, rev string) ([]string, error) {
+	out, err := runner.RunForOutput(gitCommand(gitCmd, "cat-file", "commit", rev))
+	if err != nil {
+		return nil, err
+	}
+
+	var parents []string
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			// The headers end at the first empty line, the commit message follows
+			break
+		}
+		if strings.HasPrefix(line, "parent ") {
+			parents = append(parents, strings.TrimPrefix(line, "parent "))
+		}
+	}
+
+	return parents, nil
+}
+
+// remoteBranchTip returns the commit of the remote branch, without fetching it
+func remoteBranchTip(gitCmd git.Git, branch string) (string, error) {
+	out, err := runner.RunForOutput(gitCommand(gitCmd, "ls-remote", originRemoteName, refsHeadsPrefix+branch))
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == refsHeadsPrefix+branch {
+			return fields[0], nil
+		}
+	}
+
+	return "", fmt.Errorf("branch not found on %s", originRemoteName)
+}
@@ -0,0 +1,70 @@
+package gitclone
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/command/git"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_commitParents(t *testing.T) {
+	tests := []struct {
+		name   string
+		commit string
+		want   []string
+	}{
+		{
+			name: "Merge commit",
+			commit: `tree 8b1d5a0a3ff1fa5e07f8bb0e7e3a5a2d2bf4c9d1
+parent 76a934ae8b2b1f3ba2e3e3c5a7b0a7b3e1d0b8c2
+parent 5b3dfe10a2e4b1d0c9f8e7a6b5c4d3e2f1a0b9c8
+author Jane Doe <jane@example.com> 1709283600 +0100
+committer GitHub <noreply@github.com> 1709283600 +0100
+
+Merge 5b3dfe10 into 76a934ae
+
+parent of nothing`,
+			want: []string{"76a934ae8b2b1f3ba2e3e3c5a7b0a7b3e1d0b8c2", "5b3dfe10a2e4b1d0c9f8e7a6b5c4d3e2f1a0b9c8"},
+		},
+		{
+			name: "Root commit",
+			commit: `tree 8b1d5a0a3ff1fa5e07f8bb0e7e3a5a2d2bf4c9d1
+author Jane Doe <jane@example.com> 1709283600 +0100
+committer Jane Doe <jane@example.com> 1709283600 +0100
+
+Initial commit`,
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRunner := new(MockRunner)
+			mockRunner.GivenRunForOutputReturnsForCommand(`git "cat-file" "commit" "HEAD"`, tt.commit)
+			runner = mockRunner
+
+			got, err := commitParents(git.Git{}, "HEAD")
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_remoteBranchTip(t *testing.T) {
+	lsRemoteCmd := `git "ls-remote" "origin" "refs/heads/master"`
+
+	mockRunner := new(MockRunner)
+	mockRunner.GivenRunForOutputReturnsForCommand(lsRemoteCmd, "76a934ae8b2b1f3ba2e3e3c5a7b0a7b3e1d0b8c2\trefs/heads/master")
+	runner = mockRunner
+
+	tip, err := remoteBranchTip(git.Git{}, "master")
+	assert.NoError(t, err)
+	assert.Equal(t, "76a934ae8b2b1f3ba2e3e3c5a7b0a7b3e1d0b8c2", tip)
+
+	mockRunner = new(MockRunner)
+	mockRunner.GivenRunForOutputReturnsForCommand(lsRemoteCmd, "")
+	runner = mockRunner
+
+	_, err = remoteBranchTip(git.Git{}, "master")
+	assert.Error(t, err)
+}
@@ -0,0 +1,228 @@
+package gitclone
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/command/git"
+)
+
+// interruptedOperation is a git operation which leaves its state behind in the git directory if the process is killed.
+// The first state file marks the operation in progress, all of them are removed if aborting the operation fails.
+type interruptedOperation struct {
+	name       string
+	stateFiles []string
+	abortArgs  []string
+}
+
+var interruptedOperations = []interruptedOperation{
+	{name: "merge", stateFiles: []string{"MERGE_HEAD", "MERGE_MSG", "MERGE_MODE", "AUTO_MERGE"}, abortArgs: []string{"merge", "--abort"}},
+	{name: "rebase", stateFiles: []string{"rebase-merge"}, abortArgs: []string{"rebase", "--abort"}},
+	// git am and git rebase (apply backend) share the rebase-apply directory, am also creates the applying file
+	{name: "patch application (git am)", stateFiles: []string{filepath.Join("rebase-apply", "applying"), "rebase-apply"}, abortArgs: []string{"am", "--abort"}},
+	{name: "rebase", stateFiles: []string{"rebase-apply"}, abortArgs: []string{"rebase", "--abort"}},
+	{name: "cherry-pick", stateFiles: []string{"CHERRY_PICK_HEAD", "sequencer"}, abortArgs: []string{"cherry-pick", "--abort"}},
+	{name: "revert", stateFiles: []string{"REVERT_HEAD", "sequencer"}, abortArgs: []string{"revert", "--abort"}},
+	{name: "cherry-pick or revert sequence", stateFiles: []string{"sequencer"}, abortArgs: []string{"cherry-pick", "--quit"}},
+}
+
+// gitProcess is a running git process and its working directory
+type gitProcess struct {
+	pid int
+	dir string
+}
+
+// recoverInterruptedRun repairs the repository left behind by an interrupted (killed) previous build, so the following git commands
+// don't fail with errors like "Unable to create '.../index.lock': File exists". The lock files are removed (unless a running git process
+// might own them), and the interrupted merge, rebase, cherry-pick, revert or patch application is aborted.
+// The changes of a half-applied patch are discarded by the cleanup of the dirty working tree later.
+// It returns the performed repairs, failures are only logged as the following git commands report the problem anyway.
+func (g GitCloner) recoverInterruptedRun(gitCmd git.Git) []string {
+	out, err := runner.RunForOutput(gitCommand(gitCmd, "rev-parse", "--path-format=absolute", "--absolute-git-dir", "--git-common-dir"))
+	if err != nil {
+		g.logger.Warnf("Failed to find the git directory: %s", err)
+		return nil
+	}
+	gitDir, commonDir, _ := strings.Cut(out, "\n")
+	commonDir = strings.TrimSpace(commonDir)
+	if commonDir == "" {
+		commonDir = gitDir
+	}
+
+	var repairs []string
+	if owners, err := g.gitProcessesOf(gitCmd, commonDir); err != nil {
+		g.logger.Warnf("Failed to check for running git processes, keeping the lock files: %s", err)
+	} else if len(owners) > 0 {
+		var pids []string
+		for _, owner := range owners {
+			pids = append(pids, strconv.Itoa(owner.pid))
+		}
+		g.logger.Warnf("Git is running in the repository (PID %s), keeping the lock files", strings.Join(pids, ", "))
+	} else {
+		// The git directory of a linked worktree is inside the common directory
+		removed, err := removeLockFiles(commonDir)
+		if err != nil {
+			g.logger.Warnf("Failed to clean up lock files: %s", err)
+		}
+		for _, path := range removed {
+			repairs = append(repairs, fmt.Sprintf("Removed %s", path))
+		}
+	}
+
+	for _, operation := range interruptedOperations {
+		if _, err := os.Lstat(filepath.Join(gitDir, operation.stateFiles[0])); err != nil {
+			continue
+		}
+
+		if abortErr := runner.Run(gitCommand(gitCmd, operation.abortArgs...)); abortErr != nil {
+			g.logger.Warnf("Failed to abort the interrupted %s, removing its state: %s", operation.name, abortErr)
+			for _, stateFile := range operation.stateFiles {
+				if err := os.RemoveAll(filepath.Join(gitDir, stateFile)); err != nil {
+					g.logger.Warnf("Failed to remove %s: %s", stateFile, err)
+				}
+			}
+			repairs = append(repairs, fmt.Sprintf("Removed the state of the interrupted %s", operation.name))
+			continue
+		}
+		repairs = append(repairs, fmt.Sprintf("Aborted the interrupted %s", operation.name))
+	}
+
+	if len(repairs) > 0 {
+		g.logger.Println()
+		g.logger.Warnf("Repaired the repository after an interrupted previous run:")
+		for _, repair := range repairs {
+			g.logger.Printf("- %s", repair)
+		}
+	}
+
+	return repairs
+}
+
+// gitProcessesOf returns the running git processes working in the repository (in any of its worktrees or in the git directory)
+func (g GitCloner) gitProcessesOf(gitCmd git.Git, commonDir string) ([]gitProcess, error) {
+	dirs := []string{commonDir}
+	out, err := runner.RunForOutput(gitCommand(gitCmd, "worktree", "list", "--porcelain"))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if dir, found := strings.CutPrefix(strings.TrimSpace(line), "worktree "); found {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	processes, err := runningGitProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	var owners []gitProcess
+	for _, process := range processes {
+		for _, dir := range dirs {
+			if isSamePath(process.dir, dir) || isPathInside(process.dir, dir) {
+				owners = append(owners, process)
+				break
+			}
+		}
+	}
+	return owners, nil
+}
+
+// runningGitProcesses lists the git processes (git and its helpers, such as git-remote-https) with their working directories
+func runningGitProcesses() ([]gitProcess, error) {
+	switch runtime.GOOS {
+	case "linux":
+		return procGitProcesses()
+	case "darwin":
+		return lsofGitProcesses()
+	default:
+		return nil, fmt.Errorf("listing the processes is not supported on %s", runtime.GOOS)
+	}
+}
+
+func procGitProcesses() ([]gitProcess, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	var processes []gitProcess
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "comm"))
+		if err != nil || !isGitCommand(strings.TrimSpace(string(comm))) {
+			continue
+		}
+		// The working directory can't be read if the process has exited since, or if it belongs to another user
+		dir, err := os.Readlink(filepath.Join("/proc", entry.Name(), "cwd"))
+		if err != nil {
+			continue
+		}
+		processes = append(processes, gitProcess{pid: pid, dir: dir})
+	}
+	return processes, nil
+}
+
+func lsofGitProcesses() ([]gitProcess, error) {
+	if _, err := exec.LookPath("lsof"); err != nil {
+		return nil, err
+	}
+
+	// lsof exits with 1 (without any output) if no process matches
+	out, err := command.New("lsof", "-a", "-d", "cwd", "-c", "git", "-F", "pcn").RunAndReturnTrimmedOutput()
+	if err != nil && out != "" {
+		return nil, fmt.Errorf("%s: %w", out, err)
+	}
+
+	var processes []gitProcess
+	var current gitProcess
+	var currentCommand string
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			continue
+		}
+		switch value := line[1:]; line[0] {
+		case 'p':
+			current = gitProcess{}
+			currentCommand = ""
+			current.pid, _ = strconv.Atoi(value)
+		case 'c':
+			currentCommand = value
+		case 'n':
+			if isGitCommand(currentCommand) {
+				current.dir = value
+				processes = append(processes, current)
+			}
+		}
+	}
+	return processes, nil
+}
+
+// isGitCommand reports whether the process name is git or one of its helpers (but not for example gitlab-runner)
+func isGitCommand(name string) bool {
+	return name == "git" || strings.HasPrefix(name, "git-")
+}
+
+// isPathInside reports whether path is inside dir (at any depth)
+func isPathInside(path, dir string) bool {
+	resolve := func(p string) string {
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			return resolved
+		}
+		return p
+	}
+	rel, err := filepath.Rel(resolve(dir), resolve(path))
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,121 @@
+package gitclone
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/command/git"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_recoverInterruptedRun(t *testing.T) {
+	runner = &DefaultRunner{}
+	cloner := GitCloner{logger: log.NewLogger()}
+
+	gitArgs := func(dir string, args ...string) []string {
+		return append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	}
+	runGit := func(t *testing.T, dir string, args ...string) {
+		out, err := exec.Command("git", gitArgs(dir, args...)...).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	// givenConflictingBranches creates a repository where merging (or cherry-picking) the other branch into main conflicts
+	givenConflictingBranches := func(t *testing.T) (string, git.Git) {
+		dir := t.TempDir()
+		runGit(t, dir, "init", "-q", "--initial-branch=main")
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("base\n"), 0644))
+		runGit(t, dir, "add", "file.txt")
+		runGit(t, dir, "commit", "-q", "-m", "base")
+		runGit(t, dir, "checkout", "-q", "-b", "other")
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("other\n"), 0644))
+		runGit(t, dir, "commit", "-q", "-am", "other")
+		runGit(t, dir, "checkout", "-q", "main")
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("main\n"), 0644))
+		runGit(t, dir, "commit", "-q", "-am", "main")
+
+		gitCmd, err := git.New(dir)
+		require.NoError(t, err)
+		return dir, gitCmd
+	}
+
+	t.Run("Removes the lock files and aborts the interrupted merge", func(t *testing.T) {
+		// Given
+		dir, gitCmd := givenConflictingBranches(t)
+		require.Error(t, exec.Command("git", gitArgs(dir, "merge", "other")...).Run())
+		require.FileExists(t, filepath.Join(dir, ".git", "MERGE_HEAD"))
+		lockFiles := []string{
+			filepath.Join(dir, ".git", "index.lock"),
+			filepath.Join(dir, ".git", "shallow.lock"),
+			filepath.Join(dir, ".git", "objects", "pack", "tmp_pack_a1b2c3"),
+		}
+		for _, path := range lockFiles {
+			require.NoError(t, os.WriteFile(path, nil, 0644))
+		}
+
+		// When
+		repairs := cloner.recoverInterruptedRun(gitCmd)
+
+		// Then
+		for _, path := range lockFiles {
+			assert.NoFileExists(t, path)
+		}
+		assert.NoFileExists(t, filepath.Join(dir, ".git", "MERGE_HEAD"))
+		assert.Len(t, repairs, len(lockFiles)+1)
+		assert.Equal(t, "Aborted the interrupted merge", repairs[len(repairs)-1])
+
+		out, err := exec.Command("git", "-C", dir, "status", "--porcelain").CombinedOutput()
+		require.NoError(t, err)
+		assert.Empty(t, strings.TrimSpace(string(out)))
+	})
+
+	t.Run("Aborts the interrupted cherry-pick", func(t *testing.T) {
+		// Given
+		dir, gitCmd := givenConflictingBranches(t)
+		require.Error(t, exec.Command("git", gitArgs(dir, "cherry-pick", "other")...).Run())
+		require.FileExists(t, filepath.Join(dir, ".git", "CHERRY_PICK_HEAD"))
+
+		// When
+		repairs := cloner.recoverInterruptedRun(gitCmd)
+
+		// Then
+		assert.Equal(t, []string{"Aborted the interrupted cherry-pick"}, repairs)
+		assert.NoFileExists(t, filepath.Join(dir, ".git", "CHERRY_PICK_HEAD"))
+	})
+
+	t.Run("Keeps the lock files of a running git process", func(t *testing.T) {
+		// Given
+		dir, gitCmd := givenConflictingBranches(t)
+		lockFile := filepath.Join(dir, ".git", "index.lock")
+		require.NoError(t, os.WriteFile(lockFile, nil, 0644))
+
+		// A git process waiting for input in a subdirectory of the repository
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+		cmd := exec.Command("git", "cat-file", "--batch")
+		cmd.Dir = filepath.Join(dir, "sub")
+		stdin, err := cmd.StdinPipe()
+		require.NoError(t, err)
+		require.NoError(t, cmd.Start())
+		defer func() {
+			require.NoError(t, stdin.Close())
+			require.NoError(t, cmd.Wait())
+		}()
+
+		// When
+		repairs := cloner.recoverInterruptedRun(gitCmd)
+
+		// Then
+		assert.Empty(t, repairs)
+		assert.FileExists(t, lockFile)
+	})
+
+	t.Run("Nothing to repair", func(t *testing.T) {
+		_, gitCmd := givenConflictingBranches(t)
+
+		assert.Empty(t, cloner.recoverInterruptedRun(gitCmd))
+	})
+}
@@ -0,0 +1,243 @@
+// Package repourl parses the git repository URLs into a repository identity,
+// so the different URLs (HTTPS, SSH, with or without the .git suffix) of the same repository can be compared.
+package repourl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Transport is the protocol used to access the repository
+type Transport string
+
+const (
+	TransportHTTPS Transport = "https"
+	TransportHTTP  Transport = "http"
+	// TransportSSH is used both by the ssh://[user@]host[:port]/path and the scp-like [user@]host:path URLs
+	TransportSSH Transport = "ssh"
+	TransportGit Transport = "git"
+	// TransportFile is used both by the file:// URLs and the local paths
+	TransportFile Transport = "file"
+)
+
+// IsHTTP reports whether the transport is HTTP or HTTPS
+func (t Transport) IsHTTP() bool {
+	return t == TransportHTTPS || t == TransportHTTP
+}
+
+var (
+	errEmptyURL    = errors.New("empty repository URL")
+	errMissingHost = errors.New("missing hostname")
+	errMissingName = errors.New("missing repository name")
+)
+
+// Repository is a repository URL parsed into its parts.
+// The credentials of HTTP(S) URLs are never stored.
+type Repository struct {
+	Transport Transport
+	// User is the user of SSH URLs, for example git
+	User string
+	// Host is the lowercase hostname, empty for local repositories
+	Host string
+	Port string
+	// Owner is the path between the host and the repository name, for example an organization,
+	// a GitLab group with subgroups (group/subgroup) or an Azure DevOps project (org/project/_git)
+	Owner string
+	// Name is the repository name without the .git suffix
+	Name string
+}
+
+// Parse parses the repository URL. Supported formats:
+//
+//	https://[user[:password]@]hostname[:port]/owner/repository[.git]
+//	http://[user[:password]@]hostname[:port]/owner/repository[.git]
+//	ssh://[user@]hostname[:port]/owner/repository[.git]
+//	[user@]hostname:owner/repository[.git]
+//	git://hostname[:port]/owner/repository[.git]
+//	file:///path/to/repository[.git]
+//	/path/to/repository[.git]
+func Parse(rawURL string) (Repository, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return Repository{}, errEmptyURL
+	}
+
+	var repo Repository
+	var authority, path string
+	if scheme, rest, found := strings.Cut(rawURL, "://"); found {
+		switch strings.ToLower(scheme) {
+		case "https":
+			repo.Transport = TransportHTTPS
+		case "http":
+			repo.Transport = TransportHTTP
+		case "ssh", "git+ssh", "ssh+git":
+			repo.Transport = TransportSSH
+		case "git":
+			repo.Transport = TransportGit
+		case "file":
+			repo.Transport = TransportFile
+		default:
+			return Repository{}, fmt.Errorf("unsupported URL scheme: %s", scheme)
+		}
+
+		if repo.Transport == TransportFile {
+			path = rest
+		} else {
+			authority, path, _ = strings.Cut(rest, "/")
+		}
+	} else if host, scpPath, found := strings.Cut(rawURL, ":"); found && !strings.Contains(host, "/") && !isWindowsDrive(host) {
+		repo.Transport = TransportSSH
+		authority, path = host, scpPath
+	} else {
+		repo.Transport = TransportFile
+		path = rawURL
+	}
+
+	if repo.Transport != TransportFile {
+		if err := repo.parseAuthority(authority); err != nil {
+			return Repository{}, err
+		}
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment = strings.TrimSpace(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		return Repository{}, errMissingName
+	}
+	repo.Name = strings.TrimSpace(strings.TrimSuffix(segments[len(segments)-1], ".git"))
+	repo.Owner = strings.Join(segments[:len(segments)-1], "/")
+	if repo.Name == "" {
+		return Repository{}, errMissingName
+	}
+
+	return repo, nil
+}
+
+func (r *Repository) parseAuthority(authority string) error {
+	hostPort := authority
+	if idx := strings.LastIndex(authority, "@"); idx != -1 {
+		userInfo := authority[:idx]
+		hostPort = authority[idx+1:]
+		// The user of HTTP(S) URLs is often an access token, only the SSH user is kept
+		if r.Transport == TransportSSH {
+			r.User, _, _ = strings.Cut(userInfo, ":")
+		}
+	}
+
+	host, port := hostPort, ""
+	if strings.HasPrefix(hostPort, "[") {
+		// IPv6 address, for example [::1]:22
+		end := strings.Index(hostPort, "]")
+		if end == -1 {
+			return fmt.Errorf("invalid hostname: %s", hostPort)
+		}
+		host = hostPort[:end+1]
+		port = strings.TrimPrefix(hostPort[end+1:], ":")
+	} else if h, p, found := strings.Cut(hostPort, ":"); found {
+		host, port = h, p
+	}
+
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("invalid port: %s", port)
+		}
+	}
+	if host == "" || strings.ContainsAny(host, " \t/\\") {
+		return errMissingHost
+	}
+
+	r.Host = strings.ToLower(host)
+	r.Port = port
+	return nil
+}
+
+// A single letter followed by a colon is a Windows drive (C:\repo), not an scp-like host
+func isWindowsDrive(host string) bool {
+	return len(host) == 1 && (host[0] >= 'a' && host[0] <= 'z' || host[0] >= 'A' && host[0] <= 'Z')
+}
+
+// ID returns the identity of the repository (hostname/owner/repository), independent of the transport, user and port.
+// The SSH and HTTPS URLs of Azure DevOps repositories are normalized to the same identity.
+func (r Repository) ID() string {
+	host, owner := r.Host, r.Owner
+	if host == "ssh.dev.azure.com" {
+		// git@ssh.dev.azure.com:v3/org/project/repository
+		host = "dev.azure.com"
+		owner = strings.TrimPrefix(owner, "v3/")
+	}
+	if host == "dev.azure.com" {
+		// https://dev.azure.com/org/project/_git/repository
+		owner = strings.TrimSuffix(owner, "/_git")
+	}
+
+	path := r.Name
+	if owner != "" {
+		path = owner + "/" + path
+	}
+	if host == "" {
+		return path
+	}
+	return host + "/" + path
+}
+
+// Equal reports whether the two URLs point to the same repository
+func (r Repository) Equal(other Repository) bool {
+	return r.ID() == other.ID()
+}
+
+// String returns the URL of the repository (with the .git suffix) without credentials
+func (r Repository) String() string {
+	path := r.Name + ".git"
+	if r.Owner != "" {
+		path = r.Owner + "/" + path
+	}
+	if r.Transport == TransportFile {
+		return "file:///" + path
+	}
+
+	authority := r.Host
+	if r.Port != "" {
+		authority += ":" + r.Port
+	}
+	if r.User != "" {
+		authority = r.User + "@" + authority
+	}
+	return string(r.Transport) + "://" + authority + "/" + path
+}
+
+// Redact returns the URL with the credentials replaced, so it can be logged or exported.
+// The whole user info of HTTP(S) URLs is replaced, as the user itself is often an access token.
+func Redact(rawURL string) string {
+	scheme, rest, found := strings.Cut(rawURL, "://")
+	if !found {
+		return rawURL
+	}
+
+	authority, path, hasPath := strings.Cut(rest, "/")
+	idx := strings.LastIndex(authority, "@")
+	if idx == -1 {
+		return rawURL
+	}
+
+	userInfo, hostPort := authority[:idx], authority[idx+1:]
+	switch {
+	case strings.EqualFold(scheme, "https") || strings.EqualFold(scheme, "http"):
+		userInfo = "***"
+	case strings.Contains(userInfo, ":"):
+		user, _, _ := strings.Cut(userInfo, ":")
+		userInfo = user + ":***"
+	default:
+		return rawURL
+	}
+
+	redacted := scheme + "://" + userInfo + "@" + hostPort
+	if hasPath {
+		redacted += "/" + path
+	}
+	return redacted
+}
@@ -0,0 +1,256 @@
+package gitclone
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/command/git"
+)
+
+const signatureVerificationFailedTag = "signature_verification_failed"
+
+// SignaturePolicy controls what happens if the signature of the checked out commit (or tag) can not be verified
+type SignaturePolicy string
+
+const (
+	// SignaturePolicyOff skips signature verification
+	SignaturePolicyOff SignaturePolicy = "off"
+	// SignaturePolicyWarn verifies the signature and logs a warning if the verification fails
+	SignaturePolicyWarn SignaturePolicy = "warn"
+	// SignaturePolicyRequire verifies the signature and fails the checkout if the verification fails
+	SignaturePolicyRequire SignaturePolicy = "require"
+)
+
+type signatureStatus string
+
+const (
+	signatureStatusGood       signatureStatus = "good"
+	signatureStatusBad        signatureStatus = "bad"
+	signatureStatusUnknownKey signatureStatus = "unknown_key"
+	signatureStatusExpired    signatureStatus = "expired"
+	signatureStatusRevoked    signatureStatus = "revoked"
+	signatureStatusUnsigned   signatureStatus = "unsigned"
+	signatureStatusError      signatureStatus = "error"
+)
+
+const gpgStatusPrefix = "[GNUPG:] "
+
+// Example: Good "git" signature for jane@example.com with ED25519 key SHA256:le6qNnbLHGCs9Toytyv7pTALz3Ij9Q5L3zxdVH/YVmg
+// The principal is missing if the key is not listed in the allowed signers file.
+var sshGoodSignaturePattern = regexp.MustCompile(`^Good "git" signature(?: for (.+?))? with \S+ key (\S+)$`)
+
+// signatureInfo is the result of verifying the signature of the checked out commit or tag
+type signatureInfo struct {
+	// target is the verified object, either a commit or an annotated tag
+	target      string
+	status      signatureStatus
+	signer      string
+	fingerprint string
+	// details holds the verification output if the status is not good
+	details string
+}
+
+func (s signatureInfo) String() string {
+	if s.status == signatureStatusGood {
+		return fmt.Sprintf("%s is signed by %s (%s)", s.target, s.signer, s.fingerprint)
+	}
+
+	msg := fmt.Sprintf("%s signature status: %s", s.target, s.status)
+	if s.details != "" {
+		msg += "\n" + s.details
+	}
+	return msg
+}
+
+// checkSignature verifies the signature according to SignaturePolicy: a failed verification fails the checkout
+// with the require policy, and is only logged with the warn policy. It returns nil if the verification is off.
+func (g GitCloner) checkSignature(gitCmd git.Git, cfg Config, strategy checkoutStrategy, isPR bool) (*signatureInfo, error) {
+	if cfg.SignaturePolicy == "" || cfg.SignaturePolicy == SignaturePolicyOff {
+		return nil, nil
+	}
+
+	info := g.verifySignature(gitCmd, cfg, strategy, isPR)
+	if info.status == signatureStatusGood {
+		g.logger.Donef("%s", info)
+	} else if cfg.SignaturePolicy == SignaturePolicyRequire {
+		return nil, newStepError(
+			signatureVerificationFailedTag,
+			fmt.Errorf("signature verification failed: %s", info),
+			"Signature verification failed",
+		)
+	} else {
+		g.logger.Warnf("Signature verification failed: %s", info)
+	}
+
+	return &info, nil
+}
+
+// verifySignature verifies the signature of the build trigger commit, or the tag itself for tag builds.
+// For Pull Requests the Pull Request head is verified, as the merge commit is created by the git server or the Step.
+// GPG signatures are verified against the keys of SignatureKeyring (if set), SSH signatures against SignatureAllowedSigners.
+// The failures of the verification are reported in the status of the result, so the policy decides what happens.
+func (g GitCloner) verifySignature(gitCmd git.Git, cfg Config, strategy checkoutStrategy, isPR bool) signatureInfo {
+	g.logger.Println()
+	g.logger.Infof("Verifying signature")
+
+	target := strategy.getBuildTriggerRef()
+	if target == "" {
+		if isPR {
+			return signatureInfo{
+				target:  "Pull Request head",
+				status:  signatureStatusError,
+				details: "the Pull Request head commit is not available, only the Pull Request diff was applied",
+			}
+		}
+		target = "HEAD"
+	}
+
+	// The default keys of the machine are not known to the Step, these are only trusted if a failure doesn't fail the build
+	if cfg.SignaturePolicy == SignaturePolicyRequire && cfg.SignatureKeyring == "" && cfg.SignatureAllowedSigners == "" {
+		return signatureInfo{
+			target:  target,
+			status:  signatureStatusError,
+			details: "neither a GPG keyring nor an SSH allowed signers file is set, the signature can't be verified against the allowed signers",
+		}
+	}
+
+	verifyCmd := "verify-commit"
+	if _, isTag := strategy.(checkoutTag); isTag {
+		objectType, err := runner.RunForOutput(gitCommand(gitCmd, "cat-file", "-t", target))
+		if err != nil {
+			return signatureInfo{
+				target:  target,
+				status:  signatureStatusError,
+				details: fmt.Sprintf("failed to check the type of %s: %v", target, err),
+			}
+		}
+		if objectType == "tag" {
+			verifyCmd = "verify-tag"
+		} else {
+			// Lightweight tags have no signature, the tagged commit is verified instead
+			g.logger.Warnf("%s is a lightweight tag, verifying the signature of the tagged commit", target)
+		}
+	}
+
+	args := []string{verifyCmd, "--raw", target}
+	if cfg.SignatureAllowedSigners != "" {
+		args = append([]string{"-c", "gpg.ssh.allowedSignersFile=" + cfg.SignatureAllowedSigners}, args...)
+	}
+	cmd := gitCommand(gitCmd, args...)
+
+	if cfg.SignatureKeyring != "" {
+		gnupgHome, err := importGPGKeyring(cfg.SignatureKeyring)
+		if err != nil {
+			return signatureInfo{
+				target:  target,
+				status:  signatureStatusError,
+				details: fmt.Sprintf("failed to import GPG keyring (%s): %v", cfg.SignatureKeyring, err),
+			}
+		}
+		defer func() {
+			if err := os.RemoveAll(gnupgHome); err != nil {
+				g.logger.Warnf("Failed to remove temporary GPG home: %s", err)
+			}
+		}()
+		cmd.AppendEnvs("GNUPGHOME=" + gnupgHome)
+	}
+
+	out, err := runner.RunForOutput(cmd)
+	info := parseSignatureVerification(out, err != nil)
+	info.target = target
+
+	return info
+}
+
+// importGPGKeyring imports the public keys into a new GPG home, so only the given keys are accepted
+func importGPGKeyring(keyring string) (string, error) {
+	gnupgHome, err := os.MkdirTemp("", "git-clone-gnupg")
+	if err != nil {
+		return "", err
+	}
+
+	cmd := command.New("gpg", "--batch", "--import", keyring).AppendEnvs("GNUPGHOME=" + gnupgHome)
+	if _, err := runner.RunForOutput(cmd); err != nil {
+		if removeErr := os.RemoveAll(gnupgHome); removeErr != nil {
+			return "", fmt.Errorf("%v, failed to remove temporary GPG home: %v", err, removeErr)
+		}
+		return "", err
+	}
+
+	return gnupgHome, nil
+}
+
+// parseSignatureVerification parses the output of `git verify-commit --raw` and `git verify-tag --raw`.
+// GPG reports its machine readable status lines, for example:
+//
+//	[GNUPG:] GOODSIG 74F4DD440581A4FF Jane <jane@example.com>
+//	[GNUPG:] VALIDSIG 5B4E8F1C77283B5A12638AF974F4DD440581A4FF 2024-03-01 1709283600 0 4 0 22 8 00 5B4E8F1C77283B5A12638AF974F4DD440581A4FF
+//
+// SSH signatures are reported in a human readable format, for example:
+//
+//	Good "git" signature for jane@example.com with ED25519 key SHA256:le6qNnbLHGCs9Toytyv7pTALz3Ij9Q5L3zxdVH/YVmg
+//
+// Unsigned commits produce no output at all.
+func parseSignatureVerification(out string, failed bool) signatureInfo {
+	info := signatureInfo{status: signatureStatusError}
+	if strings.TrimSpace(out) == "" || strings.Contains(out, "no signature found") {
+		info.status = signatureStatusUnsigned
+		return info
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+
+		if match := sshGoodSignaturePattern.FindStringSubmatch(line); match != nil {
+			info.signer, info.fingerprint = match[1], match[2]
+			if info.signer != "" {
+				info.status = signatureStatusGood
+			} else {
+				info.status = signatureStatusUnknownKey
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(line, gpgStatusPrefix) {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, gpgStatusPrefix))
+		if len(fields) < 2 {
+			continue
+		}
+		keyword, keyID := fields[0], fields[1]
+		uid := strings.Join(fields[2:], " ")
+
+		switch keyword {
+		case "GOODSIG":
+			info.status, info.signer = signatureStatusGood, uid
+		case "BADSIG":
+			info.status, info.signer = signatureStatusBad, uid
+		case "EXPSIG", "EXPKEYSIG":
+			info.status, info.signer = signatureStatusExpired, uid
+		case "REVKEYSIG":
+			info.status, info.signer = signatureStatusRevoked, uid
+		case "ERRSIG":
+			info.status, info.fingerprint = signatureStatusUnknownKey, keyID
+			// Newer GPG versions report the fingerprint of the missing key as the last field
+			if len(fields) >= 8 {
+				info.fingerprint = fields[7]
+			}
+		case "VALIDSIG":
+			info.fingerprint = keyID
+		}
+	}
+
+	// A good looking signature is not accepted if git reported a failure (for example the key has insufficient trust)
+	if failed && info.status == signatureStatusGood {
+		info.status = signatureStatusError
+	}
+	if info.status != signatureStatusGood {
+		info.details = out
+	}
+
+	return info
+}
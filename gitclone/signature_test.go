@@ -0,0 +1,184 @@
+package gitclone
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/command/git"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSignatureVerification(t *testing.T) {
+	tests := []struct {
+		name            string
+		out             string
+		failed          bool
+		wantStatus      signatureStatus
+		wantSigner      string
+		wantFingerprint string
+	}{
+		{
+			name: "Good GPG signature",
+			out: `[GNUPG:] NEWSIG
+[GNUPG:] KEY_CONSIDERED 5B4E8F1C77283B5A12638AF974F4DD440581A4FF 0
+[GNUPG:] GOODSIG 74F4DD440581A4FF Jane <jane@example.com>
+[GNUPG:] VALIDSIG 5B4E8F1C77283B5A12638AF974F4DD440581A4FF 2024-03-01 1709283600 0 4 0 22 8 00 5B4E8F1C77283B5A12638AF974F4DD440581A4FF
+[GNUPG:] TRUST_UNDEFINED 0 pgp`,
+			wantStatus:      signatureStatusGood,
+			wantSigner:      "Jane <jane@example.com>",
+			wantFingerprint: "5B4E8F1C77283B5A12638AF974F4DD440581A4FF",
+		},
+		{
+			name: "GPG key not in the keyring",
+			out: `[GNUPG:] NEWSIG
+[GNUPG:] ERRSIG 74F4DD440581A4FF 22 8 00 1709283600 9 5B4E8F1C77283B5A12638AF974F4DD440581A4FF
+[GNUPG:] NO_PUBKEY 74F4DD440581A4FF`,
+			failed:          true,
+			wantStatus:      signatureStatusUnknownKey,
+			wantFingerprint: "5B4E8F1C77283B5A12638AF974F4DD440581A4FF",
+		},
+		{
+			name:       "Bad GPG signature",
+			out:        `[GNUPG:] BADSIG 74F4DD440581A4FF Jane <jane@example.com>`,
+			failed:     true,
+			wantStatus: signatureStatusBad,
+			wantSigner: "Jane <jane@example.com>",
+		},
+		{
+			name:       "GPG signature by a revoked key",
+			out:        `[GNUPG:] REVKEYSIG 74F4DD440581A4FF Jane <jane@example.com>`,
+			failed:     true,
+			wantStatus: signatureStatusRevoked,
+			wantSigner: "Jane <jane@example.com>",
+		},
+		{
+			name:            "Good SSH signature",
+			out:             `Good "git" signature for jane@example.com with ED25519 key SHA256:le6qNnbLHGCs9Toytyv7pTALz3Ij9Q5L3zxdVH/YVmg`,
+			wantStatus:      signatureStatusGood,
+			wantSigner:      "jane@example.com",
+			wantFingerprint: "SHA256:le6qNnbLHGCs9Toytyv7pTALz3Ij9Q5L3zxdVH/YVmg",
+		},
+		{
+			name: "SSH key not in the allowed signers",
+			out: `Good "git" signature with ED25519 key SHA256:le6qNnbLHGCs9Toytyv7pTALz3Ij9Q5L3zxdVH/YVmg
+No principal matched.`,
+			failed:          true,
+			wantStatus:      signatureStatusUnknownKey,
+			wantFingerprint: "SHA256:le6qNnbLHGCs9Toytyv7pTALz3Ij9Q5L3zxdVH/YVmg",
+		},
+		{
+			name:       "SSH allowed signers not configured",
+			out:        `error: gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification`,
+			failed:     true,
+			wantStatus: signatureStatusError,
+		},
+		{
+			name:       "Unsigned commit",
+			out:        "",
+			failed:     true,
+			wantStatus: signatureStatusUnsigned,
+		},
+		{
+			name:       "Unsigned tag",
+			out:        "error: no signature found",
+			failed:     true,
+			wantStatus: signatureStatusUnsigned,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseSignatureVerification(tt.out, tt.failed)
+
+			assert.Equal(t, tt.wantStatus, got.status)
+			assert.Equal(t, tt.wantSigner, got.signer)
+			assert.Equal(t, tt.wantFingerprint, got.fingerprint)
+		})
+	}
+}
+
+func Test_checkSignature(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		strategy   checkoutStrategy
+		isPR       bool
+		mockRunner *MockRunner
+		wantErr    bool
+		wantStatus signatureStatus
+		wantCmds   []string
+	}{
+		{
+			name:       "Verification is off",
+			cfg:        Config{SignaturePolicy: SignaturePolicyOff},
+			strategy:   checkoutTag{params: TagParams{Tag: "1.0.0"}},
+			mockRunner: givenMockRunner(),
+		},
+		{
+			name:       "Warn: only the Pull Request diff is applied",
+			cfg:        Config{SignaturePolicy: SignaturePolicyWarn, SignatureAllowedSigners: "allowed_signers"},
+			strategy:   checkoutPRDiffFile{},
+			isPR:       true,
+			mockRunner: givenMockRunner(),
+			wantStatus: signatureStatusError,
+		},
+		{
+			name:       "Warn: the type of the tag can't be checked",
+			cfg:        Config{SignaturePolicy: SignaturePolicyWarn, SignatureAllowedSigners: "allowed_signers"},
+			strategy:   checkoutTag{params: TagParams{Tag: "1.0.0"}},
+			mockRunner: new(MockRunner).GivenRunForOutputFailsForCommand(`git "cat-file" "-t" "refs/tags/1.0.0"`, 1),
+			wantStatus: signatureStatusError,
+			wantCmds:   []string{`git "cat-file" "-t" "refs/tags/1.0.0"`},
+		},
+		{
+			name:     "Warn: the GPG keyring can't be imported",
+			cfg:      Config{SignaturePolicy: SignaturePolicyWarn, SignatureKeyring: "/non/existing/keyring.gpg"},
+			strategy: checkoutTag{params: TagParams{Tag: "1.0.0"}},
+			mockRunner: new(MockRunner).
+				GivenRunForOutputReturnsForCommand(`git "cat-file" "-t" "refs/tags/1.0.0"`, "tag").
+				GivenRunForOutputFailsForCommand(`gpg "--batch" "--import" "/non/existing/keyring.gpg"`, 1),
+			wantStatus: signatureStatusError,
+			wantCmds:   []string{`git "cat-file" "-t" "refs/tags/1.0.0"`, `gpg "--batch" "--import" "/non/existing/keyring.gpg"`},
+		},
+		{
+			name:       "Require: only the Pull Request diff is applied",
+			cfg:        Config{SignaturePolicy: SignaturePolicyRequire, SignatureAllowedSigners: "allowed_signers"},
+			strategy:   checkoutPRDiffFile{},
+			isPR:       true,
+			mockRunner: givenMockRunner(),
+			wantErr:    true,
+		},
+		{
+			name:       "Require: neither a GPG keyring nor an SSH allowed signers file is set",
+			cfg:        Config{SignaturePolicy: SignaturePolicyRequire},
+			strategy:   checkoutTag{params: TagParams{Tag: "1.0.0"}},
+			mockRunner: givenMockRunner(),
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			runner = tt.mockRunner
+			cloner := GitCloner{logger: log.NewLogger()}
+
+			// When
+			info, err := cloner.checkSignature(git.Git{}, tt.cfg, tt.strategy, tt.isPR)
+
+			// Then
+			assert.Equal(t, tt.wantCmds, tt.mockRunner.Cmds())
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Nil(t, info)
+				return
+			}
+			require.NoError(t, err)
+			if tt.wantStatus == "" {
+				assert.Nil(t, info)
+				return
+			}
+			require.NotNil(t, info)
+			assert.Equal(t, tt.wantStatus, info.status)
+		})
+	}
+}
@@ -1,10 +1,12 @@
 package gitclone
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/bitrise-io/bitrise-init/errormapper"
 	"github.com/bitrise-io/go-steputils/step"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/repourl"
 )
 
 const (
@@ -20,6 +22,8 @@ func mapDetailedErrorRecommendation(tag, errMsg string) step.Recommendation {
 		matcher = newUpdateSubmoduleFailedErrorMatcher()
 	case fetchFailedTag:
 		matcher = newFetchFailedPatternErrorMatcher()
+	case commandTimedOutTag:
+		matcher = newCommandTimedOutPatternErrorMatcher()
 	}
 	if matcher != nil {
 		return matcher.Run(errMsg)
@@ -54,6 +58,17 @@ func newStepErrorWithBranchRecommendations(tag string, err error, shortMsg, curr
 	return newErr
 }
 
+// wrapCommandTimeoutError converts an error caused by a timed out git command into a step error,
+// unless it is already a step error with a more specific tag.
+func wrapCommandTimeoutError(err error, shortMsg string) error {
+	var stepErr *step.Error
+	if errors.As(err, &stepErr) || !isCommandTimeoutError(err) {
+		return err
+	}
+
+	return newStepError(commandTimedOutTag, err, shortMsg)
+}
+
 func newUpdateSubmoduleFailedErrorMatcher() *errormapper.PatternErrorMatcher {
 	return &errormapper.PatternErrorMatcher{
 		DefaultBuilder: newUpdateSubmoduleFailedGenericDetailedError,
@@ -124,6 +139,31 @@ func newFetchFailedPatternErrorMatcher() *errormapper.PatternErrorMatcher {
 	}
 }
 
+func newCommandTimedOutPatternErrorMatcher() *errormapper.PatternErrorMatcher {
+	return &errormapper.PatternErrorMatcher{
+		DefaultBuilder: newCommandTimedOutGenericDetailedError,
+		PatternToBuilder: errormapper.PatternToDetailedErrorBuilder{
+			`git (fetch|submodule update) did not finish in (\S+)`: newTransferTimedOutDetailedError,
+		},
+	}
+}
+
+func newCommandTimedOutGenericDetailedError(errorMsg string) errormapper.DetailedError {
+	return errormapper.DetailedError{
+		Title:       "A git command has timed out.",
+		Description: fmt.Sprintf("Please check if the repository is in a consistent state or increase the timeout of the failing phase in the Step inputs.\nOur auto-configurator returned the following error:\n%s", errorMsg),
+	}
+}
+
+func newTransferTimedOutDetailedError(errorMsg string, params ...string) errormapper.DetailedError {
+	phase := errormapper.GetParamAt(0, params)
+	timeout := errormapper.GetParamAt(1, params)
+	return errormapper.DetailedError{
+		Title:       fmt.Sprintf("Downloading from the git server has stalled (git %s did not finish in %s).", phase, timeout),
+		Description: "The transfer did not finish in time and was terminated. Please check the status of your git server and the network connection, or increase the timeout in the Step inputs. A stalled transfer is aborted and retried earlier if it is slower than the low speed limit set in the Step inputs.",
+	}
+}
+
 func newCheckoutFailedGenericDetailedError(errorMsg string) errormapper.DetailedError {
 	return errormapper.DetailedError{
 		Title:       "We couldn’t checkout your branch.",
@@ -146,7 +186,7 @@ func newFetchFailedSSHAccessErrorDetailedError(errorMsg string, params ...string
 }
 
 func newFetchFailedCouldNotFindGitRepoDetailedError(errorMsg string, params ...string) errormapper.DetailedError {
-	repoURL := errormapper.GetParamAt(0, params)
+	repoURL := repourl.Redact(errormapper.GetParamAt(0, params))
 	return errormapper.DetailedError{
 		Title:       fmt.Sprintf("We couldn’t find a git repository at '%s'.", repoURL),
 		Description: "Please abort the process, double-check your repository URL and try again.",
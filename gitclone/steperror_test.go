@@ -317,6 +317,28 @@ func Test_mapRecommendation(t *testing.T) {
 				Description: `Please abort the process, update your SSH settings and try again. You can find out more about <a target="_blank" href="https://docs.github.com/en/free-pro-team@latest/github/authenticating-to-github/authorizing-an-ssh-key-for-use-with-saml-single-sign-on">using SAML SSO in the Github docs</a>.`,
 			}),
 		},
+		{
+			name: "command_timed_out fetch stalled error mapping",
+			args: args{
+				tag:    commandTimedOutTag,
+				errMsg: "git fetch did not finish in 10m0s and was terminated",
+			},
+			want: errormapper.NewDetailedErrorRecommendation(errormapper.DetailedError{
+				Title:       "Downloading from the git server has stalled (git fetch did not finish in 10m0s).",
+				Description: "The transfer did not finish in time and was terminated. Please check the status of your git server and the network connection, or increase the timeout in the Step inputs. A stalled transfer is aborted and retried earlier if it is slower than the low speed limit set in the Step inputs.",
+			}),
+		},
+		{
+			name: "command_timed_out generic error mapping",
+			args: args{
+				tag:    commandTimedOutTag,
+				errMsg: "git merge did not finish in 30s and was terminated",
+			},
+			want: errormapper.NewDetailedErrorRecommendation(errormapper.DetailedError{
+				Title:       "A git command has timed out.",
+				Description: "Please check if the repository is in a consistent state or increase the timeout of the failing phase in the Step inputs.\nOur auto-configurator returned the following error:\ngit merge did not finish in 30s and was terminated",
+			}),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
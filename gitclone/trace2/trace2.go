@@ -0,0 +1,277 @@
+// Package trace2 summarizes the event stream written by git when GIT_TRACE2_EVENT is set.
+// Format reference: https://git-scm.com/docs/api-trace2#_event_format
+package trace2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Phase is a step of the clone process that is measured separately
+type Phase string
+
+const (
+	// Negotiation is the exchange of commits between the client and the server to find out what needs to be sent
+	Negotiation Phase = "negotiation"
+	// PackReceive is the download of the pack file
+	PackReceive Phase = "pack_receive"
+	// IndexPack is the delta resolution of the received pack file
+	IndexPack Phase = "index_pack"
+	// Checkout is the update of the index and the working tree
+	Checkout Phase = "checkout"
+	// Submodules is the whole submodule update, including the fetches and checkouts of the submodules
+	Submodules Phase = "submodules"
+)
+
+var phaseOrder = []Phase{Negotiation, PackReceive, IndexPack, Checkout, Submodules}
+
+// PhaseTiming is the time spent in a phase summed up across all git processes
+type PhaseTiming struct {
+	Phase    Phase
+	Duration time.Duration
+	// Count is the number of times the phase was entered (for example the number of fetches)
+	Count int
+	// Objects and Bytes are only reported when git displayed progress for the phase
+	Objects int64
+	Bytes   int64
+}
+
+// MarshalJSON ...
+func (t PhaseTiming) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Phase      Phase `json:"phase"`
+		DurationMS int64 `json:"duration_ms"`
+		Count      int   `json:"count"`
+		Objects    int64 `json:"objects,omitempty"`
+		Bytes      int64 `json:"bytes,omitempty"`
+	}{
+		Phase:      t.Phase,
+		DurationMS: t.Duration.Milliseconds(),
+		Count:      t.Count,
+		Objects:    t.Objects,
+		Bytes:      t.Bytes,
+	})
+}
+
+// Summary contains the timing of the phases that occurred, in the order of the clone process
+type Summary []PhaseTiming
+
+// Table returns the summary formatted as a plain text table
+func (s Summary) Table() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "Phase\tDuration\tCount\tObjects\tBytes")
+	for _, t := range s {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", t.Phase, t.Duration.Round(time.Millisecond), t.Count, optionalInt(t.Objects), optionalInt(t.Bytes))
+	}
+	_ = w.Flush()
+
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+func optionalInt(i int64) string {
+	if i == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(i, 10)
+}
+
+// SummarizeDir parses the event files git writes into dir (one file per process) when GIT_TRACE2_EVENT points to a directory
+func SummarizeDir(dir string) (Summary, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := map[Phase]*PhaseTiming{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if err := summarizeProcess(content, totals); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+	}
+
+	var summary Summary
+	for _, phase := range phaseOrder {
+		if t, ok := totals[phase]; ok {
+			summary = append(summary, *t)
+		}
+	}
+
+	return summary, nil
+}
+
+type event struct {
+	Event    string          `json:"event"`
+	Thread   string          `json:"thread"`
+	Category string          `json:"category"`
+	Label    string          `json:"label"`
+	Key      string          `json:"key"`
+	Value    json.RawMessage `json:"value"`
+	TRel     float64         `json:"t_rel"`
+	TAbs     float64         `json:"t_abs"`
+	Argv     []string        `json:"argv"`
+}
+
+type region struct {
+	phase   Phase
+	objects int64
+	bytes   int64
+}
+
+// summarizeProcess adds the phases of a single git process to the totals
+func summarizeProcess(content []byte, totals map[Phase]*PhaseTiming) error {
+	var argv []string
+	var processDuration time.Duration
+	packProgressSeen := false
+	regionsByThread := map[string][]*region{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A killed process can leave a truncated last line behind
+			continue
+		}
+
+		switch e.Event {
+		case "start":
+			argv = e.Argv
+		case "exit":
+			processDuration = seconds(e.TAbs)
+		case "region_enter":
+			regionsByThread[e.Thread] = append(regionsByThread[e.Thread], &region{phase: regionPhase(e.Category, e.Label)})
+		case "region_leave":
+			stack := regionsByThread[e.Thread]
+			if len(stack) == 0 {
+				continue
+			}
+			r := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			regionsByThread[e.Thread] = stack
+
+			// Nested regions of the same phase (such as recursive unpack_trees calls) are only counted once
+			if r.phase == "" || isPhaseOpen(stack, r.phase) {
+				continue
+			}
+			if r.phase == PackReceive {
+				packProgressSeen = true
+			}
+			add(totals, PhaseTiming{Phase: r.phase, Duration: seconds(e.TRel), Count: 1, Objects: r.objects, Bytes: r.bytes})
+		case "data":
+			stack := regionsByThread[e.Thread]
+			if len(stack) == 0 {
+				continue
+			}
+			r := stack[len(stack)-1]
+			switch e.Key {
+			case "total_objects":
+				r.objects = parseValue(e.Value)
+			case "total_bytes":
+				r.bytes = parseValue(e.Value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	switch processPhase(argv) {
+	case PackReceive:
+		// Git only reports the progress regions of the pack transfer if progress output is enabled,
+		// otherwise the lifetime of the process receiving the pack is the best estimate.
+		if !packProgressSeen {
+			add(totals, PhaseTiming{Phase: PackReceive, Duration: processDuration, Count: 1})
+		}
+	case Submodules:
+		add(totals, PhaseTiming{Phase: Submodules, Duration: processDuration, Count: 1})
+	}
+
+	return nil
+}
+
+func regionPhase(category, label string) Phase {
+	switch {
+	case category == "fetch-pack" && strings.HasPrefix(label, "negotiation"):
+		return Negotiation
+	case category == "progress" && (label == "Receiving objects" || label == "Unpacking objects"):
+		return PackReceive
+	case category == "progress" && label == "Resolving deltas":
+		return IndexPack
+	case category == "unpack_trees" && label == "unpack_trees":
+		return Checkout
+	}
+	return ""
+}
+
+func processPhase(argv []string) Phase {
+	if len(argv) < 2 {
+		return ""
+	}
+
+	switch argv[1] {
+	case "index-pack", "unpack-objects":
+		return PackReceive
+	case "submodule":
+		if len(argv) > 2 && argv[2] == "update" {
+			return Submodules
+		}
+	}
+	return ""
+}
+
+func isPhaseOpen(stack []*region, phase Phase) bool {
+	for _, r := range stack {
+		if r.phase == phase {
+			return true
+		}
+	}
+	return false
+}
+
+func add(totals map[Phase]*PhaseTiming, t PhaseTiming) {
+	total, ok := totals[t.Phase]
+	if !ok {
+		total = &PhaseTiming{Phase: t.Phase}
+		totals[t.Phase] = total
+	}
+
+	total.Duration += t.Duration
+	total.Count += t.Count
+	total.Objects += t.Objects
+	total.Bytes += t.Bytes
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// parseValue reads the value of a data event, which git writes as a JSON string
+func parseValue(raw json.RawMessage) int64 {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		s = string(raw)
+	}
+
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return i
+}
@@ -0,0 +1,99 @@
+package trace2
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const fetchEvents = `{"event":"start","sid":"a","thread":"main","t_abs":0.0004,"argv":["git","fetch","--jobs=10","origin","master"]}
+{"event":"region_enter","sid":"a","thread":"main","nesting":1,"category":"fetch","label":"fetch_refs"}
+{"event":"region_enter","sid":"a","thread":"main","nesting":2,"category":"fetch-pack","label":"negotiation_v2"}
+{"event":"region_leave","sid":"a","thread":"main","t_rel":0.250,"nesting":2,"category":"fetch-pack","label":"negotiation_v2"}
+{"event":"region_leave","sid":"a","thread":"main","t_rel":2.5,"nesting":1,"category":"fetch","label":"fetch_refs"}
+{"event":"exit","sid":"a","thread":"main","t_abs":3.0,"code":0}
+`
+
+const indexPackEvents = `{"event":"start","sid":"a/b","thread":"main","t_abs":0.0004,"argv":["/usr/lib/git-core/git","index-pack","--stdin","--fix-thin"]}
+{"event":"region_enter","sid":"a/b","thread":"main","nesting":1,"category":"progress","label":"Receiving objects"}
+{"event":"data","sid":"a/b","thread":"main","t_abs":1.5,"t_rel":1.5,"nesting":2,"category":"progress","key":"total_objects","value":"1200"}
+{"event":"data","sid":"a/b","thread":"main","t_abs":1.5,"t_rel":1.5,"nesting":2,"category":"progress","key":"total_bytes","value":"52428800"}
+{"event":"region_leave","sid":"a/b","thread":"main","t_rel":1.5,"nesting":1,"category":"progress","label":"Receiving objects"}
+{"event":"region_enter","sid":"a/b","thread":"main","nesting":1,"category":"progress","label":"Resolving deltas"}
+{"event":"data","sid":"a/b","thread":"main","t_abs":2.0,"t_rel":0.5,"nesting":2,"category":"progress","key":"total_objects","value":"800"}
+{"event":"region_leave","sid":"a/b","thread":"main","t_rel":0.5,"nesting":1,"category":"progress","label":"Resolving deltas"}
+{"event":"exit","sid":"a/b","thread":"main","t_abs":2.1,"code":0}
+`
+
+const unpackObjectsEvents = `{"event":"start","sid":"c/d","thread":"main","t_abs":0.0004,"argv":["/usr/lib/git-core/git","unpack-objects","--pack_header=2,9"]}
+{"event":"exit","sid":"c/d","thread":"main","t_abs":0.2,"code":0}
+`
+
+const checkoutEvents = `{"event":"start","sid":"e","thread":"main","t_abs":0.0006,"argv":["git","checkout","76a934a"]}
+{"event":"region_enter","sid":"e","thread":"main","nesting":1,"category":"unpack_trees","label":"unpack_trees"}
+{"event":"region_enter","sid":"e","thread":"main","nesting":2,"category":"unpack_trees","label":"unpack_trees"}
+{"event":"region_leave","sid":"e","thread":"main","t_rel":0.1,"nesting":2,"category":"unpack_trees","label":"unpack_trees"}
+{"event":"region_leave","sid":"e","thread":"main","t_rel":0.4,"nesting":1,"category":"unpack_trees","label":"unpack_trees"}
+{"event":"exit","sid":"e","thread":"main","t_abs":0.5,"code":0}
+`
+
+const submoduleEvents = `{"event":"start","sid":"f","thread":"main","t_abs":0.0005,"argv":["git","submodule","update","--init","--recursive"]}
+{"event":"exit","sid":"f","thread":"main","t_abs":4.0,"code":0}
+{"event":"region_enter","sid":"f","thread":"main","nesting":1,"category":"unpack_trees","lab`
+
+func TestSummarizeDir(t *testing.T) {
+	dir := t.TempDir()
+	writeEvents(t, dir, "fetch", fetchEvents)
+	writeEvents(t, dir, "index-pack", indexPackEvents)
+	writeEvents(t, dir, "unpack-objects", unpackObjectsEvents)
+	writeEvents(t, dir, "checkout", checkoutEvents)
+	writeEvents(t, dir, "submodule", submoduleEvents)
+
+	summary, err := SummarizeDir(dir)
+	require.NoError(t, err)
+
+	require.Equal(t, Summary{
+		{Phase: Negotiation, Duration: 250 * time.Millisecond, Count: 1},
+		{Phase: PackReceive, Duration: 1700 * time.Millisecond, Count: 2, Objects: 1200, Bytes: 52428800},
+		{Phase: IndexPack, Duration: 500 * time.Millisecond, Count: 1, Objects: 800},
+		{Phase: Checkout, Duration: 400 * time.Millisecond, Count: 1},
+		{Phase: Submodules, Duration: 4 * time.Second, Count: 1},
+	}, summary)
+}
+
+func TestSummary_Table(t *testing.T) {
+	summary := Summary{
+		{Phase: Negotiation, Duration: 250 * time.Millisecond, Count: 1},
+		{Phase: PackReceive, Duration: 1500 * time.Millisecond, Count: 1, Objects: 1200, Bytes: 52428800},
+	}
+
+	want := strings.Join([]string{
+		"Phase         Duration  Count  Objects  Bytes",
+		"negotiation   250ms     1      -        -",
+		"pack_receive  1.5s      1      1200     52428800",
+	}, "\n")
+	require.Equal(t, want, summary.Table())
+}
+
+func TestSummary_MarshalJSON(t *testing.T) {
+	summary := Summary{
+		{Phase: Negotiation, Duration: 250 * time.Millisecond, Count: 1},
+		{Phase: PackReceive, Duration: 1500 * time.Millisecond, Count: 1, Objects: 1200, Bytes: 52428800},
+	}
+
+	got, err := json.Marshal(summary)
+	require.NoError(t, err)
+	require.JSONEq(t, `[
+		{"phase":"negotiation","duration_ms":250,"count":1},
+		{"phase":"pack_receive","duration_ms":1500,"count":1,"objects":1200,"bytes":52428800}
+	]`, string(got))
+}
+
+func writeEvents(t *testing.T, dir, name, content string) {
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+}
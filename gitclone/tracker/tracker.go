@@ -1,12 +1,13 @@
 package tracker
 
 import (
-	"strings"
 	"time"
 
 	"github.com/bitrise-io/go-utils/v2/analytics"
 	"github.com/bitrise-io/go-utils/v2/env"
 	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/repourl"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/trace2"
 )
 
 type StepTracker struct {
@@ -26,23 +27,39 @@ func NewStepTracker(envRepo env.Repository, logger log.Logger) StepTracker {
 }
 
 func (t *StepTracker) LogCheckout(duration time.Duration, method string, remoteURL string) {
-	var remoteType = "other"
-	if strings.Contains(remoteURL, "github.com") {
-		remoteType = "github.com"
-	} else if strings.Contains(remoteURL, "gitlab.com") {
-		remoteType = "gitlab.com"
-	} else if strings.Contains(remoteURL, "bitbucket.org") {
-		remoteType = "bitbucket.org"
-	}
-
 	p := analytics.Properties{
 		"duration_s":  duration.Truncate(time.Second).Seconds(),
 		"method":      method,
-		"remote_type": remoteType,
+		"remote_type": remoteType(remoteURL),
 	}
 	t.tracker.Enqueue("step_git_clone_fetch_and_checkout", p)
 }
 
+// remoteType returns the hosting provider of the repository, matched by the hostname (not anywhere in the URL)
+func remoteType(remoteURL string) string {
+	repo, err := repourl.Parse(remoteURL)
+	if err != nil {
+		return "other"
+	}
+
+	switch repo.Host {
+	case "github.com", "gitlab.com", "bitbucket.org":
+		return repo.Host
+	default:
+		return "other"
+	}
+}
+
+func (t *StepTracker) LogFetch(duration time.Duration, objects, bytes, throughput int64) {
+	p := analytics.Properties{
+		"duration_ms":        duration.Milliseconds(),
+		"object_count":       objects,
+		"bytes":              bytes,
+		"throughput_bytes_s": throughput,
+	}
+	t.tracker.Enqueue("step_git_clone_fetch_completed", p)
+}
+
 func (t *StepTracker) LogSubmoduleUpdate(duration time.Duration) {
 	p := analytics.Properties{
 		"duration_s": duration.Truncate(time.Second).Seconds(),
@@ -59,6 +76,21 @@ func (t *StepTracker) LogMergeRefVerify(duration time.Duration, success bool, at
 	t.tracker.Enqueue("step_git_clone_merge_ref_verified", p)
 }
 
+func (t *StepTracker) LogPhaseTimings(phases trace2.Summary) {
+	p := analytics.Properties{}
+	for _, phase := range phases {
+		p[string(phase.Phase)+"_duration_ms"] = phase.Duration.Milliseconds()
+		p[string(phase.Phase)+"_count"] = phase.Count
+		if phase.Objects > 0 {
+			p[string(phase.Phase)+"_objects"] = phase.Objects
+		}
+		if phase.Bytes > 0 {
+			p[string(phase.Phase)+"_bytes"] = phase.Bytes
+		}
+	}
+	t.tracker.Enqueue("step_git_clone_phase_timings", p)
+}
+
 func (t *StepTracker) Wait() {
 	t.tracker.Wait()
 }
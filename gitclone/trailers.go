@@ -0,0 +1,126 @@
+package gitclone
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// The trailers (only the trailer block, continuation lines unfolded) and the raw message of each commit
+const commitMessageLogFormat = "%(trailers:only,unfold)%x1f%B%x1e"
+
+// commitMessageScan configures which details are extracted from the commit messages
+type commitMessageScan struct {
+	// trailerKeys are the requested trailer keys, for example Signed-off-by
+	trailerKeys []string
+	// issueKeyPattern matches the issue keys in the branch name and the commit messages
+	issueKeyPattern *regexp.Regexp
+	// scanCommitRange extends the scan from the build trigger commit to the commits of the commit range
+	scanCommitRange bool
+	// commitRange is the commit range to scan, nil if it is not available
+	commitRange *commitRange
+	branch      string
+}
+
+func (s commitMessageScan) enabled() bool {
+	return len(s.trailerKeys) > 0 || s.issueKeyPattern != nil
+}
+
+type commitMessage struct {
+	trailers string
+	message  string
+}
+
+// parseCommitMessageLog parses the output of `git log --format=<commitMessageLogFormat>`
+func parseCommitMessageLog(out string) []commitMessage {
+	var messages []commitMessage
+	for _, record := range strings.Split(out, commitRecordSeparator) {
+		record = strings.TrimLeft(record, "\n")
+		if record == "" {
+			continue
+		}
+
+		trailers, message, _ := strings.Cut(record, commitFieldSeparator)
+		messages = append(messages, commitMessage{trailers: trailers, message: message})
+	}
+	return messages
+}
+
+// collectTrailers returns the unique values of the requested trailers by the requested keys.
+// Trailer keys are matched case-insensitively, as git does.
+func collectTrailers(messages []commitMessage, keys []string) map[string][]string {
+	trailers := map[string][]string{}
+	for _, key := range keys {
+		trailers[key] = []string{}
+	}
+
+	for _, msg := range messages {
+		for _, line := range strings.Split(msg.trailers, "\n") {
+			token, value, found := strings.Cut(line, ":")
+			if !found {
+				continue
+			}
+			token, value = strings.TrimSpace(token), strings.TrimSpace(value)
+
+			for _, key := range keys {
+				if strings.EqualFold(token, key) && !slices.Contains(trailers[key], value) {
+					trailers[key] = append(trailers[key], value)
+				}
+			}
+		}
+	}
+
+	return trailers
+}
+
+// collectIssueKeys returns the unique issue keys of the branch name and the commit messages, in order of appearance
+func collectIssueKeys(pattern *regexp.Regexp, branch string, messages []commitMessage) []string {
+	texts := []string{branch}
+	for _, msg := range messages {
+		texts = append(texts, msg.message)
+	}
+
+	keys := []string{}
+	for _, text := range texts {
+		for _, key := range pattern.FindAllString(text, -1) {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// ParseCommitTrailers parses the trailer keys, one per line.
+// The keys are matched case-insensitively, so the keys which differ only in case (or map to the same output) are rejected.
+func ParseCommitTrailers(lines []string) ([]string, error) {
+	var keys []string
+	keysByOutput := map[string]string{}
+	for _, line := range lines {
+		key := strings.TrimSpace(line)
+		if key == "" {
+			continue
+		}
+
+		outputKey := trailerOutputKey(key)
+		if other, ok := keysByOutput[outputKey]; ok {
+			return nil, fmt.Errorf("invalid trailer key (%s): %s is already listed, both would be exported as %s", key, other, outputKey)
+		}
+		keysByOutput[outputKey] = key
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// trailerOutputKey returns the output name of the trailer, for example GIT_CLONE_TRAILER_SIGNED_OFF_BY for Signed-off-by
+func trailerOutputKey(trailerKey string) string {
+	return outputTrailerPrefix + strings.Map(func(r rune) rune {
+		if r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, trailerKey)
+}
@@ -0,0 +1,68 @@
+package gitclone
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseCommitMessageLog(t *testing.T) {
+	out := "co-authored-by: Bob <bob@example.com>\n\x1fsecond ABC-7\n\nco-authored-by: Bob <bob@example.com>\n\x1e\n" +
+		"Signed-off-by: a continued\nChange-Id: I123\n\x1fPROJ-12 Fix crash\n\nSigned-off-by: a\n continued\nChange-Id: I123\n\x1e\n"
+
+	assert.Equal(t, []commitMessage{
+		{
+			trailers: "co-authored-by: Bob <bob@example.com>\n",
+			message:  "second ABC-7\n\nco-authored-by: Bob <bob@example.com>\n",
+		},
+		{
+			trailers: "Signed-off-by: a continued\nChange-Id: I123\n",
+			message:  "PROJ-12 Fix crash\n\nSigned-off-by: a\n continued\nChange-Id: I123\n",
+		},
+	}, parseCommitMessageLog(out))
+}
+
+func Test_collectTrailers(t *testing.T) {
+	messages := []commitMessage{
+		{trailers: "Co-authored-by: Jane <jane@example.com>\nSigned-off-by: John <john@example.com>\nChange-Id: I123\n"},
+		{trailers: "co-authored-by: Bob <bob@example.com>\nCo-authored-by: Jane <jane@example.com>\n"},
+		{trailers: ""},
+	}
+
+	got := collectTrailers(messages, []string{"Co-authored-by", "Change-Id", "Reviewed-by"})
+
+	assert.Equal(t, map[string][]string{
+		"Co-authored-by": {"Jane <jane@example.com>", "Bob <bob@example.com>"},
+		"Change-Id":      {"I123"},
+		"Reviewed-by":    {},
+	}, got)
+}
+
+func Test_collectIssueKeys(t *testing.T) {
+	pattern := regexp.MustCompile(`[A-Z][A-Z0-9]+-[0-9]+`)
+	messages := []commitMessage{
+		{message: "PROJ-12 Fix crash\n\nAlso fixes ABC-7 and PROJ-12."},
+		{message: "Refactor"},
+	}
+
+	assert.Equal(t, []string{"APP-1", "PROJ-12", "ABC-7"}, collectIssueKeys(pattern, "feature/APP-1-login", messages))
+	assert.Equal(t, []string{}, collectIssueKeys(pattern, "main", nil))
+}
+
+func Test_ParseCommitTrailers(t *testing.T) {
+	keys, err := ParseCommitTrailers([]string{" Signed-off-by ", "", "Change-Id"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Signed-off-by", "Change-Id"}, keys)
+
+	_, err = ParseCommitTrailers([]string{"Signed-off-by", "signed-off-by"})
+	assert.ErrorContains(t, err, "Signed-off-by is already listed")
+
+	_, err = ParseCommitTrailers([]string{"Change-Id", "Change_Id"})
+	assert.ErrorContains(t, err, "both would be exported as GIT_CLONE_TRAILER_CHANGE_ID")
+}
+
+func Test_trailerOutputKey(t *testing.T) {
+	assert.Equal(t, "GIT_CLONE_TRAILER_CO_AUTHORED_BY", trailerOutputKey("Co-authored-by"))
+	assert.Equal(t, "GIT_CLONE_TRAILER_CHANGE_ID", trailerOutputKey("Change-Id"))
+}
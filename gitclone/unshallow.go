@@ -17,43 +17,180 @@ type unshallowFetchOptions struct {
 	// Sets '--no-recurse-submodules' flag
 	// More info: https://git-scm.com/docs/git-fetch#Documentation/git-fetch.txt---no-recurse-submodules
 	fetchSubmodules bool
+	// Sets '--force' flag, so the refs moved on the remote since a previous build (in a reused clone directory)
+	// are updated instead of being rejected
+	forceUpdate bool
+	// limit replaces the '--unshallow' flag if set, so the history is only fetched as far back as the configured limit
+	limit historyLimit
 }
 
+// fallbackRetry makes a failed checkout or merge possible (for example by fetching more history), then retries it
 type fallbackRetry interface {
-	do(gitCmd git.Git) error
+	do(gitCmd git.Git, retry func() error) error
+}
+
+// deepenOptions configure the incremental deepening tried before unshallowing the repository
+type deepenOptions struct {
+	gitDir string
+	// steps are the number of commits the shallow history is deepened by in each round, no incremental deepening if empty
+	steps []int
 }
 
 type simpleUnshallow struct {
 	traits unshallowFetchOptions
 }
 
-func (s simpleUnshallow) do(gitCmd git.Git) error {
-	log.Infof("Fetch with unshallow...")
+func (s simpleUnshallow) do(gitCmd git.Git, retry func() error) error {
+	if s.traits.limit.isSet() {
+		log.Infof("Fetch the history within the configured limit...")
+	} else {
+		log.Infof("Fetch with unshallow...")
+	}
 
-	return unshallowFetch(gitCmd, s.traits)
+	if err := unshallowFetch(gitCmd, s.traits); err != nil {
+		return err
+	}
+
+	return retry()
 }
 
 type resetUnshallow struct {
 	traits unshallowFetchOptions
 }
 
-func (r resetUnshallow) do(gitCmd git.Git) error {
-	log.Infof("Resetting repository, then fetch with unshallow...")
+func (r resetUnshallow) do(gitCmd git.Git, retry func() error) error {
+	if r.traits.limit.isSet() {
+		log.Infof("Resetting repository, then fetch the history within the configured limit...")
+	} else {
+		log.Infof("Resetting repository, then fetch with unshallow...")
+	}
 
 	if err := resetRepo(gitCmd); err != nil {
 		return fmt.Errorf("reset repository: %v", err)
 	}
 
-	return unshallowFetch(gitCmd, r.traits)
+	if err := unshallowFetch(gitCmd, r.traits); err != nil {
+		return err
+	}
+
+	return retry()
+}
+
+// incrementalDeepen deepens the shallow history by a growing number of commits and retries after each step,
+// so a commit close to the fetched history doesn't require downloading the full history of the repository.
+// The repository is only unshallowed if the retry still fails after the last step.
+type incrementalDeepen struct {
+	traits  unshallowFetchOptions
+	options deepenOptions
+	// reset cleans up the leftovers of the failed command (for example a conflicting merge) before each retry
+	reset bool
+	// targets are the refs fetched by the checkout strategy, their history is deepened (set by withDeepenTargets)
+	targets []fetchTarget
+}
+
+// fetchTarget is a ref (or a commit) fetched from a remote
+type fetchTarget struct {
+	remote string
+	ref    string
+}
+
+// withDeepenTargets returns the fallback deepening the history of the given refs, if the fallback deepens the history at all.
+// The refs are deepened instead of the shallow boundary commits, as fetching a commit by its hash is not allowed by every server
+// (see uploadpack.allowReachableSHA1InWant).
+func withDeepenTargets(fallback fallbackRetry, targets ...fetchTarget) fallbackRetry {
+	deepen, ok := fallback.(incrementalDeepen)
+	if !ok {
+		return fallback
+	}
+	deepen.targets = targets
+	return deepen
+}
+
+func (d incrementalDeepen) do(gitCmd git.Git, retry func() error) error {
+	deepened := 0
+	for _, step := range d.options.steps {
+		if len(d.targets) == 0 || !isShallowRepository(d.options.gitDir) {
+			break
+		}
+
+		log.Infof("Deepening the history by %d commits...", step)
+		if err := d.deepen(gitCmd, step); err != nil {
+			log.Warnf("Deepening the history failed: %v", err)
+			break
+		}
+		deepened += step
+
+		if d.reset {
+			if err := resetRepo(gitCmd); err != nil {
+				return fmt.Errorf("reset repository: %v", err)
+			}
+		}
+
+		err := retry()
+		if err == nil {
+			log.Donef("Succeeded after deepening the history by %d commits", deepened)
+			return nil
+		}
+		log.Warnf("Still failing after deepening the history by %d commits: %v", deepened, err)
+	}
+
+	log.Warnf("Deepening the history by %d commits was not enough, falling back to unshallow", deepened)
+	if d.reset {
+		return resetUnshallow{traits: d.traits}.do(gitCmd, retry)
+	}
+	return simpleUnshallow{traits: d.traits}.do(gitCmd, retry)
+}
+
+func (d incrementalDeepen) deepen(gitCmd git.Git, depth int) error {
+	for _, target := range d.targets {
+		if err := deepenFetch(gitCmd, target.remote, depth, []string{target.ref}, d.traits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deepenFetch deepens the history of the given refs of the remote by the given number of commits.
+// The refs are the ones fetched by the checkout (see withDeepenTargets), so no other branch is fetched.
+func deepenFetch(gitCmd git.Git, remote string, depth int, refs []string, traits unshallowFetchOptions) error {
+	opts := []string{jobsFlag, fmt.Sprintf("--deepen=%d", depth)}
+	if traits.tags {
+		opts = append(opts, "--tags")
+	} else {
+		opts = append(opts, "--no-tags")
+	}
+	if traits.forceUpdate {
+		opts = append(opts, "--force")
+	}
+	if !traits.fetchSubmodules {
+		opts = append(opts, "--no-recurse-submodules")
+	}
+	opts = append(opts, remote)
+	opts = append(opts, refs...)
+
+	if err := runner.RunWithRetry(func() *command.Model {
+		return gitCmd.Fetch(opts...)
+	}); err != nil {
+		return fmt.Errorf("fetch failed: %w", err)
+	}
+	return nil
 }
 
 func unshallowFetch(gitCmd git.Git, traits unshallowFetchOptions) error {
-	opts := []string{jobsFlag, "--unshallow"}
+	opts := []string{jobsFlag}
+	if traits.limit.isSet() {
+		opts = append(opts, traits.limit.fetchFlags()...)
+	} else {
+		opts = append(opts, "--unshallow")
+	}
 	if traits.tags {
 		opts = append(opts, "--tags")
 	} else {
 		opts = append(opts, "--no-tags")
 	}
+	if traits.forceUpdate {
+		opts = append(opts, "--force")
+	}
 	if !traits.fetchSubmodules {
 		opts = append(opts, "--no-recurse-submodules")
 	}
@@ -61,7 +198,7 @@ func unshallowFetch(gitCmd git.Git, traits unshallowFetchOptions) error {
 	if err := runner.RunWithRetry(func() *command.Model {
 		return gitCmd.Fetch(opts...)
 	}); err != nil {
-		return fmt.Errorf("fetch failed: %v", err)
+		return fmt.Errorf("fetch failed: %w", err)
 	}
 	return nil
 }
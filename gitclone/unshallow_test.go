@@ -0,0 +1,143 @@
+package gitclone
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/command/git"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_incrementalDeepen(t *testing.T) {
+	tests := []struct {
+		name           string
+		shallow        string
+		reset          bool
+		traits         unshallowFetchOptions
+		targets        []fetchTarget
+		failingCmd     string
+		retryFailures  int
+		wantRetryCalls int
+		wantCmds       []string
+	}{
+		{
+			name:           "Succeeds after the second step",
+			shallow:        "aaa\nbbb\n",
+			targets:        []fetchTarget{{remote: "origin", ref: "refs/heads/master"}},
+			retryFailures:  1,
+			wantRetryCalls: 2,
+			wantCmds: []string{
+				`git "fetch" "--jobs=10" "--deepen=50" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/master"`,
+				`git "fetch" "--jobs=10" "--deepen=200" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/master"`,
+			},
+		},
+		{
+			name:           "Unshallows after the last step",
+			shallow:        "aaa\n",
+			targets:        []fetchTarget{{remote: "origin", ref: "refs/heads/master"}, {remote: "fork", ref: "refs/heads/feature"}},
+			retryFailures:  2,
+			wantRetryCalls: 3,
+			wantCmds: []string{
+				`git "fetch" "--jobs=10" "--deepen=50" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/master"`,
+				`git "fetch" "--jobs=10" "--deepen=50" "--no-tags" "--no-recurse-submodules" "fork" "refs/heads/feature"`,
+				`git "fetch" "--jobs=10" "--deepen=200" "--no-tags" "--no-recurse-submodules" "origin" "refs/heads/master"`,
+				`git "fetch" "--jobs=10" "--deepen=200" "--no-tags" "--no-recurse-submodules" "fork" "refs/heads/feature"`,
+				`git "fetch" "--jobs=10" "--unshallow" "--no-tags" "--no-recurse-submodules"`,
+			},
+		},
+		{
+			name:           "Resets before each retry",
+			shallow:        "aaa\n",
+			reset:          true,
+			targets:        []fetchTarget{{remote: "origin", ref: "refs/tags/1.0.0"}},
+			wantRetryCalls: 1,
+			wantCmds: []string{
+				`git "fetch" "--jobs=10" "--deepen=50" "--no-tags" "--no-recurse-submodules" "origin" "refs/tags/1.0.0"`,
+				`git "reset" "--hard" "HEAD"`,
+				`git "clean" "-x" "-d" "-f"`,
+				`git "submodule" "foreach" "git" "reset" "--hard" "HEAD"`,
+				`git "submodule" "foreach" "git" "clean" "-x" "-d" "-f"`,
+			},
+		},
+		{
+			name:           "Unshallows if the server rejects the deepening",
+			shallow:        "aaa\n",
+			targets:        []fetchTarget{{remote: "origin", ref: "76a934ae"}},
+			failingCmd:     `git "fetch" "--jobs=10" "--deepen=50" "--no-tags" "--no-recurse-submodules" "origin" "76a934ae"`,
+			wantRetryCalls: 1,
+			wantCmds: []string{
+				`git "fetch" "--jobs=10" "--deepen=50" "--no-tags" "--no-recurse-submodules" "origin" "76a934ae"`,
+				`git "fetch" "--jobs=10" "--unshallow" "--no-tags" "--no-recurse-submodules"`,
+			},
+		},
+		{
+			name:           "Forces the update of the refs in a reused clone directory",
+			shallow:        "aaa\n",
+			traits:         unshallowFetchOptions{tags: true, forceUpdate: true},
+			targets:        []fetchTarget{{remote: "origin", ref: "refs/tags/1.0.0"}},
+			retryFailures:  1,
+			wantRetryCalls: 2,
+			wantCmds: []string{
+				`git "fetch" "--jobs=10" "--deepen=50" "--tags" "--force" "--no-recurse-submodules" "origin" "refs/tags/1.0.0"`,
+				`git "fetch" "--jobs=10" "--deepen=200" "--tags" "--force" "--no-recurse-submodules" "origin" "refs/tags/1.0.0"`,
+			},
+		},
+		{
+			name:           "Unshallows without deepen targets",
+			shallow:        "aaa\n",
+			wantRetryCalls: 1,
+			wantCmds: []string{
+				`git "fetch" "--jobs=10" "--unshallow" "--no-tags" "--no-recurse-submodules"`,
+			},
+		},
+		{
+			name:           "Not shallow",
+			targets:        []fetchTarget{{remote: "origin", ref: "refs/heads/master"}},
+			wantRetryCalls: 1,
+			wantCmds: []string{
+				`git "fetch" "--jobs=10" "--unshallow" "--no-tags" "--no-recurse-submodules"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			gitDir := t.TempDir()
+			if tt.shallow != "" {
+				assert.NoError(t, os.WriteFile(filepath.Join(gitDir, "shallow"), []byte(tt.shallow), 0600))
+			}
+
+			mockRunner := givenMockRunner()
+			if tt.failingCmd != "" {
+				mockRunner.GivenRunWithRetryFailsForCommand(tt.failingCmd)
+			}
+			mockRunner.GivenRunWithRetrySucceeds().GivenRunSucceeds()
+			runner = mockRunner
+
+			retryCalls := 0
+			retry := func() error {
+				retryCalls++
+				if retryCalls <= tt.retryFailures {
+					return errors.New("fatal: reference is not a tree")
+				}
+				return nil
+			}
+
+			fallback := withDeepenTargets(incrementalDeepen{
+				traits:  tt.traits,
+				options: deepenOptions{gitDir: gitDir, steps: []int{50, 200}},
+				reset:   tt.reset,
+			}, tt.targets...)
+
+			// When
+			err := fallback.do(git.Git{}, retry)
+
+			// Then
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantRetryCalls, retryCalls)
+			assert.Equal(t, tt.wantCmds, mockRunner.Cmds())
+		})
+	}
+}
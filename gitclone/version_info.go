@@ -0,0 +1,274 @@
+package gitclone
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/command/git"
+)
+
+const (
+	refsTagsPrefix  = "refs/tags/"
+	peeledTagSuffix = "^{}"
+	// Only the tags of the most recent tagged commits are fetched, older tags can't be the nearest one
+	maxFetchedTaggedCommits = 20
+)
+
+// Examples: 1.2.3, v1.2.3, release-1.2.3-beta.1+42
+var semverPattern = regexp.MustCompile(`(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+([0-9A-Za-z.-]+))?$`)
+
+// Example: v1.2.3-5-g76a934a
+var describePattern = regexp.MustCompile(`^(.+)-(\d+)-g([0-9a-f]+)$`)
+
+// versionInfo holds the tag based version details of the checked out commit
+type versionInfo struct {
+	headTags []string
+	// nearestTag is the closest tag reachable from HEAD matching the tag pattern, distance is the number of commits since it
+	nearestTag string
+	distance   int
+	describe   string
+	version    *semanticVersion
+	// buildNumber is the number of commits in the history of HEAD
+	buildNumber int
+	// approximate is set if some values could not be computed from the complete history
+	approximate bool
+}
+
+type semanticVersion struct {
+	major, minor, patch int
+	prerelease, build   string
+}
+
+func (v semanticVersion) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+	if v.prerelease != "" {
+		s += "-" + v.prerelease
+	}
+	if v.build != "" {
+		s += "+" + v.build
+	}
+	return s
+}
+
+// collectVersionInfo fetches the tags (and as much history as needed) to describe the checked out commit.
+// Failures are only reported as warnings, the checkout itself has already succeeded at this point.
+// The history is deepened through the given fetched refs (see checkoutStrategy.fetchTargets).
+func (g GitCloner) collectVersionInfo(gitCmd git.Git, cfg Config, targets []fetchTarget) *versionInfo {
+	g.logger.Println()
+	g.logger.Infof("Collecting version information")
+
+	tagPattern := cfg.VersionTagPattern
+	if tagPattern == "" {
+		tagPattern = "*"
+	}
+	if _, err := matchTagPattern(tagPattern, ""); err != nil {
+		g.logger.Warnf("Version information is not exported: invalid tag pattern (%s): %s", tagPattern, err)
+		return nil
+	}
+
+	head, err := runner.RunForOutput(gitCmd.RevParse("HEAD"))
+	if err != nil {
+		g.logger.Warnf("Version information is not exported: %s", err)
+		return nil
+	}
+
+	// Listing the remote tags is cheap compared to fetching them, it tells which commits are worth looking for
+	remoteTags, err := runner.RunForOutput(gitCommand(gitCmd, "ls-remote", "--tags", originRemoteName))
+	if err != nil {
+		g.logger.Warnf("Version information is not exported: failed to list tags: %s", err)
+		return nil
+	}
+	tagsByCommit := parseLsRemoteTags(remoteTags)
+
+	info := versionInfo{headTags: tagsByCommit[head]}
+	gitDir := cfg.commonGitDir()
+
+	matchingTagsByCommit := filterTags(tagsByCommit, tagPattern)
+	taggedCommits, err := findTaggedCommits(gitCmd, gitDir, targets, matchingTagsByCommit)
+	if errors.Is(err, errDeepenLimitReached) {
+		info.approximate = true
+		g.logger.Warnf("No tag matching %s found in the recent history: %s", tagPattern, err)
+	} else if err != nil {
+		g.logger.Warnf("Failed to find the nearest tag: %s", err)
+		info.approximate = true
+	}
+
+	var refspecs []string
+	seen := map[string]bool{}
+	tagsToFetch := append(append([]string{}, info.headTags...), taggedCommitTags(taggedCommits, matchingTagsByCommit)...)
+	for _, tag := range tagsToFetch {
+		if !seen[tag] {
+			seen[tag] = true
+			refspecs = append(refspecs, fmt.Sprintf("%s%s:%s%s", refsTagsPrefix, tag, refsTagsPrefix, tag))
+		}
+	}
+	if len(refspecs) > 0 {
+		// The tagged commits are already available, only the tag objects are transferred
+		opts := append([]string{jobsFlag, "--no-tags", "--no-recurse-submodules", originRemoteName}, refspecs...)
+		if err := runner.RunWithRetry(func() *command.Model {
+			return gitCmd.Fetch(opts...)
+		}); err != nil {
+			g.logger.Warnf("Version information is not exported: failed to fetch tags: %s", err)
+			return nil
+		}
+	}
+
+	if len(taggedCommits) > 0 {
+		describe, err := runner.RunForOutput(gitCommand(gitCmd, "describe", "--tags", "--long", "--abbrev=7", "--match", tagPattern, "HEAD"))
+		if err != nil {
+			g.logger.Warnf("Failed to describe the checked out commit: %s", err)
+			info.approximate = true
+		} else {
+			info.describe = describe
+			info.nearestTag, info.distance = parseDescribe(describe)
+			info.version = parseSemanticVersion(info.nearestTag)
+		}
+	}
+
+	count, err := runner.RunForOutput(gitCmd.RevList("HEAD", "--count"))
+	if err == nil {
+		info.buildNumber, err = strconv.Atoi(count)
+	}
+	if err != nil {
+		g.logger.Warnf("Failed to count commits: %s", err)
+		info.approximate = true
+	} else if isShallowRepository(gitDir) {
+		g.logger.Warnf("The build number (%d) is approximate: it is counted from the shallow history. Set the clone depth to 0 to count the complete history.", info.buildNumber)
+		info.approximate = true
+	}
+
+	return &info
+}
+
+// findTaggedCommits deepens the history of the targets until a commit with a matching tag becomes reachable from HEAD.
+// It returns the tagged commits of the available history, the most recent ones first.
+func findTaggedCommits(gitCmd git.Git, gitDir string, targets []fetchTarget, tagsByCommit map[string][]string) ([]string, error) {
+	if len(tagsByCommit) == 0 {
+		return nil, nil
+	}
+
+	var taggedCommits []string
+	err := deepenHistoryUntil(gitCmd, gitDir, targets, func() bool {
+		out, err := runner.RunForOutput(gitCmd.RevList("HEAD"))
+		if err != nil {
+			return false
+		}
+
+		taggedCommits = nil
+		for _, commit := range strings.Fields(out) {
+			if _, ok := tagsByCommit[commit]; ok && len(taggedCommits) < maxFetchedTaggedCommits {
+				taggedCommits = append(taggedCommits, commit)
+			}
+		}
+		return len(taggedCommits) > 0
+	})
+	if errors.Is(err, errHistoryComplete) {
+		return nil, nil
+	}
+
+	return taggedCommits, err
+}
+
+// parseLsRemoteTags parses the output of `git ls-remote --tags` and returns the tag names by the tagged commits.
+// Example:
+//
+//	b30b826ac9594330d77554f30103492409035aee	refs/tags/1.0.0
+//	a50bdc5182e3e7c292f7c8c881a1a0d9476c8eda	refs/tags/1.1.0
+//	25c9d97e5c9e7f4c9ad25597f8e1265af07869d7	refs/tags/1.1.0^{}
+//
+// Annotated tags (1.1.0) are listed twice, the peeled (^{}) line contains the tagged commit.
+func parseLsRemoteTags(out string) map[string][]string {
+	commitByTag := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], refsTagsPrefix) {
+			continue
+		}
+
+		tag := strings.TrimPrefix(fields[1], refsTagsPrefix)
+		if strings.HasSuffix(tag, peeledTagSuffix) {
+			commitByTag[strings.TrimSuffix(tag, peeledTagSuffix)] = fields[0]
+		} else if _, ok := commitByTag[tag]; !ok {
+			commitByTag[tag] = fields[0]
+		}
+	}
+
+	tagsByCommit := map[string][]string{}
+	for tag, commit := range commitByTag {
+		tagsByCommit[commit] = append(tagsByCommit[commit], tag)
+	}
+	for _, tags := range tagsByCommit {
+		sort.Strings(tags)
+	}
+
+	return tagsByCommit
+}
+
+func filterTags(tagsByCommit map[string][]string, pattern string) map[string][]string {
+	filtered := map[string][]string{}
+	for commit, tags := range tagsByCommit {
+		for _, tag := range tags {
+			if ok, _ := matchTagPattern(pattern, tag); ok {
+				filtered[commit] = append(filtered[commit], tag)
+			}
+		}
+	}
+	return filtered
+}
+
+// matchTagPattern matches the tag similar to `git describe --match`, where `*` matches slashes too
+func matchTagPattern(pattern, tag string) (bool, error) {
+	return path.Match(strings.ReplaceAll(pattern, "/", "\x00"), strings.ReplaceAll(tag, "/", "\x00"))
+}
+
+func taggedCommitTags(commits []string, tagsByCommit map[string][]string) []string {
+	var tags []string
+	for _, commit := range commits {
+		tags = append(tags, tagsByCommit[commit]...)
+	}
+	return tags
+}
+
+// parseDescribe parses the output of `git describe --long`, for example v1.2.3-5-g76a934a
+func parseDescribe(describe string) (tag string, distance int) {
+	match := describePattern.FindStringSubmatch(describe)
+	if match == nil {
+		return "", 0
+	}
+
+	distance, err := strconv.Atoi(match[2])
+	if err != nil {
+		return "", 0
+	}
+	return match[1], distance
+}
+
+func parseSemanticVersion(tag string) *semanticVersion {
+	match := semverPattern.FindStringSubmatch(tag)
+	if match == nil {
+		return nil
+	}
+
+	var numbers [3]int
+	for i := range numbers {
+		n, err := strconv.Atoi(match[i+1])
+		if err != nil {
+			return nil
+		}
+		numbers[i] = n
+	}
+
+	return &semanticVersion{
+		major:      numbers[0],
+		minor:      numbers[1],
+		patch:      numbers[2],
+		prerelease: match[4],
+		build:      match[5],
+	}
+}
@@ -0,0 +1,72 @@
+package gitclone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseLsRemoteTags(t *testing.T) {
+	out := `b30b826ac9594330d77554f30103492409035aee	refs/tags/1.0.0
+a50bdc5182e3e7c292f7c8c881a1a0d9476c8eda	refs/tags/v1.1.0
+25c9d97e5c9e7f4c9ad25597f8e1265af07869d7	refs/tags/v1.1.0^{}
+25c9d97e5c9e7f4c9ad25597f8e1265af07869d7	refs/tags/release/1.1.0
+warning: redirecting to https://github.com/bitrise-io/git-clone-test.git/`
+
+	want := map[string][]string{
+		"b30b826ac9594330d77554f30103492409035aee": {"1.0.0"},
+		"25c9d97e5c9e7f4c9ad25597f8e1265af07869d7": {"release/1.1.0", "v1.1.0"},
+	}
+	assert.Equal(t, want, parseLsRemoteTags(out))
+}
+
+func Test_filterTags(t *testing.T) {
+	tagsByCommit := map[string][]string{
+		"b30b826a": {"1.0.0"},
+		"25c9d97e": {"release/1.1.0", "v1.1.0"},
+	}
+
+	assert.Equal(t, tagsByCommit, filterTags(tagsByCommit, "*"))
+	assert.Equal(t, map[string][]string{"25c9d97e": {"v1.1.0"}}, filterTags(tagsByCommit, "v*"))
+	assert.Equal(t, map[string][]string{"25c9d97e": {"release/1.1.0"}}, filterTags(tagsByCommit, "release/*"))
+	assert.Equal(t, map[string][]string{}, filterTags(tagsByCommit, "[invalid"))
+}
+
+func Test_parseDescribe(t *testing.T) {
+	tests := []struct {
+		describe     string
+		wantTag      string
+		wantDistance int
+	}{
+		{describe: "v1.2.3-5-g76a934a", wantTag: "v1.2.3", wantDistance: 5},
+		{describe: "release-2.0.0-beta-0-g76a934a", wantTag: "release-2.0.0-beta", wantDistance: 0},
+		{describe: "76a934a", wantTag: "", wantDistance: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.describe, func(t *testing.T) {
+			tag, distance := parseDescribe(tt.describe)
+			assert.Equal(t, tt.wantTag, tag)
+			assert.Equal(t, tt.wantDistance, distance)
+		})
+	}
+}
+
+func Test_parseSemanticVersion(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want *semanticVersion
+	}{
+		{tag: "1.2.3", want: &semanticVersion{major: 1, minor: 2, patch: 3}},
+		{tag: "v10.0.1", want: &semanticVersion{major: 10, minor: 0, patch: 1}},
+		{tag: "release/2.0.0-beta.1+42", want: &semanticVersion{major: 2, minor: 0, patch: 0, prerelease: "beta.1", build: "42"}},
+		{tag: "v1.2", want: nil},
+		{tag: "nightly", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseSemanticVersion(tt.tag))
+		})
+	}
+
+	assert.Equal(t, "2.0.0-beta.1+42", parseSemanticVersion("v2.0.0-beta.1+42").String())
+}
@@ -0,0 +1,408 @@
+package gitclone
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command/git"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/repourl"
+)
+
+// RemoteMismatchPolicy controls what happens if the clone directory already contains a repository with a different origin
+type RemoteMismatchPolicy string
+
+const (
+	// RemoteMismatchFail fails the Step
+	RemoteMismatchFail RemoteMismatchPolicy = "fail"
+	// RemoteMismatchUpdate points the origin remote to the configured repository URL and reuses the existing repository
+	RemoteMismatchUpdate RemoteMismatchPolicy = "update"
+	// RemoteMismatchReclone deletes the content of the clone directory and clones the configured repository from scratch
+	RemoteMismatchReclone RemoteMismatchPolicy = "reclone"
+)
+
+// NonEmptyDirPolicy controls what happens if the clone directory is not empty, but it doesn't contain a git repository
+type NonEmptyDirPolicy string
+
+const (
+	// NonEmptyDirWarn logs a warning and clones into the directory anyway (the default)
+	NonEmptyDirWarn NonEmptyDirPolicy = "warn"
+	// NonEmptyDirFail fails the Step
+	NonEmptyDirFail NonEmptyDirPolicy = "fail"
+	// NonEmptyDirWipe deletes the content of the clone directory
+	NonEmptyDirWipe NonEmptyDirPolicy = "wipe"
+	// NonEmptyDirBackup moves the clone directory aside (next to the clone directory) and starts with an empty directory
+	NonEmptyDirBackup NonEmptyDirPolicy = "backup"
+)
+
+// fetchedRemotes are the remotes the Step fetches from (the fork remote is only added for Pull Requests from forks)
+var fetchedRemotes = []string{originRemoteName, forkRemoteName}
+
+// staleRefPrefixes returns the refs created by previous builds in a reused clone directory (on persistent agents):
+// the Pull Request refs and the remote-tracking refs of the fetched remotes.
+// These are recreated by the builds that need them, the leftovers only pile up and might point to outdated commits.
+func staleRefPrefixes() []string {
+	prefixes := []string{"refs/remotes/pull/"}
+	for _, remote := range fetchedRemotes {
+		prefixes = append(prefixes, "refs/remotes/"+remote+"/")
+	}
+	return prefixes
+}
+
+// reconcileOrigin checks the origin remote of the repository already present in the clone directory (if any)
+// and handles the mismatch of the configured repository URL according to RemoteMismatchPolicy.
+// The origin remotes are compared by the repository they point to, so the HTTPS and SSH URLs of the same repository match.
+// It returns true if the existing repository (with a matching origin) can be reused.
+func (g GitCloner) reconcileOrigin(gitCmd git.Git, cfg Config) (bool, error) {
+	url, err := originURL(gitCmd, cfg.CloneIntoDir)
+	if err != nil {
+		return false, newStepError(
+			"check_origin_present_failed",
+			fmt.Errorf("checking if origin is present failed: %v", err),
+			"Checking whether origin is present failed",
+		)
+	}
+	if url == "" {
+		return false, nil
+	}
+	if isSameRepo(url, cfg.RepositoryURL) {
+		if url != cfg.RepositoryURL {
+			// For example the SSH URL of the same repository is configured instead of the HTTPS URL
+			if err := setOriginURL(gitCmd, cfg.RepositoryURL); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	switch cfg.RemoteMismatchPolicy {
+	case RemoteMismatchUpdate:
+		g.logger.Warnf("The clone directory (%s) contains a different repository (%s), pointing origin to %s", cfg.CloneIntoDir, repourl.Redact(url), repourl.Redact(cfg.RepositoryURL))
+		if err := setOriginURL(gitCmd, cfg.RepositoryURL); err != nil {
+			return false, err
+		}
+		return true, nil
+	case RemoteMismatchReclone:
+		g.logger.Warnf("The clone directory (%s) contains a different repository (%s), deleting its content", cfg.CloneIntoDir, repourl.Redact(url))
+		if err := removeDirContent(cfg.CloneIntoDir); err != nil {
+			return false, newStepError(
+				"remove_clone_dir_content_failed",
+				fmt.Errorf("deleting the content of the clone directory failed: %v", err),
+				"Deleting the content of the clone directory failed",
+			)
+		}
+		return false, nil
+	default:
+		return false, newStepError(
+			"check_origin_present_failed",
+			fmt.Errorf(".git folder exists in the directory (%s), but using a different remote (%s)", cfg.CloneIntoDir, repourl.Redact(url)),
+			"Checking whether origin is present failed",
+		)
+	}
+}
+
+// prepareCloneDir handles the content of a clone directory without a git repository according to NonEmptyDirPolicy,
+// so the files already in the directory are not mixed with the files of the repository.
+func (g GitCloner) prepareCloneDir(cfg Config) error {
+	dir := cfg.CloneIntoDir
+	if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return newStepError(
+			"check_clone_dir_failed",
+			fmt.Errorf("reading the clone directory failed: %v", err),
+			"Checking the clone directory failed",
+		)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	switch cfg.NonEmptyDirPolicy {
+	case NonEmptyDirWipe:
+		g.logger.Warnf("The clone directory (%s) is not empty and it doesn't contain a git repository, deleting its content", dir)
+		if err := removeDirContent(dir); err != nil {
+			return newStepError(
+				"remove_clone_dir_content_failed",
+				fmt.Errorf("deleting the content of the clone directory failed: %v", err),
+				"Deleting the content of the clone directory failed",
+			)
+		}
+		return nil
+	case NonEmptyDirBackup:
+		backupDir, err := moveDirAside(dir)
+		if err != nil {
+			return newStepError(
+				"backup_clone_dir_failed",
+				fmt.Errorf("moving the content of the clone directory aside failed: %v", err),
+				"Moving the content of the clone directory aside failed",
+			)
+		}
+		g.logger.Warnf("The clone directory (%s) was not empty and it didn't contain a git repository, its content was moved to %s", dir, backupDir)
+		return nil
+	case NonEmptyDirFail:
+		return newStepError(
+			"clone_dir_not_empty",
+			fmt.Errorf("the clone directory (%s) is not empty and it doesn't contain a git repository, its %d files and directories (for example %s) would be mixed with the files of the repository",
+				dir, len(entries), entries[0].Name()),
+			"The clone directory is not empty",
+		)
+	default:
+		g.logger.Warnf("The clone directory (%s) is not empty and it doesn't contain a git repository, its %d files and directories (for example %s) will be mixed with the files of the repository",
+			dir, len(entries), entries[0].Name())
+		return nil
+	}
+}
+
+// moveDirAside renames the directory to a timestamped backup next to it and recreates the directory empty.
+// It returns the path of the backup.
+func moveDirAside(dir string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(absDir)
+	if err != nil {
+		return "", err
+	}
+
+	backupDir := fmt.Sprintf("%s.backup-%s", absDir, time.Now().Format("20060102-150405"))
+	if _, err := os.Lstat(backupDir); err == nil {
+		return "", fmt.Errorf("backup directory (%s) already exists", backupDir)
+	}
+	if err := os.Rename(absDir, backupDir); err != nil {
+		return "", err
+	}
+	if err := os.Mkdir(absDir, info.Mode().Perm()); err != nil {
+		// The content is moved back, so the clone directory is left as it was
+		if rollbackErr := os.Rename(backupDir, absDir); rollbackErr != nil {
+			return "", fmt.Errorf("%w, moving the content back from %s failed: %v", err, backupDir, rollbackErr)
+		}
+		return "", err
+	}
+	return backupDir, nil
+}
+
+func setOriginURL(gitCmd git.Git, url string) error {
+	if err := runner.Run(gitCommand(gitCmd, "remote", "set-url", originRemoteName, url)); err != nil {
+		return newStepError(
+			"update_remote_failed",
+			fmt.Errorf("updating remote repository URL failed (%s): %v", repourl.Redact(url), err),
+			"Updating remote repository URL failed",
+		)
+	}
+	return nil
+}
+
+// removeDirContent deletes everything in the directory, but keeps the directory itself
+func removeDirContent(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addOrUpdateRemote adds the remote, or updates its URL if the remote already exists (in a reused clone directory)
+func addOrUpdateRemote(gitCmd git.Git, name, url string) error {
+	addErr := runner.Run(gitCmd.RemoteAdd(name, url))
+	if addErr == nil {
+		return nil
+	}
+
+	if _, err := runner.RunForOutput(gitCommand(gitCmd, "remote", "get-url", name)); err != nil {
+		return addErr
+	}
+
+	log.Printf("Remote %s already exists, updating its URL", name)
+	return runner.Run(gitCommand(gitCmd, "remote", "set-url", name, url))
+}
+
+// pruneStaleRefs deletes the refs left behind by previous builds and returns the deleted refs
+func pruneStaleRefs(gitCmd git.Git) ([]string, error) {
+	args := append([]string{"for-each-ref", "--format=%(refname)"}, staleRefPrefixes()...)
+	out, err := runner.RunForOutput(gitCommand(gitCmd, args...))
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []string
+	var commands strings.Builder
+	for _, ref := range strings.Split(out, "\n") {
+		if ref = strings.TrimSpace(ref); ref == "" {
+			continue
+		}
+		refs = append(refs, ref)
+		commands.WriteString("delete " + ref + "\n")
+	}
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	cmd := gitCommand(gitCmd, "update-ref", "--stdin")
+	cmd.SetStdin(strings.NewReader(commands.String()))
+	if err := runner.Run(cmd); err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+// isHeadAtCommit checks if the commit (full or abbreviated hash) is already checked out.
+// An abbreviated hash is resolved first, as it might be ambiguous.
+func isHeadAtCommit(gitCmd git.Git, commit string) bool {
+	if commit == "" {
+		return false
+	}
+
+	head, err := runner.RunForOutput(gitCmd.RevParse("HEAD"))
+	if err != nil {
+		return false
+	}
+	commit = strings.ToLower(commit)
+	if !strings.HasPrefix(head, commit) {
+		return false
+	}
+	if len(commit) == len(head) {
+		return true
+	}
+
+	resolved, err := runner.RunForOutput(gitCommand(gitCmd, "rev-parse", "--verify", "--quiet", commit+"^{commit}"))
+	if err != nil {
+		return false
+	}
+	return resolved == head
+}
+
+// canSkipCheckout reports whether the build trigger commit is already checked out in the reused clone directory.
+// Only the methods checking out the build trigger commit itself qualify, merging a Pull Request creates a new commit each time.
+func canSkipCheckout(gitCmd git.Git, method CheckoutMethod, commit string) bool {
+	switch method {
+	case CheckoutCommitMethod,
+		CheckoutHeadBranchCommitMethod,
+		CheckoutForkCommitMethod:
+		return isHeadAtCommit(gitCmd, commit)
+	default:
+		return false
+	}
+}
+
+// removeStaleSubmodules deinitializes the submodules which are registered by a previous build,
+// but are not part of the checked out commit, and removes their leftover directories.
+// It returns the names (and paths, if different) of the removed submodules.
+func removeStaleSubmodules(gitCmd git.Git, dir string) ([]string, error) {
+	registered, err := submoduleNames(gitCmd, `^submodule\..*\.url$`, "--local")
+	if err != nil {
+		return nil, err
+	}
+	current, err := submoduleNames(gitCmd, `^submodule\..*\.path$`, "--file", ".gitmodules")
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []string
+	for name := range registered {
+		if !current[name] {
+			stale = append(stale, name)
+		}
+	}
+	sort.Strings(stale)
+
+	modulesDir := filepath.Join(dir, ".git", "modules")
+	var removed, stalePaths []string
+	for _, name := range stale {
+		stalePaths = append(stalePaths, submodulePath(gitCmd, dir, filepath.Join(modulesDir, name), name))
+
+		if err := runner.Run(gitCommand(gitCmd, "config", "--remove-section", "submodule."+name)); err != nil {
+			return removed, fmt.Errorf("deinitialize submodule %s: %w", name, err)
+		}
+		if err := os.RemoveAll(filepath.Join(modulesDir, name)); err != nil {
+			return removed, fmt.Errorf("remove git directory of submodule %s: %w", name, err)
+		}
+		removed = append(removed, name)
+	}
+
+	// The working tree of a removed submodule is left behind as an untracked directory with a .git file
+	for _, path := range stalePaths {
+		absPath := filepath.Join(dir, filepath.FromSlash(path))
+		if info, err := os.Lstat(filepath.Join(absPath, ".git")); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		// The path might be reused by a submodule (or any file) of the checked out commit
+		tracked, err := runner.RunForOutput(gitCommand(gitCmd, "ls-files", "--", path))
+		if err != nil {
+			return removed, err
+		}
+		if tracked != "" {
+			continue
+		}
+
+		if err := os.RemoveAll(absPath); err != nil {
+			return removed, fmt.Errorf("remove submodule directory %s: %w", path, err)
+		}
+		if !slices.Contains(removed, path) {
+			removed = append(removed, path)
+		}
+	}
+
+	return removed, nil
+}
+
+// submodulePath returns the working tree path (relative to dir) of a submodule, based on the core.worktree config
+// of its git directory. The name of the submodule is returned if the path can't be determined.
+func submodulePath(gitCmd git.Git, dir, moduleGitDir, name string) string {
+	worktree, err := runner.RunForOutput(gitCommand(gitCmd, "config", "--file", filepath.Join(moduleGitDir, "config"), "core.worktree"))
+	if err != nil || worktree == "" {
+		return name
+	}
+	if !filepath.IsAbs(worktree) {
+		worktree = filepath.Join(moduleGitDir, worktree)
+	}
+
+	path, err := filepath.Rel(dir, worktree)
+	if err != nil || path == "." || strings.HasPrefix(path, "..") {
+		return name
+	}
+	return filepath.ToSlash(path)
+}
+
+// submoduleNames returns the names of the submodules with a config key matching the pattern
+func submoduleNames(gitCmd git.Git, keyPattern string, configArgs ...string) (map[string]bool, error) {
+	args := append([]string{"config"}, configArgs...)
+	args = append(args, "--name-only", "--get-regexp", keyPattern)
+
+	names := map[string]bool{}
+	out, err := runner.RunForOutput(gitCommand(gitCmd, args...))
+	if err != nil {
+		// git config exits with 1 (without any output) if no key matches or the .gitmodules file doesn't exist
+		if out == "" {
+			return names, nil
+		}
+		return nil, err
+	}
+
+	for _, key := range strings.Split(out, "\n") {
+		key = strings.TrimSpace(key)
+		// submodule.<name>.<variable>, the name might contain dots
+		name := strings.TrimPrefix(key, "submodule.")
+		if i := strings.LastIndex(name, "."); i > 0 {
+			names[name[:i]] = true
+		}
+	}
+	return names, nil
+}
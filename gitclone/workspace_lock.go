@@ -0,0 +1,207 @@
+package gitclone
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const workspaceLockPollInterval = time.Second
+
+// workspaceLock is an advisory lock of a clone directory, so concurrent builds on a shared agent don't run git commands
+// in the same repository. The lock file is placed next to the clone directory (not inside it),
+// so cleaning or recloning the repository can't remove it.
+type workspaceLock struct {
+	path  string
+	owner workspaceLockOwner
+}
+
+// workspaceLockOwner is the content of the lock file, used to detect the locks of processes that are no longer running
+type workspaceLockOwner struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// workspaceLockedError is returned when the lock is held by another process for longer than the wait timeout
+type workspaceLockedError struct {
+	dir     string
+	owner   *workspaceLockOwner
+	timeout time.Duration
+}
+
+func (e workspaceLockedError) Error() string {
+	if e.owner == nil {
+		return fmt.Sprintf("clone directory (%s) is locked by another process, waited %s", e.dir, e.timeout)
+	}
+	return fmt.Sprintf("clone directory (%s) is locked by process %d on %s since %s, waited %s",
+		e.dir, e.owner.PID, e.owner.Host, e.owner.CreatedAt.Format(time.RFC3339), e.timeout)
+}
+
+func workspaceLockPath(dir string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(absDir), "."+filepath.Base(absDir)+".git-clone.lock"), nil
+}
+
+// acquireWorkspaceLock locks the clone directory, waiting at most timeout for another process to release it.
+// A lock left behind by a process which is no longer running on this host is removed.
+func acquireWorkspaceLock(dir string, timeout time.Duration, logger log.Logger) (*workspaceLock, error) {
+	path, err := workspaceLockPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	lock := &workspaceLock{
+		path:  path,
+		owner: workspaceLockOwner{PID: os.Getpid(), Host: host, CreatedAt: time.Now().UTC()},
+	}
+
+	deadline := time.Now().Add(timeout)
+	waiting := false
+	for {
+		err := lock.create()
+		if err == nil {
+			return lock, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		// The owner is unknown if the file is just being written
+		owner, readErr := readWorkspaceLockOwner(path)
+		if readErr == nil && owner.isStale(host) {
+			logger.Warnf("Removing the stale lock of the clone directory, process %d is no longer running", owner.PID)
+			if err := removeStaleWorkspaceLock(path, owner); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if !time.Now().Before(deadline) {
+			lockedErr := workspaceLockedError{dir: dir, timeout: timeout}
+			if readErr == nil {
+				lockedErr.owner = &owner
+			}
+			return nil, lockedErr
+		}
+		if !waiting {
+			waiting = true
+			if readErr == nil {
+				logger.Printf("The clone directory is locked by process %d on %s, waiting at most %s", owner.PID, owner.Host, timeout)
+			} else {
+				logger.Printf("The clone directory is locked by another process, waiting at most %s", timeout)
+			}
+		}
+
+		time.Sleep(min(workspaceLockPollInterval, time.Until(deadline)))
+	}
+}
+
+func (l *workspaceLock) create() error {
+	content, err := json.Marshal(l.owner)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		_ = file.Close()
+		_ = os.Remove(l.path)
+		return err
+	}
+	return file.Close()
+}
+
+// release removes the lock file, unless it was already taken over by another process
+func (l *workspaceLock) release() error {
+	owner, err := readWorkspaceLockOwner(l.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if !owner.equal(l.owner) {
+		return fmt.Errorf("lock file (%s) is owned by process %d on %s", l.path, owner.PID, owner.Host)
+	}
+	return os.Remove(l.path)
+}
+
+func readWorkspaceLockOwner(path string) (workspaceLockOwner, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return workspaceLockOwner{}, err
+	}
+
+	var owner workspaceLockOwner
+	if err := json.Unmarshal(content, &owner); err != nil {
+		return workspaceLockOwner{}, err
+	}
+	return owner, nil
+}
+
+// removeStaleWorkspaceLock removes the lock file if it still belongs to the stale owner,
+// so a lock just taken over by another waiting process is kept.
+func removeStaleWorkspaceLock(path string, staleOwner workspaceLockOwner) error {
+	owner, err := readWorkspaceLockOwner(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if !owner.equal(staleOwner) {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (o workspaceLockOwner) equal(other workspaceLockOwner) bool {
+	return o.PID == other.PID && o.Host == other.Host && o.CreatedAt.Equal(other.CreatedAt)
+}
+
+// isStale reports whether the lock owner process is no longer running.
+// The processes of other hosts (sharing the clone directory over network storage) can't be checked, their locks are never stale.
+func (o workspaceLockOwner) isStale(host string) bool {
+	if o.Host != host || o.PID <= 0 {
+		return false
+	}
+	return !isProcessRunning(o.PID)
+}
+
+func isProcessRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	// Signal 0 only checks if the process exists (and we are allowed to signal it)
+	err = process.Signal(syscall.Signal(0))
+	if err == nil || errors.Is(err, syscall.EPERM) {
+		return true
+	}
+	return !errors.Is(err, os.ErrProcessDone) && !errors.Is(err, syscall.ESRCH)
+}
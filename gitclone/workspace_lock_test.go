@@ -0,0 +1,98 @@
+package gitclone
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_acquireWorkspaceLock(t *testing.T) {
+	logger := log.NewLogger()
+	host, err := os.Hostname()
+	require.NoError(t, err)
+
+	writeLock := func(t *testing.T, dir string, owner workspaceLockOwner) string {
+		path, err := workspaceLockPath(dir)
+		require.NoError(t, err)
+		content, err := json.Marshal(owner)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, content, 0644))
+		return path
+	}
+
+	t.Run("Acquire and release", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "src")
+
+		lock, err := acquireWorkspaceLock(dir, 0, logger)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(filepath.Dir(dir), ".src.git-clone.lock"), lock.path)
+		assert.FileExists(t, lock.path)
+
+		require.NoError(t, lock.release())
+		assert.NoFileExists(t, lock.path)
+	})
+
+	t.Run("Locked by a running process", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "src")
+		lock, err := acquireWorkspaceLock(dir, 0, logger)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, lock.release()) }()
+
+		_, err = acquireWorkspaceLock(dir, 0, logger)
+		var lockedErr workspaceLockedError
+		require.ErrorAs(t, err, &lockedErr)
+		require.NotNil(t, lockedErr.owner)
+		assert.Equal(t, os.Getpid(), lockedErr.owner.PID)
+		assert.Equal(t, host, lockedErr.owner.Host)
+	})
+
+	t.Run("Waits for the lock to be released", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "src")
+		lock, err := acquireWorkspaceLock(dir, 0, logger)
+		require.NoError(t, err)
+		time.AfterFunc(100*time.Millisecond, func() { _ = lock.release() })
+
+		otherLock, err := acquireWorkspaceLock(dir, 10*time.Second, logger)
+		require.NoError(t, err)
+		require.NoError(t, otherLock.release())
+	})
+
+	t.Run("Stale lock of an exited process", func(t *testing.T) {
+		cmd := exec.Command("true")
+		require.NoError(t, cmd.Run())
+
+		dir := filepath.Join(t.TempDir(), "src")
+		writeLock(t, dir, workspaceLockOwner{PID: cmd.Process.Pid, Host: host, CreatedAt: time.Now().UTC()})
+
+		lock, err := acquireWorkspaceLock(dir, 0, logger)
+		require.NoError(t, err)
+		assert.Equal(t, os.Getpid(), lock.owner.PID)
+		require.NoError(t, lock.release())
+	})
+
+	t.Run("Lock of an other host is never stale", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "src")
+		path := writeLock(t, dir, workspaceLockOwner{PID: 1 << 30, Host: "other-" + host, CreatedAt: time.Now().UTC()})
+
+		_, err := acquireWorkspaceLock(dir, 0, logger)
+		require.ErrorAs(t, err, &workspaceLockedError{})
+		assert.FileExists(t, path)
+	})
+
+	t.Run("Release keeps the lock of an other process", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "src")
+		lock, err := acquireWorkspaceLock(dir, 0, logger)
+		require.NoError(t, err)
+
+		path := writeLock(t, dir, workspaceLockOwner{PID: 1, Host: host, CreatedAt: time.Now().UTC()})
+		require.Error(t, lock.release())
+		assert.FileExists(t, path)
+	})
+}
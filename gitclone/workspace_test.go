@@ -0,0 +1,330 @@
+package gitclone
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/command/git"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_addOrUpdateRemote(t *testing.T) {
+	tests := []struct {
+		name       string
+		mockRunner *MockRunner
+		wantErr    bool
+		wantCmds   []string
+	}{
+		{
+			name:       "New remote",
+			mockRunner: givenMockRunnerSucceeds(),
+			wantCmds: []string{
+				`git "remote" "add" "fork" "https://github.com/bitrise-io/other-repo.git"`,
+			},
+		},
+		{
+			name: "Remote already exists",
+			mockRunner: givenMockRunner().
+				GivenRunFailsForCommand(`git "remote" "add" "fork" "https://github.com/bitrise-io/other-repo.git"`, 1).
+				GivenRunSucceeds(),
+			wantCmds: []string{
+				`git "remote" "add" "fork" "https://github.com/bitrise-io/other-repo.git"`,
+				`git "remote" "get-url" "fork"`,
+				`git "remote" "set-url" "fork" "https://github.com/bitrise-io/other-repo.git"`,
+			},
+		},
+		{
+			name: "Adding remote fails",
+			mockRunner: new(MockRunner).
+				GivenRunForOutputFailsForCommand(`git "remote" "get-url" "fork"`, 1).
+				GivenRunFailsForCommand(`git "remote" "add" "fork" "https://github.com/bitrise-io/other-repo.git"`, 1),
+			wantErr: true,
+			wantCmds: []string{
+				`git "remote" "add" "fork" "https://github.com/bitrise-io/other-repo.git"`,
+				`git "remote" "get-url" "fork"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner = tt.mockRunner
+
+			err := addOrUpdateRemote(git.Git{}, forkRemoteName, "https://github.com/bitrise-io/other-repo.git")
+
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantCmds, tt.mockRunner.Cmds())
+		})
+	}
+}
+
+func Test_pruneStaleRefs(t *testing.T) {
+	mockRunner := new(MockRunner).
+		GivenRunForOutputReturnsForCommand(
+			`git "for-each-ref" "--format=%(refname)" "refs/remotes/pull/" "refs/remotes/origin/" "refs/remotes/fork/"`,
+			"refs/remotes/pull/7/merge\nrefs/remotes/origin/main\nrefs/remotes/fork/feature",
+		).
+		GivenRunSucceeds()
+	runner = mockRunner
+
+	refs, err := pruneStaleRefs(git.Git{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"refs/remotes/pull/7/merge", "refs/remotes/origin/main", "refs/remotes/fork/feature"}, refs)
+	assert.Equal(t, []string{
+		`git "for-each-ref" "--format=%(refname)" "refs/remotes/pull/" "refs/remotes/origin/" "refs/remotes/fork/"`,
+		`git "update-ref" "--stdin"`,
+	}, mockRunner.Cmds())
+}
+
+func Test_removeStaleSubmodules(t *testing.T) {
+	runner = &DefaultRunner{}
+
+	dir := t.TempDir()
+	gitCmd, err := git.New(dir)
+	require.NoError(t, err)
+	runGit := func(args ...string) {
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+
+	// Given a submodule (lib) registered by a previous build, but removed from the repository since,
+	// and a submodule (app) which is still part of the repository
+	runGit("init", "-q")
+	runGit("config", "submodule.lib.url", "https://github.com/bitrise-io/lib.git")
+	runGit("config", "submodule.app.url", "https://github.com/bitrise-io/app.git")
+	runGit("config", "--file", ".gitmodules", "submodule.app.path", "app")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".git", "modules", "lib"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "lib"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lib", ".git"), []byte("gitdir: ../.git/modules/lib\n"), 0644))
+	// A stale submodule (design) checked out into a path different from its name
+	runGit("config", "submodule.design.url", "https://github.com/bitrise-io/design.git")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".git", "modules", "design"), 0755))
+	runGit("config", "--file", filepath.Join(".git", "modules", "design", "config"), "core.worktree", "../../../assets/design")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "assets", "design"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "assets", "design", ".git"), []byte("gitdir: ../../.git/modules/design\n"), 0644))
+	// An untracked directory, which is not a submodule
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "build"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "build", "output.txt"), []byte("output"), 0644))
+	// An untracked repository with a .git file, which doesn't belong to a stale submodule
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "tools"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tools", ".git"), []byte("gitdir: /tmp/tools.git\n"), 0644))
+
+	// When
+	removed, err := removeStaleSubmodules(gitCmd, dir)
+
+	// Then
+	require.NoError(t, err)
+	assert.Equal(t, []string{"design", "lib", "assets/design"}, removed)
+	assert.NoDirExists(t, filepath.Join(dir, "lib"))
+	assert.NoDirExists(t, filepath.Join(dir, ".git", "modules", "lib"))
+	assert.NoDirExists(t, filepath.Join(dir, "assets", "design"))
+	assert.NoDirExists(t, filepath.Join(dir, ".git", "modules", "design"))
+	assert.DirExists(t, filepath.Join(dir, "build"))
+	assert.DirExists(t, filepath.Join(dir, "tools"))
+
+	out, err := exec.Command("git", "-C", dir, "config", "--get", "submodule.app.url").CombinedOutput()
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/bitrise-io/app.git\n", string(out))
+	_, err = exec.Command("git", "-C", dir, "config", "--get", "submodule.lib.url").CombinedOutput()
+	assert.Error(t, err)
+}
+
+func Test_reconcileOrigin(t *testing.T) {
+	const remoteList = "origin\tgit@github.com:bitrise-io/git-clone-test.git (fetch)\norigin\tgit@github.com:bitrise-io/git-clone-test.git (push)"
+
+	tests := []struct {
+		name        string
+		repoURL     string
+		policy      RemoteMismatchPolicy
+		want        bool
+		wantErr     bool
+		wantCmds    []string
+		wantRemoved bool
+	}{
+		{
+			name:    "Same URL",
+			repoURL: "git@github.com:bitrise-io/git-clone-test.git",
+			want:    true,
+			wantCmds: []string{
+				`git "remote" "-v"`,
+			},
+		},
+		{
+			name:    "Same repository, different URL",
+			repoURL: "https://github.com/bitrise-io/git-clone-test",
+			want:    true,
+			wantCmds: []string{
+				`git "remote" "-v"`,
+				`git "remote" "set-url" "origin" "https://github.com/bitrise-io/git-clone-test"`,
+			},
+		},
+		{
+			name:    "Different repository, fail",
+			repoURL: "https://github.com/bitrise-io/other-repo.git",
+			policy:  RemoteMismatchFail,
+			wantErr: true,
+			wantCmds: []string{
+				`git "remote" "-v"`,
+			},
+		},
+		{
+			name:    "Different repository, update",
+			repoURL: "https://github.com/bitrise-io/other-repo.git",
+			policy:  RemoteMismatchUpdate,
+			want:    true,
+			wantCmds: []string{
+				`git "remote" "-v"`,
+				`git "remote" "set-url" "origin" "https://github.com/bitrise-io/other-repo.git"`,
+			},
+		},
+		{
+			name:    "Different repository, reclone",
+			repoURL: "https://github.com/bitrise-io/other-repo.git",
+			policy:  RemoteMismatchReclone,
+			want:    false,
+			wantCmds: []string{
+				`git "remote" "-v"`,
+			},
+			wantRemoved: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			dir := t.TempDir()
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, ".git"), 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("readme"), 0644))
+
+			mockRunner := new(MockRunner).
+				GivenRunForOutputReturnsForCommand(`git "remote" "-v"`, remoteList).
+				GivenRunSucceeds()
+			runner = mockRunner
+
+			cloner := GitCloner{logger: log.NewLogger()}
+			cfg := Config{CloneIntoDir: dir, RepositoryURL: tt.repoURL, RemoteMismatchPolicy: tt.policy}
+
+			// When
+			got, err := cloner.reconcileOrigin(git.Git{}, cfg)
+
+			// Then
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantCmds, mockRunner.Cmds())
+
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantRemoved, len(entries) == 0)
+		})
+	}
+}
+
+func Test_prepareCloneDir(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       []string
+		policy      NonEmptyDirPolicy
+		wantErr     bool
+		wantFiles   []string
+		wantBackups []string
+	}{
+		{
+			name: "Empty directory",
+		},
+		{
+			name:      "Existing repository",
+			files:     []string{".git/HEAD", "README.md"},
+			wantFiles: []string{".git", "README.md"},
+		},
+		{
+			name:      "Not empty, fail",
+			files:     []string{"node_modules/index.js", "README.md"},
+			policy:    NonEmptyDirFail,
+			wantErr:   true,
+			wantFiles: []string{"README.md", "node_modules"},
+		},
+		{
+			name:      "Not empty, default policy warns",
+			files:     []string{"README.md"},
+			wantFiles: []string{"README.md"},
+		},
+		{
+			name:      "Not empty, warn",
+			files:     []string{"node_modules/index.js", "README.md"},
+			policy:    NonEmptyDirWarn,
+			wantFiles: []string{"README.md", "node_modules"},
+		},
+		{
+			name:   "Not empty, wipe",
+			files:  []string{"node_modules/index.js", "README.md"},
+			policy: NonEmptyDirWipe,
+		},
+		{
+			name:        "Not empty, backup",
+			files:       []string{"node_modules/index.js", "README.md"},
+			policy:      NonEmptyDirBackup,
+			wantBackups: []string{"README.md", "node_modules"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Given
+			dir := filepath.Join(t.TempDir(), "src")
+			require.NoError(t, os.MkdirAll(dir, 0755))
+			for _, file := range tt.files {
+				require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, file)), 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, file), []byte("content"), 0644))
+			}
+
+			cloner := GitCloner{logger: log.NewLogger()}
+			cfg := Config{CloneIntoDir: dir, NonEmptyDirPolicy: tt.policy}
+
+			// When
+			err := cloner.prepareCloneDir(cfg)
+
+			// Then
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantFiles, dirEntryNames(t, dir))
+
+			backups, err := filepath.Glob(dir + ".backup-*")
+			require.NoError(t, err)
+			if tt.wantBackups == nil {
+				assert.Empty(t, backups)
+			} else {
+				require.Len(t, backups, 1)
+				assert.Equal(t, tt.wantBackups, dirEntryNames(t, backups[0]))
+			}
+		})
+	}
+
+	t.Run("Missing directory", func(t *testing.T) {
+		cloner := GitCloner{logger: log.NewLogger()}
+		require.NoError(t, cloner.prepareCloneDir(Config{CloneIntoDir: filepath.Join(t.TempDir(), "src")}))
+	})
+}
+
+func dirEntryNames(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
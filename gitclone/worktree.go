@@ -0,0 +1,367 @@
+package gitclone
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command/git"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/repourl"
+)
+
+const (
+	worktreeSetupFailedTag = "worktree_setup_failed"
+	// worktreeBaseRef points to an empty commit, new worktrees are created at this commit before the actual checkout
+	worktreeBaseRef = "refs/git-clone/worktree-base"
+	emptyTreeHash   = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	// worktreeRefNamespace prefixes the names of the temporary refs of the worktrees
+	worktreeRefNamespace = "git-clone/"
+)
+
+// sharedRepositoryDir returns the bare repository (in the cache directory) shared by the builds of the repository
+func sharedRepositoryDir(cacheDir, repositoryURL string) (string, error) {
+	repo, err := repourl.Parse(repositoryURL)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, filepath.FromSlash(repo.ID())+".git"), nil
+}
+
+// worktree is the clone directory checked out as a linked worktree of the shared repository
+type worktree struct {
+	sharedDir   string
+	lockTimeout time.Duration
+	// refs names the temporary refs created by the checkout in the worktree
+	refs tempRefs
+}
+
+// lock locks the shared repository, it returns the function releasing the lock
+func (w worktree) lock(logger log.Logger) (func(), error) {
+	lock, err := acquireWorkspaceLock(w.sharedDir, w.lockTimeout, logger)
+	if err != nil {
+		return nil, newStepError(
+			"workspace_lock_failed",
+			fmt.Errorf("locking the shared repository failed: %v", err),
+			"Locking the shared repository failed",
+		)
+	}
+	return func() {
+		if err := lock.release(); err != nil {
+			logger.Warnf("Failed to release the lock of the shared repository: %s", err)
+		}
+	}, nil
+}
+
+// tempRefs names the temporary refs created by the checkout: the local branches, the Pull Request refs and the fork remote.
+// In worktree mode the names are prefixed by the namespace of the worktree (git-clone/<worktree>/),
+// so the builds sharing the repository don't move each other's refs.
+type tempRefs struct {
+	namespace string
+}
+
+func newWorktreeTempRefs(worktreeID string) tempRefs {
+	return tempRefs{namespace: worktreeRefNamespace + worktreeID + "/"}
+}
+
+// branch returns the name of the local branch created for the branch of the remote
+func (r tempRefs) branch(name string) string {
+	return r.namespace + name
+}
+
+// forkRemote returns the name of the remote added for the fork of the repository
+func (r tempRefs) forkRemote() string {
+	return r.namespace + forkRemoteName
+}
+
+// remoteRef returns the local ref a ref of the remote (for example pull/7/merge) is fetched into
+func (r tempRefs) remoteRef(ref string) string {
+	return "refs/remotes/" + r.namespace + ref
+}
+
+// withoutHistoryLimit disables the shallow fetches in worktree mode: the shallow file of the shared repository
+// can't be namespaced like the refs, so a depth limited fetch in one worktree would cut the history of the others.
+// The full history is fetched only once, later builds fetch just the new objects into the shared object store.
+func withoutHistoryLimit(cfg Config) (Config, bool) {
+	limited := cfg.CloneDepth > 0 || cfg.ShallowSince != "" || len(cfg.ShallowExclude) > 0
+	cfg.CloneDepth = -1
+	cfg.ShallowSince, cfg.ShallowExclude = "", nil
+	return cfg, limited
+}
+
+// setupWorktree materializes the clone directory as a linked worktree of the bare repository shared by the builds
+// of the same remote, so the builds running at the same time on one machine share a single object store.
+// The shared repository is only locked while its refs or config are changed: during the setup, by the git commands
+// changing them (see changesSharedRepository) and by finishWorktree.
+func (g GitCloner) setupWorktree(cfg Config) (*worktree, error) {
+	dir, err := sharedRepositoryDir(cfg.WorktreeCacheDir, cfg.RepositoryURL)
+	if err != nil {
+		return nil, newStepError(
+			worktreeSetupFailedTag,
+			fmt.Errorf("parsing repository URL failed: %v", err),
+			"Setting up the worktree failed",
+		)
+	}
+
+	wt := &worktree{sharedDir: dir, lockTimeout: cfg.WorkspaceLockTimeout}
+	releaseLock, err := wt.lock(g.logger)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseLock()
+
+	g.logger.Println()
+	g.logger.Infof("Setting up worktree of the shared repository (%s)", dir)
+	// The shared repository is bare, it has no .git directory
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err == nil {
+		if sharedGitCmd, err := git.New(dir); err == nil {
+			g.recoverInterruptedRun(sharedGitCmd)
+		}
+	}
+	if err := setupSharedRepository(dir, cfg.RepositoryURL); err != nil {
+		return nil, newStepError(
+			worktreeSetupFailedTag,
+			fmt.Errorf("setting up the shared repository failed: %v", err),
+			"Setting up the worktree failed",
+		)
+	}
+
+	sharedGitCmd, err := git.New(dir)
+	if err != nil {
+		return nil, err
+	}
+	if pruned, err := runner.RunForOutput(gitCommand(sharedGitCmd, "worktree", "prune", "--verbose")); err != nil {
+		g.logger.Warnf("Failed to prune the worktrees of previous builds: %s", err)
+	} else if pruned != "" {
+		g.logger.Printf("Pruned the worktrees of previous builds:\n%s", pruned)
+	}
+	if err := pruneStaleWorktreeRefs(sharedGitCmd, dir); err != nil {
+		g.logger.Warnf("Failed to delete the temporary refs of the pruned worktrees: %s", err)
+	}
+
+	if err := addWorktree(sharedGitCmd, dir, cfg.CloneIntoDir); err != nil {
+		return nil, newStepError(
+			worktreeSetupFailedTag,
+			fmt.Errorf("adding worktree failed: %v", err),
+			"Setting up the worktree failed",
+		)
+	}
+
+	gitCmd, err := git.New(cfg.CloneIntoDir)
+	if err != nil {
+		return nil, err
+	}
+	// The git directory of a linked worktree is named after the worktree (<shared repository>/worktrees/<id>)
+	worktreeGitDir, err := runner.RunForOutput(gitCommand(gitCmd, "rev-parse", "--path-format=absolute", "--git-dir"))
+	if err != nil {
+		return nil, newStepError(
+			worktreeSetupFailedTag,
+			fmt.Errorf("finding the git directory of the worktree failed: %v", err),
+			"Setting up the worktree failed",
+		)
+	}
+	wt.refs = newWorktreeTempRefs(filepath.Base(worktreeGitDir))
+
+	runner.SetSharedRepositoryLock(cfg.CloneIntoDir, func() (func(), error) {
+		return wt.lock(g.logger)
+	})
+
+	return wt, nil
+}
+
+// finishWorktree deletes the temporary refs of the worktree, while the shared repository is locked
+func (g GitCloner) finishWorktree(wt *worktree, gitCmd git.Git, cloneDir string) {
+	// The lock is taken for the whole cleanup below, the git commands must not take it again
+	runner.SetSharedRepositoryLock(cloneDir, nil)
+
+	releaseLock, err := wt.lock(g.logger)
+	if err != nil {
+		g.logger.Warnf("Failed to clean up the temporary refs of the worktree: %s", err)
+		return
+	}
+	defer releaseLock()
+
+	if err := cleanupWorktree(gitCmd, wt.refs); err != nil {
+		g.logger.Warnf("Failed to clean up the temporary refs of the worktree: %s", err)
+	}
+}
+
+func setupSharedRepository(dir, repositoryURL string) error {
+	gitCmd, err := git.New(dir)
+	if err != nil {
+		return err
+	}
+
+	// Reinitializing an existing repository is safe, it also restores core.bare if it was overwritten
+	if err := runner.Run(gitCommand(gitCmd, "init", "--bare")); err != nil {
+		return err
+	}
+	// The sparse-checkout and partial clone config of each worktree is kept in its own config (config.worktree).
+	// With the per-worktree config enabled, core.bare would apply to every worktree, so it's moved into the config of the shared repository's own worktree.
+	if err := runner.Run(gitCommand(gitCmd, "config", "extensions.worktreeConfig", "true")); err != nil {
+		return err
+	}
+	if err := runner.Run(gitCommand(gitCmd, "config", "--worktree", "core.bare", "true")); err != nil {
+		return err
+	}
+	if err := runner.Run(gitCommand(gitCmd, "config", "--unset", "core.bare")); err != nil {
+		return err
+	}
+	if err := addOrUpdateRemote(gitCmd, originRemoteName, repositoryURL); err != nil {
+		return err
+	}
+
+	if _, err := runner.RunForOutput(gitCommand(gitCmd, "rev-parse", "--verify", "--quiet", worktreeBaseRef)); err == nil {
+		return nil
+	}
+	if err := runner.Run(gitCommand(gitCmd, "hash-object", "-t", "tree", "-w", os.DevNull)); err != nil {
+		return err
+	}
+	// The commit is created with a fixed author and date, so it's the same in every shared repository
+	cmd := gitCommand(gitCmd, "commit-tree", emptyTreeHash, "-m", "Empty worktree base")
+	cmd.AppendEnvs(
+		"GIT_AUTHOR_NAME=git-clone", "GIT_AUTHOR_EMAIL=", "GIT_AUTHOR_DATE=@0 +0000",
+		"GIT_COMMITTER_NAME=git-clone", "GIT_COMMITTER_EMAIL=", "GIT_COMMITTER_DATE=@0 +0000",
+	)
+	commit, err := runner.RunForOutput(cmd)
+	if err != nil {
+		return err
+	}
+	return runner.Run(gitCommand(gitCmd, "update-ref", worktreeBaseRef, commit))
+}
+
+// addWorktree adds the clone directory as a worktree of the shared repository,
+// or reuses it if it's already a worktree of the shared repository (on persistent agents)
+func addWorktree(sharedGitCmd git.Git, sharedDir, cloneDir string) error {
+	absCloneDir, err := filepath.Abs(cloneDir)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(filepath.Join(absCloneDir, ".git")); err == nil {
+		gitCmd, err := git.New(absCloneDir)
+		if err != nil {
+			return err
+		}
+		commonDir, err := runner.RunForOutput(gitCommand(gitCmd, "rev-parse", "--path-format=absolute", "--git-common-dir"))
+		if err != nil {
+			return err
+		}
+		if !isSamePath(commonDir, sharedDir) {
+			return fmt.Errorf("the clone directory (%s) contains a repository which is not a worktree of the shared repository (%s)", cloneDir, sharedDir)
+		}
+		return nil
+	}
+
+	entries, err := os.ReadDir(absCloneDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("the clone directory (%s) is not empty", cloneDir)
+	}
+
+	return runner.Run(gitCommand(sharedGitCmd, "worktree", "add", "--detach", absCloneDir, worktreeBaseRef))
+}
+
+func isSamePath(path, otherPath string) bool {
+	resolve := func(p string) string {
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			p = resolved
+		}
+		return p
+	}
+	return resolve(path) == resolve(otherPath)
+}
+
+// pruneStaleWorktreeRefs deletes the temporary refs and fork remotes left behind by the worktrees which were pruned
+// (for example because the build was killed before its cleanup)
+func pruneStaleWorktreeRefs(sharedGitCmd git.Git, sharedDir string) error {
+	isStale := func(name string) bool {
+		id, _, _ := strings.Cut(strings.TrimPrefix(name, worktreeRefNamespace), "/")
+		_, err := os.Stat(filepath.Join(sharedDir, "worktrees", id))
+		return os.IsNotExist(err)
+	}
+
+	remotes, err := runner.RunForOutput(gitCommand(sharedGitCmd, "remote"))
+	if err != nil {
+		return err
+	}
+	for _, remote := range strings.Split(remotes, "\n") {
+		if remote = strings.TrimSpace(remote); strings.HasPrefix(remote, worktreeRefNamespace) && isStale(remote) {
+			if err := runner.Run(gitCommand(sharedGitCmd, "remote", "remove", remote)); err != nil {
+				return err
+			}
+		}
+	}
+
+	refList, err := runner.RunForOutput(gitCommand(sharedGitCmd, "for-each-ref", "--format=%(refname)",
+		refsHeadsPrefix+worktreeRefNamespace, "refs/remotes/"+worktreeRefNamespace))
+	if err != nil {
+		return err
+	}
+	var refs []string
+	for _, ref := range strings.Split(refList, "\n") {
+		ref = strings.TrimSpace(ref)
+		name := strings.TrimPrefix(strings.TrimPrefix(ref, refsHeadsPrefix), "refs/remotes/")
+		if ref != "" && isStale(name) {
+			refs = append(refs, ref)
+		}
+	}
+	return deleteRefs(sharedGitCmd, refs)
+}
+
+// cleanupWorktree detaches the HEAD of the worktree and deletes the temporary refs (local branches, Pull Request refs
+// and the fork remote) created by the checkout. A branch can only be checked out in one worktree at a time,
+// so the worktree is left at the checked out commit.
+func cleanupWorktree(gitCmd git.Git, refs tempRefs) error {
+	if _, err := runner.RunForOutput(gitCommand(gitCmd, "symbolic-ref", "--quiet", "HEAD")); err == nil {
+		if err := runner.Run(gitCmd.Checkout("--detach")); err != nil {
+			return err
+		}
+	}
+
+	remotes, err := runner.RunForOutput(gitCommand(gitCmd, "remote"))
+	if err != nil {
+		return err
+	}
+	if slices.Contains(strings.Split(remotes, "\n"), refs.forkRemote()) {
+		if err := runner.Run(gitCommand(gitCmd, "remote", "remove", refs.forkRemote())); err != nil {
+			return err
+		}
+	}
+
+	refList, err := runner.RunForOutput(gitCommand(gitCmd, "for-each-ref", "--format=%(refname)",
+		refsHeadsPrefix+refs.branch(""), refs.remoteRef("")))
+	if err != nil {
+		return err
+	}
+	var tempRefs []string
+	for _, ref := range strings.Split(refList, "\n") {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			tempRefs = append(tempRefs, ref)
+		}
+	}
+	return deleteRefs(gitCmd, tempRefs)
+}
+
+// deleteRefs deletes the refs in a single transaction
+func deleteRefs(gitCmd git.Git, refs []string) error {
+	if len(refs) == 0 {
+		return nil
+	}
+
+	var commands strings.Builder
+	for _, ref := range refs {
+		commands.WriteString("delete " + ref + "\n")
+	}
+	cmd := gitCommand(gitCmd, "update-ref", "--stdin")
+	cmd.SetStdin(strings.NewReader(commands.String()))
+	return runner.Run(cmd)
+}
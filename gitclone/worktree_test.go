@@ -0,0 +1,176 @@
+package gitclone
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/command/git"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sharedRepositoryDir(t *testing.T) {
+	httpsDir, err := sharedRepositoryDir("/cache", "https://github.com/bitrise-io/git-clone-test.git")
+	require.NoError(t, err)
+	sshDir, err := sharedRepositoryDir("/cache", "git@github.com:bitrise-io/git-clone-test.git")
+	require.NoError(t, err)
+
+	assert.Equal(t, "/cache/github.com/bitrise-io/git-clone-test.git", httpsDir)
+	assert.Equal(t, httpsDir, sshDir)
+}
+
+func Test_worktree(t *testing.T) {
+	runner = &DefaultRunner{}
+
+	root := t.TempDir()
+	runGit := func(dir string, args ...string) string {
+		args = append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		out, err := exec.Command("git", args...).CombinedOutput()
+		require.NoError(t, err, string(out))
+		return strings.TrimSpace(string(out))
+	}
+
+	// Given a remote repository and a shared repository
+	remoteDir := filepath.Join(root, "remote")
+	require.NoError(t, os.MkdirAll(remoteDir, 0755))
+	runGit(remoteDir, "init", "-q")
+	runGit(remoteDir, "commit", "-q", "--allow-empty", "-m", "initial")
+	sharedDir := filepath.Join(root, "cache", "remote.git")
+	require.NoError(t, setupSharedRepository(sharedDir, remoteDir))
+	sharedGitCmd, err := git.New(sharedDir)
+	require.NoError(t, err)
+
+	// When the clone directory is added as a worktree
+	cloneDir := filepath.Join(root, "src")
+	require.NoError(t, addWorktree(sharedGitCmd, sharedDir, cloneDir))
+
+	// Then
+	info, err := os.Stat(filepath.Join(cloneDir, ".git"))
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+	assert.Equal(t, "true", runGit(sharedDir, "config", "core.bare"))
+	assert.Equal(t, remoteDir, runGit(sharedDir, "remote", "get-url", "origin"))
+
+	t.Run("Reuses the worktree of a previous build", func(t *testing.T) {
+		require.NoError(t, setupSharedRepository(sharedDir, remoteDir))
+		require.NoError(t, addWorktree(sharedGitCmd, sharedDir, cloneDir))
+	})
+
+	t.Run("Fails for a non-empty directory", func(t *testing.T) {
+		dir := filepath.Join(root, "not-empty")
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("content"), 0644))
+
+		require.Error(t, addWorktree(sharedGitCmd, sharedDir, dir))
+	})
+
+	t.Run("Fails for a standalone repository", func(t *testing.T) {
+		dir := filepath.Join(root, "standalone")
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		runGit(dir, "init", "-q")
+
+		require.Error(t, addWorktree(sharedGitCmd, sharedDir, dir))
+	})
+
+	t.Run("Cleanup detaches HEAD and deletes the temporary refs of the worktree", func(t *testing.T) {
+		gitCmd, err := git.New(cloneDir)
+		require.NoError(t, err)
+		refs := newWorktreeTempRefs(filepath.Base(cloneDir))
+		runGit(cloneDir, "fetch", "-q", "origin", "refs/heads/master:"+refs.remoteRef("pull/1/head"))
+		runGit(cloneDir, "checkout", "-q", "-B", refs.branch("master"), refs.remoteRef("pull/1/head"))
+		runGit(cloneDir, "remote", "add", refs.forkRemote(), remoteDir)
+		runGit(cloneDir, "branch", "git-clone/other/master")
+
+		require.NoError(t, cleanupWorktree(gitCmd, refs))
+
+		assert.Equal(t, "HEAD", runGit(cloneDir, "rev-parse", "--abbrev-ref", "HEAD"))
+		assert.Equal(t, "", runGit(cloneDir, "for-each-ref", "refs/heads/git-clone/src/", "refs/remotes/git-clone/src/"))
+		assert.Equal(t, "origin", runGit(cloneDir, "remote"))
+		assert.Equal(t, "refs/heads/git-clone/other/master", runGit(cloneDir, "for-each-ref", "--format=%(refname)", "refs/heads/"))
+		assert.NotEmpty(t, runGit(cloneDir, "rev-parse", "--verify", worktreeBaseRef))
+	})
+
+	t.Run("Removes the lock files of the worktree", func(t *testing.T) {
+		worktreeLock := filepath.Join(sharedDir, "worktrees", "src", "index.lock")
+		sharedLock := filepath.Join(sharedDir, "shallow.lock")
+		otherWorktreeLock := filepath.Join(sharedDir, "worktrees", "other", "index.lock")
+		require.NoError(t, os.MkdirAll(filepath.Dir(otherWorktreeLock), 0755))
+		for _, lock := range []string{worktreeLock, sharedLock, otherWorktreeLock} {
+			require.NoError(t, os.WriteFile(lock, nil, 0644))
+		}
+
+		removed, err := removeLockFilesOf(cloneDir, false)
+		require.NoError(t, err)
+		assert.Equal(t, []string{worktreeLock}, removed)
+		assert.FileExists(t, sharedLock)
+
+		removed, err = removeLockFilesOf(cloneDir, true)
+		require.NoError(t, err)
+		assert.Equal(t, []string{sharedLock}, removed)
+		assert.FileExists(t, otherWorktreeLock)
+		require.NoError(t, os.RemoveAll(filepath.Dir(otherWorktreeLock)))
+	})
+
+	t.Run("Deletes the temporary refs of the pruned worktrees", func(t *testing.T) {
+		runGit(cloneDir, "branch", "git-clone/src/master")
+		runGit(cloneDir, "remote", "add", "git-clone/other/fork", remoteDir)
+
+		require.NoError(t, pruneStaleWorktreeRefs(sharedGitCmd, sharedDir))
+
+		assert.Equal(t, "refs/heads/git-clone/src/master", runGit(cloneDir, "for-each-ref", "--format=%(refname)", "refs/heads/"))
+		assert.Equal(t, "origin", runGit(cloneDir, "remote"))
+	})
+}
+
+func Test_changesSharedRepository(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{args: []string{"git", "fetch", "--jobs=10", "origin", "refs/heads/master"}, want: true},
+		{args: []string{"git", "update-ref", "--stdin"}, want: true},
+		{args: []string{"git", "remote", "add", "git-clone/src/fork", "https://github.com/fork/repo.git"}, want: true},
+		{args: []string{"git", "checkout", "-B", "git-clone/src/master", "origin/master"}, want: true},
+		{args: []string{"git", "checkout", "--detach"}},
+		{args: []string{"git", "merge", "git-clone/src/fork/feature"}},
+		{args: []string{"git", "config", "gc.auto", "0"}, want: true},
+		{args: []string{"git", "config", "--worktree", "extensions.partialClone", "origin"}},
+		{args: []string{"git", "config", "--get", "remote.origin.url"}},
+		{args: []string{"git", "submodule", "update", "--init", "--recursive"}, want: true},
+		{args: []string{"git", "submodule", "update", "--recursive"}},
+		{args: []string{"git", "submodule", "foreach", "git", "reset"}},
+		{args: []string{"git", "rev-parse", "HEAD"}},
+		{args: []string{"echo", "fetch"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			assert.Equal(t, tt.want, changesSharedRepository(tt.args))
+		})
+	}
+}
+
+func Test_withoutHistoryLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         Config
+		wantLimited bool
+	}{
+		{name: "default depth", cfg: Config{}},
+		{name: "full depth", cfg: Config{CloneDepth: -1}},
+		{name: "depth", cfg: Config{CloneDepth: 10}, wantLimited: true},
+		{name: "shallow since", cfg: Config{ShallowSince: "2024-01-01"}, wantLimited: true},
+		{name: "shallow exclude", cfg: Config{ShallowExclude: []string{"v1.0.0"}}, wantLimited: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, limited := withoutHistoryLimit(tt.cfg)
+
+			assert.Equal(t, tt.wantLimited, limited)
+			assert.Equal(t, -1, cfg.CloneDepth)
+			assert.Equal(t, historyLimit{}, newHistoryLimit(cfg))
+		})
+	}
+}
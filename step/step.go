@@ -2,6 +2,11 @@ package step
 
 import (
 	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-steputils/v2/stepconf"
 	"github.com/bitrise-io/go-utils/retry"
@@ -12,6 +17,7 @@ import (
 	"github.com/bitrise-io/go-utils/v2/pathutil"
 	"github.com/bitrise-steplib/steps-git-clone/gitclone"
 	"github.com/bitrise-steplib/steps-git-clone/gitclone/bitriseapi"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/repourl"
 	"github.com/bitrise-steplib/steps-git-clone/gitclone/tracker"
 	"github.com/bitrise-steplib/steps-git-clone/transport"
 )
@@ -24,11 +30,24 @@ type Input struct {
 
 	CloneIntoDir               string   `env:"clone_into_dir,required"`
 	CloneDepth                 int      `env:"clone_depth"`
+	CloneShallowSince          string   `env:"clone_shallow_since"`
+	CloneShallowExclude        []string `env:"clone_shallow_exclude,multiline"`
 	UpdateSubmodules           bool     `env:"update_submodules,opt[yes,no]"`
 	SubmoduleUpdateDepth       int      `env:"submodule_update_depth"`
 	FetchTags                  bool     `env:"fetch_tags,opt[yes,no]"`
 	SparseDirectories          []string `env:"sparse_directories,multiline"`
 	IgnoreBranchForCommitFetch bool     `env:"ignore_branch_for_commit_fetch,opt[yes,no]"`
+	UnshallowDeepenSteps       string   `env:"unshallow_deepen_steps"`
+	WorktreeCacheDir           string   `env:"worktree_cache_dir"`
+	AdditionalRepositories     string   `env:"additional_repositories"`
+
+	FetchTimeout           int `env:"fetch_timeout"`
+	CheckoutTimeout        int `env:"checkout_timeout"`
+	MergeTimeout           int `env:"merge_timeout"`
+	SubmoduleUpdateTimeout int `env:"submodule_update_timeout"`
+	LowSpeedLimit          int `env:"low_speed_limit"`
+	LowSpeedTime           int `env:"low_speed_time"`
+	WorkspaceLockTimeout   int `env:"workspace_lock_timeout"`
 
 	RepositoryURL           string `env:"repository_url,required"`
 	Commit                  string `env:"commit"`
@@ -40,17 +59,45 @@ type Input struct {
 	PRUnverifiedMergeBranch string `env:"pull_request_unverified_merge_branch"`
 	PRHeadBranch            string `env:"pull_request_head_branch"`
 
-	ResetRepository       bool   `env:"reset_repository,opt[Yes,No]"`
-	PerformanceMonitoring bool   `env:"performance_monitoring,opt[yes,no]"`
-	BuildURL              string `env:"build_url"`
-	BuildAPIToken         string `env:"build_api_token"`
+	ExportCommitRange    bool     `env:"export_commit_range,opt[yes,no]"`
+	CommitRangeBase      string   `env:"commit_range_base"`
+	ExportChangedFiles   bool     `env:"export_changed_files,opt[yes,no]"`
+	ChangedFilesPatterns []string `env:"changed_files_patterns,multiline"`
+	ExportVersionInfo    bool     `env:"export_version_info,opt[yes,no]"`
+	VersionTagPattern    string   `env:"version_tag_pattern"`
+
+	SignaturePolicy         string `env:"signature_policy,opt[off,warn,require]"`
+	SignatureKeyring        string `env:"signature_gpg_keyring"`
+	SignatureAllowedSigners string `env:"signature_ssh_allowed_signers"`
+
+	CommitOutputDefinitions []string `env:"commit_outputs,multiline"`
+	CommitTrailers          []string `env:"commit_trailers,multiline"`
+	IssueKeyPattern         string   `env:"issue_key_pattern"`
+	ScanCommitRange         bool     `env:"scan_commit_range,opt[yes,no]"`
+	OutputFilesDir          string   `env:"output_files_dir"`
+
+	ResetRepository       bool     `env:"reset_repository,opt[Yes,No]"`
+	RemoteMismatchPolicy  string   `env:"remote_mismatch_policy,opt[fail,update,reclone]"`
+	NonEmptyDirPolicy     string   `env:"non_empty_dir_policy,opt[warn,fail,wipe,backup]"`
+	CloneDirBlocklist     []string `env:"clone_dir_blocklist,multiline"`
+	PerformanceMonitoring bool     `env:"performance_monitoring,opt[yes,no]"`
+	BuildURL              string   `env:"build_url"`
+	BuildAPIToken         string   `env:"build_api_token"`
 }
 
 // Config is the git clone step configuration
 type Config struct {
 	Input
+	CommitOutputs  []gitclone.CommitOutput
+	IssueKeyRegexp *regexp.Regexp
+	DeepenSteps    []int
+
+	AdditionalRepositories []gitclone.AdditionalRepository
 }
 
+// urlPattern matches the URLs in a YAML (or JSON) input value, so their credentials can be redacted
+var urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"',\]}]+`)
+
 type GitCloneStep struct {
 	logger       log.Logger
 	tracker      tracker.StepTracker
@@ -76,9 +123,14 @@ func (g GitCloneStep) ProcessConfig() (Config, error) {
 	if err := g.inputParser.Parse(&input); err != nil {
 		return Config{}, fmt.Errorf("Error: %s\n", err)
 	}
-	stepconf.Print(input)
+	// The repository URLs might contain credentials
+	printedInput := input
+	printedInput.RepositoryURL = repourl.Redact(input.RepositoryURL)
+	printedInput.PRSourceRepositoryURL = repourl.Redact(input.PRSourceRepositoryURL)
+	printedInput.AdditionalRepositories = urlPattern.ReplaceAllStringFunc(input.AdditionalRepositories, repourl.Redact)
+	stepconf.Print(printedInput)
 
-	if g.isCloneDirDangerous(input.CloneIntoDir) && g.envRepo.Get("BITRISE_GIT_CLONE_FORCE_RUN") != "true" {
+	if g.isCloneDirDangerous(input.CloneIntoDir, input.CloneDirBlocklist) && g.envRepo.Get("BITRISE_GIT_CLONE_FORCE_RUN") != "true" {
 		g.logger.Println()
 		g.logger.Println()
 		g.logger.Errorf("BEWARE: The git clone directory is set to %s", input.CloneIntoDir)
@@ -87,19 +139,123 @@ func (g GitCloneStep) ProcessConfig() (Config, error) {
 		g.logger.Printf("1. Change the %s step input", colorstring.Cyan("clone_into_dir"))
 		g.logger.Printf("2. If not specified, %s defaults to %s. Check the value of this env var.", colorstring.Cyan("clone_into_dir"), colorstring.Cyan("$BITRISE_SOURCE_DIR"))
 		g.logger.Printf("3. When using self-hosted agents, you can customize %s and other important values in the %s file.", colorstring.Cyan("$BITRISE_SOURCE_DIR"), colorstring.Cyan("~/.bitrise/agent-config.yml"))
+		g.logger.Printf("The directory is blocked if it is (or it is a parent of, or a symlink to) one of the built-in blocked directories or the %s step input.", colorstring.Cyan("clone_dir_blocklist"))
 		g.logger.Printf("If you are sure you want to proceed, you can set the %s env var to force the step to run.", colorstring.Cyan("BITRISE_GIT_CLONE_FORCE_RUN=true"))
 
 		return Config{}, fmt.Errorf("dangerous clone directory detected")
 	}
 
-	return Config{input}, nil
+	var shallowExclude []string
+	for _, ref := range input.CloneShallowExclude {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			shallowExclude = append(shallowExclude, ref)
+		}
+	}
+	input.CloneShallowExclude = shallowExclude
+	input.CloneShallowSince = strings.TrimSpace(input.CloneShallowSince)
+
+	if input.CloneDepth != 0 && (input.CloneShallowSince != "" || len(input.CloneShallowExclude) > 0) {
+		return Config{}, fmt.Errorf("clone_depth can not be combined with clone_shallow_since or clone_shallow_exclude")
+	}
+
+	commitOutputs, err := gitclone.ParseCommitOutputs(input.CommitOutputDefinitions)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid commit_outputs input: %w", err)
+	}
+
+	commitTrailers, err := gitclone.ParseCommitTrailers(input.CommitTrailers)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid commit_trailers input: %w", err)
+	}
+	input.CommitTrailers = commitTrailers
+
+	var issueKeyRegexp *regexp.Regexp
+	if input.IssueKeyPattern != "" {
+		if issueKeyRegexp, err = regexp.Compile(input.IssueKeyPattern); err != nil {
+			return Config{}, fmt.Errorf("invalid issue_key_pattern input: %w", err)
+		}
+	}
+
+	deepenSteps, err := parseDeepenSteps(input.UnshallowDeepenSteps)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid unshallow_deepen_steps input: %w", err)
+	}
+
+	additionalRepositories, err := gitclone.ParseAdditionalRepositories(input.AdditionalRepositories)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid additional_repositories input: %w", err)
+	}
+	if err := g.validateAdditionalRepositoryDirs(input.CloneIntoDir, input.CloneDirBlocklist, additionalRepositories); err != nil {
+		return Config{}, fmt.Errorf("invalid additional_repositories input: %w", err)
+	}
+
+	return Config{
+		Input:                  input,
+		CommitOutputs:          commitOutputs,
+		IssueKeyRegexp:         issueKeyRegexp,
+		DeepenSteps:            deepenSteps,
+		AdditionalRepositories: additionalRepositories,
+	}, nil
+}
+
+// parseDeepenSteps parses the comma separated list of deepen steps, for example 50,200,1000
+func parseDeepenSteps(value string) ([]int, error) {
+	var steps []int
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		step, err := strconv.Atoi(item)
+		if err != nil || step <= 0 {
+			return nil, fmt.Errorf("%s is not a positive integer", item)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// validateAdditionalRepositoryDirs checks that the additional repositories are not checked out
+// into (or inside) the clone directory of the main repository or into a dangerous directory
+func (g GitCloneStep) validateAdditionalRepositoryDirs(cloneIntoDir string, blocklist []string, repos []gitclone.AdditionalRepository) error {
+	absCloneIntoDir, err := g.pathModifier.AbsPath(cloneIntoDir)
+	if err != nil {
+		absCloneIntoDir = cloneIntoDir
+	}
+
+	for _, repo := range repos {
+		if absDir, err := g.pathModifier.AbsPath(repo.Dir); err == nil && isSameOrNestedDir(absDir, absCloneIntoDir) {
+			// The clean of the main repository would delete the nested repository, and it would show up as untracked files
+			return fmt.Errorf("%s is checked out into the clone directory of the main repository (%s)", repo.Name, cloneIntoDir)
+		}
+		if g.isCloneDirDangerous(repo.Dir, blocklist) && g.envRepo.Get("BITRISE_GIT_CLONE_FORCE_RUN") != "true" {
+			return fmt.Errorf("the directory of %s is set to %s, which is probably not what you want, as the step could overwrite files in the directory", repo.Name, repo.Dir)
+		}
+	}
+
+	return nil
+}
+
+// isSameOrNestedDir reports whether dir is the parent directory or one of its subdirectories
+func isSameOrNestedDir(dir, parent string) bool {
+	rel, err := filepath.Rel(parent, dir)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
 }
 
 func (g GitCloneStep) Run(cfg Config) (gitclone.CheckoutStateResult, error) {
+	var additionalURLs []string
+	for _, repo := range cfg.AdditionalRepositories {
+		additionalURLs = append(additionalURLs, repo.URL)
+	}
 	if err := transport.Setup(transport.Config{
-		URL:          cfg.RepositoryURL,
-		HTTPUsername: cfg.GitHTTPUsername,
-		HTTPPassword: cfg.GitHTTPPassword,
+		URL:            cfg.RepositoryURL,
+		AdditionalURLs: additionalURLs,
+		HTTPUsername:   cfg.GitHTTPUsername,
+		HTTPPassword:   cfg.GitHTTPPassword,
 	}); err != nil {
 		return gitclone.CheckoutStateResult{}, err
 	}
@@ -119,10 +275,52 @@ func (g GitCloneStep) ExportOutputs(runResult gitclone.CheckoutStateResult) erro
 		return err
 	}
 
+	if err := exporter.ExportPRMergeInfo(); err != nil {
+		return err
+	}
+
+	if err := exporter.ExportSignature(); err != nil {
+		return err
+	}
+
+	if err := exporter.ExportPhaseTimings(); err != nil {
+		return err
+	}
+
+	if err := exporter.ExportCheckoutReport(); err != nil {
+		return err
+	}
+
+	if err := exporter.ExportCommitRange(); err != nil {
+		return err
+	}
+
+	if err := exporter.ExportChangedFiles(); err != nil {
+		return err
+	}
+
+	if err := exporter.ExportVersionInfo(); err != nil {
+		return err
+	}
+
+	if err := exporter.ExportCommitMessageDetails(); err != nil {
+		return err
+	}
+
+	if err := exporter.ExportAdditionalRepositories(); err != nil {
+		return err
+	}
+
+	if err := exporter.ExportOutputFiles(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (g GitCloneStep) isCloneDirDangerous(path string) bool {
+// isCloneDirDangerous reports whether the clone directory is a blocklisted directory, an ancestor of one (including the filesystem root),
+// or a symlink resolving to one of these. extraBlocklist extends the built-in blocklist.
+func (g GitCloneStep) isCloneDirDangerous(path string, extraBlocklist []string) bool {
 	blocklist := []string{
 		"~",
 		"~/Downloads",
@@ -138,6 +336,7 @@ func (g GitCloneStep) isCloneDirDangerous(path string) bool {
 		"~/.bitrise",
 		"~/.ssh",
 	}
+	blocklist = append(blocklist, extraBlocklist...)
 
 	absClonePath, err := g.pathModifier.AbsPath(path)
 	if err != nil {
@@ -146,32 +345,83 @@ func (g GitCloneStep) isCloneDirDangerous(path string) bool {
 		// A true positive will be caught by the git command anyway.
 		return false
 	}
+	clonePaths := []string{absClonePath, resolveSymlinks(absClonePath)}
+
+	for _, clonePath := range clonePaths {
+		if filepath.Dir(clonePath) == clonePath {
+			// The filesystem root
+			return true
+		}
+	}
 
 	for _, dangerousPath := range blocklist {
+		dangerousPath = strings.TrimSpace(dangerousPath)
+		if dangerousPath == "" {
+			continue
+		}
 		absDangerousPath, err := g.pathModifier.AbsPath(dangerousPath)
 		if err != nil {
 			// Not all blocklisted paths are valid on all systems, so we ignore this error.
 			continue
 		}
 
-		if absClonePath == absDangerousPath {
-			return true
+		for _, clonePath := range clonePaths {
+			for _, blockedPath := range []string{absDangerousPath, resolveSymlinks(absDangerousPath)} {
+				if clonePath == blockedPath || isAncestorDir(clonePath, blockedPath) {
+					return true
+				}
+			}
 		}
 	}
 
 	return false
 }
 
+// resolveSymlinks resolves the symlinks of the absolute path. The part of the path which doesn't exist yet is kept as is.
+func resolveSymlinks(path string) string {
+	existing := path
+	var missing []string
+	for {
+		if resolved, err := filepath.EvalSymlinks(existing); err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...)
+		}
+
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return path
+		}
+		missing = append([]string{filepath.Base(existing)}, missing...)
+		existing = parent
+	}
+}
+
+// isAncestorDir reports whether path is inside dir (at any depth)
+func isAncestorDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func convertConfig(config Config) gitclone.Config {
 	return gitclone.Config{
 		ShouldMergePR:              config.ShouldMergePR,
 		CloneIntoDir:               config.CloneIntoDir,
 		CloneDepth:                 config.CloneDepth,
+		ShallowSince:               config.CloneShallowSince,
+		ShallowExclude:             config.CloneShallowExclude,
 		UpdateSubmodules:           config.UpdateSubmodules,
 		SubmoduleUpdateDepth:       config.SubmoduleUpdateDepth,
 		FetchTags:                  config.FetchTags,
 		SparseDirectories:          config.SparseDirectories,
 		IgnoreBranchForCommitFetch: config.IgnoreBranchForCommitFetch,
+		DeepenSteps:                config.DeepenSteps,
+		WorktreeCacheDir:           config.WorktreeCacheDir,
+		CommandTimeouts:            commandTimeouts(config),
+		LowSpeedLimit:              config.LowSpeedLimit,
+		LowSpeedTime:               config.LowSpeedTime,
+		WorkspaceLockTimeout:       time.Duration(config.WorkspaceLockTimeout) * time.Second,
 		RepositoryURL:              config.RepositoryURL,
 		Commit:                     config.Commit,
 		Tag:                        config.Tag,
@@ -181,6 +431,32 @@ func convertConfig(config Config) gitclone.Config {
 		PRMergeRef:                 config.PRMergeBranch,
 		PRUnverifiedMergeRef:       config.PRUnverifiedMergeBranch,
 		PRHeadBranch:               config.PRHeadBranch,
+		ExportCommitRange:          config.ExportCommitRange,
+		CommitRangeBase:            config.CommitRangeBase,
+		ExportChangedFiles:         config.ExportChangedFiles,
+		ChangedFilesPatterns:       config.ChangedFilesPatterns,
+		ExportVersionInfo:          config.ExportVersionInfo,
+		VersionTagPattern:          config.VersionTagPattern,
+		SignaturePolicy:            gitclone.SignaturePolicy(config.SignaturePolicy),
+		SignatureKeyring:           config.SignatureKeyring,
+		SignatureAllowedSigners:    config.SignatureAllowedSigners,
+		CommitOutputs:              config.CommitOutputs,
+		CommitTrailers:             config.CommitTrailers,
+		IssueKeyPattern:            config.IssueKeyRegexp,
+		ScanCommitRange:            config.ScanCommitRange,
+		OutputFilesDir:             config.OutputFilesDir,
 		ResetRepository:            config.ResetRepository,
+		RemoteMismatchPolicy:       gitclone.RemoteMismatchPolicy(config.RemoteMismatchPolicy),
+		NonEmptyDirPolicy:          gitclone.NonEmptyDirPolicy(config.NonEmptyDirPolicy),
+		AdditionalRepositories:     config.AdditionalRepositories,
+	}
+}
+
+func commandTimeouts(config Config) gitclone.CommandTimeouts {
+	return gitclone.CommandTimeouts{
+		Fetch:           time.Duration(config.FetchTimeout) * time.Second,
+		Checkout:        time.Duration(config.CheckoutTimeout) * time.Second,
+		Merge:           time.Duration(config.MergeTimeout) * time.Second,
+		SubmoduleUpdate: time.Duration(config.SubmoduleUpdateTimeout) * time.Second,
 	}
 }
@@ -17,11 +17,15 @@ import (
 func Test_GitCloneStep_IsCloneDirDangerous(t *testing.T) {
 	home, err := os.UserHomeDir()
 	require.NoError(t, err)
+	tmpDir := t.TempDir()
+	homeLink := filepath.Join(tmpDir, "home-link")
+	require.NoError(t, os.Symlink(home, homeLink))
 
 	tests := []struct {
-		name     string
-		path     string
-		expected bool
+		name      string
+		path      string
+		blocklist []string
+		expected  bool
 	}{
 		{
 			name:     "Safe path in temp dir",
@@ -63,6 +67,43 @@ func Test_GitCloneStep_IsCloneDirDangerous(t *testing.T) {
 			path:     "$NONEXISTENT",
 			expected: false,
 		},
+		{
+			name:     "Filesystem root",
+			path:     "/",
+			expected: true,
+		},
+		{
+			name:     "Parent of home",
+			path:     filepath.Dir(home),
+			expected: true,
+		},
+		{
+			name:     "Symlink to home",
+			path:     homeLink,
+			expected: true,
+		},
+		{
+			name:     "Symlink into a dangerous path",
+			path:     filepath.Join(homeLink, ".ssh"),
+			expected: true,
+		},
+		{
+			name:     "Safe path through a symlink",
+			path:     filepath.Join(homeLink, "clone"),
+			expected: false,
+		},
+		{
+			name:      "Blocklisted by the configuration",
+			path:      filepath.Join(tmpDir, "shared"),
+			blocklist: []string{"", filepath.Join(tmpDir, "shared")},
+			expected:  true,
+		},
+		{
+			name:      "Parent of a path blocklisted by the configuration",
+			path:      tmpDir,
+			blocklist: []string{filepath.Join(tmpDir, "shared", "cache")},
+			expected:  true,
+		},
 	}
 
 	logger := log.NewLogger()
@@ -76,8 +117,52 @@ func Test_GitCloneStep_IsCloneDirDangerous(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			result := gitCloneStep.isCloneDirDangerous(test.path)
+			result := gitCloneStep.isCloneDirDangerous(test.path, test.blocklist)
 			require.Equal(t, test.expected, result)
 		})
 	}
 }
+
+func Test_parseDeepenSteps(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []int
+		wantErr bool
+	}{
+		{name: "Empty", value: "", want: nil},
+		{name: "Steps", value: "50, 200,1000", want: []int{50, 200, 1000}},
+		{name: "Not a number", value: "50,all", wantErr: true},
+		{name: "Not positive", value: "0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDeepenSteps(tt.value)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_isSameOrNestedDir(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{dir: "/src/app", want: true},
+		{dir: "/src/app/vendor/sdk", want: true},
+		{dir: "/src/sdk", want: false},
+		{dir: "/src/app-sdk", want: false},
+		{dir: "/src", want: false},
+		{dir: "/src/..app", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			require.Equal(t, tt.want, isSameOrNestedDir(tt.dir, "/src/app"))
+		})
+	}
+}
@@ -2,34 +2,55 @@ package transport
 
 import (
 	"fmt"
-	"net/url"
-	"strings"
 
+	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-steplib/steps-authenticate-host-with-netrc/netrcutil"
+	"github.com/bitrise-steplib/steps-git-clone/gitclone/repourl"
 )
 
 type Config struct {
-	URL          string
-	HTTPUsername string
-	HTTPPassword string
+	URL string
+	// AdditionalURLs are the repositories checked out with the same credentials as the main repository
+	AdditionalURLs []string
+	HTTPUsername   string
+	HTTPPassword   string
 }
 
 func Setup(cfg Config) error {
-	// We only deal with http URLs for now
-	if !strings.HasPrefix(cfg.URL, "http") {
-		return nil
-	}
-
 	// Setup is a no-op if no password is provided
 	if cfg.HTTPPassword == "" {
 		return nil
 	}
 
-	url, err := url.Parse(cfg.URL)
+	// The credentials belong to the main repository, they are only used for the additional repositories on the same host.
+	// The netrc machine is the hostname without the port.
+	mainRepo, err := repourl.Parse(cfg.URL)
 	if err != nil {
-		return fmt.Errorf("failed to parse URL: %w", err)
+		log.Debugf("Skipping the .netrc setup of %s: %s", repourl.Redact(cfg.URL), err)
+		return nil
 	}
-	host := url.Host
+	host := ""
+	for _, url := range append([]string{cfg.URL}, cfg.AdditionalURLs...) {
+		repo, err := repourl.Parse(url)
+		if err != nil {
+			log.Debugf("Skipping the .netrc setup of %s: %s", repourl.Redact(url), err)
+			continue
+		}
+		// We only deal with http URLs for now
+		if !repo.Transport.IsHTTP() {
+			log.Debugf("Skipping the .netrc setup of %s: not an HTTP(S) URL", repourl.Redact(url))
+			continue
+		}
+		if repo.Host != mainRepo.Host {
+			log.Debugf("Skipping the .netrc setup of %s: not on the host of the main repository (%s)", repourl.Redact(url), mainRepo.Host)
+			continue
+		}
+		host = repo.Host
+	}
+	if host == "" {
+		return nil
+	}
+
 	username := cfg.HTTPUsername
 	// Some providers (e.g. GitHub) doesn't care about the username, so we don't ask for it from the user
 	// But something still needs to be provided when making the network call
@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		wantNetrc string
+	}{
+		{
+			name:      "HTTPS URL",
+			cfg:       Config{URL: "https://github.com/bitrise-io/git-clone-test.git", HTTPUsername: "user", HTTPPassword: "secret"},
+			wantNetrc: "machine github.com\n\tlogin user\n\tpassword secret\n",
+		},
+		{
+			name: "Additional repositories on the same host",
+			cfg: Config{
+				URL:            "git@github.com:bitrise-io/git-clone-test.git",
+				AdditionalURLs: []string{"https://github.com/bitrise-io/sdk.git", "https://gitlab.com/bitrise-io/assets.git"},
+				HTTPPassword:   "secret",
+			},
+			wantNetrc: "machine github.com\n\tlogin bitrise-git-clone-step\n\tpassword secret\n",
+		},
+		{
+			name: "SSH URL",
+			cfg:  Config{URL: "git@github.com:bitrise-io/git-clone-test.git", HTTPPassword: "secret"},
+		},
+		{
+			name: "Unparseable URL",
+			cfg:  Config{URL: "https://github.com:port/bitrise-io/git-clone-test.git", HTTPPassword: "secret"},
+		},
+		{
+			name: "No password",
+			cfg:  Config{URL: "https://github.com/bitrise-io/git-clone-test.git"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+
+			require.NoError(t, Setup(tt.cfg))
+
+			content, err := os.ReadFile(filepath.Join(home, ".netrc"))
+			if tt.wantNetrc == "" {
+				assert.True(t, os.IsNotExist(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Contains(t, string(content), tt.wantNetrc)
+			assert.NotContains(t, string(content), "gitlab.com")
+		})
+	}
+}